@@ -0,0 +1,231 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// aliasParamPattern matches the placeholders for named alias parameters, e.g.
+// ${tenant}. Placeholders for piped input (${1.id}) start with a digit and are
+// left for the input transformation.
+var aliasParamPattern = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_-]*)\}`)
+
+// alias is a named request from the aliases section of the config. The path,
+// query, headers and body may contain placeholders for its params.
+type alias struct {
+	Verb    string                `json:"verb" mapstructure:"verb"`
+	Path    string                `json:"path" mapstructure:"path"`
+	Query   string                `json:"query,omitempty" mapstructure:"query"`
+	Headers []string              `json:"headers,omitempty" mapstructure:"headers"`
+	Body    string                `json:"body,omitempty" mapstructure:"body"`
+	Help    string                `json:"help,omitempty" mapstructure:"help"`
+	Params  map[string]aliasParam `json:"params,omitempty" mapstructure:"params"`
+}
+
+// aliasParam is a named parameter of an alias which is given as a flag.
+type aliasParam struct {
+	Help    string `json:"help,omitempty" mapstructure:"help"`
+	Default string `json:"default,omitempty" mapstructure:"default"`
+}
+
+// loadAliases reads the aliases section of the config.
+func loadAliases() (map[string]alias, error) {
+	aliases := map[string]alias{}
+	if err := viper.UnmarshalKey("aliases", &aliases); err != nil {
+		return nil, fmt.Errorf("invalid aliases config: %v", err)
+	}
+	return aliases, nil
+}
+
+// aliasVerbAnnotation is the annotation of alias commands holding the HTTP verb
+// they run.
+const aliasVerbAnnotation = "jaq_alias_verb"
+
+// ResetSettingsAliases adds a command for each alias in the config next to the
+// HTTP verbs. Aliases which would replace an existing command, or have params
+// which clash with the global flags, are not added and are returned as an
+// error.
+func ResetSettingsAliases() error {
+	aliases, err := loadAliases()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(aliases))
+	for n := range aliases {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	for _, n := range names {
+		if builtinCommand(n) {
+			problems = append(problems, fmt.Sprintf("alias %q would replace the %v command", n, n))
+			continue
+		}
+		if existing, _, err := RootCmd.Find([]string{n}); err == nil && existing != RootCmd {
+			continue
+		}
+
+		c, err := aliasCommand(n, aliases[n])
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		RootCmd.AddCommand(c)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid aliases config: %v", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// builtinCommand reports whether name is a command of jaq itself rather than an
+// alias.
+func builtinCommand(name string) bool {
+	if name == "help" {
+		return true
+	}
+	c, _, err := RootCmd.Find([]string{name})
+	if err != nil || c == RootCmd {
+		return false
+	}
+	_, ok := c.Annotations[aliasVerbAnnotation]
+	return !ok
+}
+
+// aliasCommand creates the command for an alias. Each param is a flag and any
+// placeholders in the alias which are not declared as params are added as
+// required flags.
+func aliasCommand(name string, a alias) (*cobra.Command, error) {
+	verb := strings.ToUpper(a.Verb)
+	if verb == "" {
+		verb = http.MethodGet
+	}
+	if !validVerb(verb) {
+		return nil, fmt.Errorf("alias %q has invalid verb %q", name, a.Verb)
+	}
+
+	params := map[string]aliasParam{}
+	for p, ap := range a.Params {
+		params[p] = ap
+	}
+	for _, s := range append([]string{a.Path, a.Query, a.Body}, a.Headers...) {
+		for _, m := range aliasParamPattern.FindAllStringSubmatch(s, -1) {
+			if _, ok := params[m[1]]; !ok {
+				params[m[1]] = aliasParam{}
+			}
+		}
+	}
+
+	paramNames := make([]string, 0, len(params))
+	for p := range params {
+		if RootCmd.PersistentFlags().Lookup(p) != nil {
+			return nil, fmt.Errorf("alias %q has param %q which clashes with the --%v flag", name, p, p)
+		}
+		paramNames = append(paramNames, p)
+	}
+	sort.Strings(paramNames)
+
+	short := a.Help
+	if short == "" {
+		short = fmt.Sprintf("Perform a %v operation to %v.", verb, a.Path)
+	}
+
+	sends := fmt.Sprintf("%v %v", verb, a.Path)
+	if a.Query != "" {
+		sends += "?" + a.Query
+	}
+	for _, h := range a.Headers {
+		sends += "\n  header: " + h
+	}
+	if a.Body != "" {
+		sends += "\n  body: " + a.Body
+	}
+
+	c := &cobra.Command{
+		Use:         name,
+		Short:       short,
+		Long:        fmt.Sprintf("%v\n\nAlias which sends:\n  %v", short, sends),
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{aliasVerbAnnotation: strings.ToLower(verb)},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			values := map[string]string{}
+			for _, p := range paramNames {
+				v, err := cmd.Flags().GetString(p)
+				if err != nil {
+					return err
+				}
+				if v == "" && !cmd.Flags().Changed(p) {
+					return fmt.Errorf("missing required flag --%v for alias %v", p, name)
+				}
+				values[p] = v
+			}
+			fill := func(s string) string {
+				return aliasParamPattern.ReplaceAllStringFunc(s, func(m string) string {
+					return values[aliasParamPattern.FindStringSubmatch(m)[1]]
+				})
+			}
+
+			conf, err := newConfig(cmd)
+			if err != nil {
+				return err
+			}
+			conf.verb = verb
+			conf.commandPath = RootCmd.Name() + " " + strings.ToLower(verb)
+
+			// Flags given on the command line add to, or override, the alias.
+			if q := fill(a.Query); q != "" {
+				if conf.query != "" {
+					q += "&" + conf.query
+				}
+				conf.query = q
+			}
+			var headers []string
+			for _, h := range a.Headers {
+				headers = append(headers, fill(h))
+			}
+			conf.headers = append(headers, conf.headers...)
+			if conf.body == "" && conf.filepath == "" {
+				conf.body = fill(a.Body)
+			}
+
+			return httpRun(conf, verb, fill(a.Path))
+		},
+	}
+
+	for _, p := range paramNames {
+		usage := params[p].Help
+		if usage == "" {
+			usage = fmt.Sprintf("Value of ${%v}", p)
+		}
+		if params[p].Default == "" {
+			usage += " (required)"
+		}
+		c.Flags().String(p, params[p].Default, usage)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,150 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestAliases(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	var got []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		got = append(got, strings.TrimSpace(req.Method+" "+req.URL.String()+" "+req.Header.Get("X-Api-Version")+" "+string(b)))
+	}))
+	defer s.Close()
+
+	aliases := map[string]interface{}{
+		"users": map[string]interface{}{
+			"verb":    "get",
+			"path":    "/v2/tenants/${tenant}/users",
+			"query":   "active=${active}",
+			"headers": []string{"X-Api-Version=2"},
+			"help":    "List the users of a tenant",
+			"params": map[string]interface{}{
+				"tenant": map[string]interface{}{"help": "Tenant to list users of"},
+				"active": map[string]interface{}{"default": "true"},
+			},
+		},
+		"rename": map[string]interface{}{
+			"verb": "patch",
+			"path": "/users/${id}",
+			"body": `{"name":"${name}"}`,
+		},
+	}
+
+	testCases := []struct {
+		desc    string
+		args    []string
+		input   string
+		aliases map[string]interface{}
+
+		expectRequests []string
+		expectStdout   string
+		expectErr      string
+	}{
+		{
+			desc:           "params and defaults",
+			args:           []string{"users", "--tenant", "acme"},
+			expectRequests: []string{"GET /v2/tenants/acme/users?active=true 2"},
+			expectStdout:   "\n",
+		}, {
+			desc:           "flags add to the alias",
+			args:           []string{"users", "--tenant", "acme", "--active=false", "-q", "page=2", "--header", "X-Api-Version=3"},
+			expectRequests: []string{"GET /v2/tenants/acme/users?active=false&page=2 3"},
+			expectStdout:   "\n",
+		}, {
+			desc:           "undeclared placeholders are params",
+			args:           []string{"rename", "--id", "1", "--name", "a"},
+			expectRequests: []string{`PATCH /users/1  {"name":"a"}`},
+			expectStdout:   "\n",
+		}, {
+			desc:           "piped input",
+			args:           []string{"rename", "--id", "${1.id}", "--name", "${1.name}"},
+			input:          `{"id":1,"name":"a"} {"id":2,"name":"b"}`,
+			expectRequests: []string{`PATCH /users/1  {"name":"a"}`, `PATCH /users/2  {"name":"b"}`},
+			expectStdout:   "\n\n",
+		}, {
+			desc:         "dry-run shows the expanded command",
+			args:         []string{"users", "--tenant", "acme", "--dry-run=jaq"},
+			expectStdout: "jaq get /v2/tenants/acme/users --query active=true --header X-Api-Version=2\n",
+		}, {
+			desc:      "missing param",
+			args:      []string{"users"},
+			expectErr: "missing required flag --tenant for alias users",
+		}, {
+			desc:      "alias may not replace a command",
+			args:      []string{"users", "--tenant", "acme"},
+			aliases:   map[string]interface{}{"get": map[string]interface{}{"path": "/"}, "post-it": map[string]interface{}{"verb": "send", "path": "/"}},
+			expectErr: `invalid aliases config: alias "get" would replace the get command; alias "post-it" has invalid verb "send"`,
+		}, {
+			desc:      "param may not clash with a flag",
+			args:      []string{"users", "--tenant", "acme"},
+			aliases:   map[string]interface{}{"find": map[string]interface{}{"path": "/${query}"}},
+			expectErr: `invalid aliases config: alias "find" has param "query" which clashes with the --query flag`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ResetSettings()
+			viper.Set("scheme", "http")
+			viper.Set("domain", s.Listener.Addr().String())
+			viper.Set("aliases", aliases)
+			if tc.aliases != nil {
+				viper.Set("aliases", tc.aliases)
+			}
+			got = nil
+
+			var input io.Reader
+			if tc.input != "" {
+				input = strings.NewReader(tc.input)
+			}
+
+			stdout, _, err := captureOutput(execute, tc.args, input)
+			switch {
+			case err == nil && tc.expectErr != "":
+				t.Errorf("Expected error %q but got none", tc.expectErr)
+			case err != nil && err.Error() != tc.expectErr:
+				t.Errorf("Expected error %q, got %q", tc.expectErr, err)
+			}
+
+			if !reflect.DeepEqual(got, tc.expectRequests) {
+				t.Errorf("Expected requests %q, got %q", tc.expectRequests, got)
+			}
+			if stdout != tc.expectStdout {
+				t.Errorf("Expected stdout %q, got %q", tc.expectStdout, stdout)
+			}
+		})
+	}
+}
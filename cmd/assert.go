@@ -0,0 +1,280 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Ericsson/jaq/schema"
+	"github.com/Jeffail/gabs"
+)
+
+// checkResults collects the outcome of every request which was checked against
+// expectations during a run so they can be written as a test report. It is
+// reset at the start of each execute.
+var checkResults []checkResult
+
+// checkResult is the outcome of a single request. It is considered passed if
+// there are no failures.
+type checkResult struct {
+	Name     string
+	Status   int
+	Duration time.Duration
+	Failures []string
+}
+
+// expectations are the assertions made about each response.
+type expectations struct {
+	statuses []statusRule
+	fields   []fieldExpectation
+	schema   *schema.Schema
+
+	// report is set if results should be collected even without explicit
+	// expectations.
+	report bool
+}
+
+// fieldExpectation asserts that the value at path compares to value with op,
+// either == or !=.
+type fieldExpectation struct {
+	path, op, value string
+}
+
+// newExpectations parses the --expect-status, --expect and --expect-schema
+// values.
+func newExpectations(statuses, fields []string, schemaPath string, report bool) (expectations, error) {
+	e := expectations{report: report}
+
+	for _, s := range statuses {
+		low, high, err := parseStatusCodes(strings.ToLower(strings.TrimSpace(s)))
+		if err != nil {
+			return e, fmt.Errorf("invalid expected status %q, expected a code (200), class (2xx) or range (200-204)", s)
+		}
+		e.statuses = append(e.statuses, statusRule{low: low, high: high})
+	}
+
+	for _, f := range fields {
+		fe, err := parseFieldExpectation(f)
+		if err != nil {
+			return e, err
+		}
+		e.fields = append(e.fields, fe)
+	}
+
+	if schemaPath != "" {
+		s, err := schema.Load(schemaPath)
+		if err != nil {
+			return e, err
+		}
+		e.schema = s
+	}
+
+	return e, nil
+}
+
+func parseFieldExpectation(s string) (fieldExpectation, error) {
+	for _, op := range []string{"==", "!="} {
+		if parts := strings.SplitN(s, op, 2); len(parts) == 2 {
+			return fieldExpectation{path: strings.TrimSpace(parts[0]), op: op, value: strings.TrimSpace(parts[1])}, nil
+		}
+	}
+	return fieldExpectation{}, fmt.Errorf("invalid expectation %q, expected PATH==VALUE or PATH!=VALUE", s)
+}
+
+// enabled is true if responses need to be checked.
+func (e expectations) enabled() bool {
+	return e.report || len(e.statuses) > 0 || e.needsBody()
+}
+
+// needsBody is true if the response body must be read to check it.
+func (e expectations) needsBody() bool {
+	return len(e.fields) > 0 || e.schema != nil
+}
+
+// check returns a description of each expectation the response did not meet.
+// Without any explicit status expectations, the request must have gotten a
+// response with a code < 400.
+func (e expectations) check(status int, err error, body []byte) []string {
+	var failures []string
+
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	if len(e.statuses) == 0 {
+		if status >= 400 {
+			failures = append(failures, fmt.Sprintf("unexpected status %v", status))
+		}
+	} else {
+		matched := false
+		for _, r := range e.statuses {
+			if status >= r.low && status <= r.high {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			failures = append(failures, fmt.Sprintf("status %v does not match expected status", status))
+		}
+	}
+
+	if !e.needsBody() {
+		return failures
+	}
+
+	parsed, parseErr := gabs.ParseJSON(body)
+	if parseErr != nil {
+		return append(failures, fmt.Sprintf("unable to parse response as JSON: %v", parseErr))
+	}
+
+	for _, f := range e.fields {
+		if msg := f.check(parsed); msg != "" {
+			failures = append(failures, msg)
+		}
+	}
+
+	if e.schema != nil {
+		for _, v := range e.schema.Validate(parsed.Data()) {
+			failures = append(failures, "schema: "+v.Error())
+		}
+	}
+
+	return failures
+}
+
+// check returns a description of the failure or the empty string if the
+// expectation was met. The expected value is compared as JSON if it parses as
+// JSON, otherwise as a string.
+func (f fieldExpectation) check(parsed *gabs.Container) string {
+	actual := parsed.Path(f.path).Data()
+
+	var expected interface{}
+	equal := false
+	if err := json.Unmarshal([]byte(f.value), &expected); err == nil {
+		equal = reflect.DeepEqual(actual, expected)
+	} else {
+		equal = actual != nil && fmt.Sprint(actual) == f.value
+	}
+
+	if equal == (f.op == "==") {
+		return ""
+	}
+
+	b, _ := json.Marshal(actual)
+	return fmt.Sprintf("expected %v%v%v but got %s", f.path, f.op, f.value, b)
+}
+
+// writeReport writes the collected results to path in the given format; either
+// junit or tap. If no format is given it is guessed from the file extension.
+func writeReport(path, format string, results []checkResult) error {
+	if format == "" {
+		format = "junit"
+		if filepath.Ext(path) == ".tap" {
+			format = "tap"
+		}
+	}
+
+	write := writeJUnit
+	switch format {
+	case "junit":
+	case "tap":
+		write = writeTAP
+	default:
+		return fmt.Errorf("invalid report format %q, expected junit or tap", format)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return write(f, results)
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     float64     `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Details string `xml:",chardata"`
+}
+
+func writeJUnit(w io.Writer, results []checkResult) error {
+	suite := junitSuite{Name: "jaq", Tests: len(results)}
+	for _, r := range results {
+		c := junitCase{Name: r.Name, ClassName: "jaq", Time: r.Duration.Seconds()}
+		if len(r.Failures) > 0 {
+			suite.Failures++
+			c.Failure = &junitFailure{Message: r.Failures[0], Details: strings.Join(r.Failures, "\n")}
+		}
+		suite.Time += c.Time
+		suite.Cases = append(suite.Cases, c)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitSuites{Suites: []junitSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func writeTAP(w io.Writer, results []checkResult) error {
+	fmt.Fprintf(w, "TAP version 13\n1..%d\n", len(results))
+	for i, r := range results {
+		if len(r.Failures) == 0 {
+			fmt.Fprintf(w, "ok %d - %v\n", i+1, r.Name)
+			continue
+		}
+
+		fmt.Fprintf(w, "not ok %d - %v\n  ---\n  failures:\n", i+1, r.Name)
+		for _, f := range r.Failures {
+			b, _ := json.Marshal(f)
+			fmt.Fprintf(w, "    - %s\n", b)
+		}
+		fmt.Fprintf(w, "  ...\n")
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+var timeAttr = regexp.MustCompile(`time="[^"]*"`)
+
+func TestExpectations(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/jobs/a":
+			w.Write([]byte(`{"id":1,"status":"done"}`))
+		case "/jobs/b":
+			w.Write([]byte(`{"id":"2","status":"failed"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer s.Close()
+
+	testCases := []struct {
+		desc   string
+		args   []string
+		input  string
+		report string
+
+		expectStdout   string
+		expectStderr   []string
+		expectReport   string
+		expectFailures [][]string
+		expectExitCode int
+	}{
+		{
+			desc:           "status and field expectations pass",
+			args:           []string{"get", "/jobs/a", "--expect-status", "2xx", "--expect", `status=="done"`, "--expect", "id!=2"},
+			expectStdout:   `{"id":1,"status":"done"}` + "\n",
+			expectFailures: [][]string{nil},
+			expectExitCode: exitOK,
+		}, {
+			desc:           "expected 404 is not a failure",
+			args:           []string{"get", "/jobs/c", "--expect-status", "404", "--on-error", "silence"},
+			expectFailures: [][]string{nil},
+			expectExitCode: exitOK,
+		}, {
+			desc:         "field and schema failures",
+			args:         []string{"get", "/jobs/$1", "--expect", `status==done`, "--expect-schema", "testdata/postSchema.json"},
+			input:        "a b",
+			expectStdout: `{"id":1,"status":"done"}` + "\n" + `{"id":"2","status":"failed"}` + "\n",
+			expectStderr: []string{
+				"Expectation failed for GET http://" + s.Listener.Addr().String() + `/jobs/b: expected status==done but got "failed"`,
+				"Expectation failed for GET http://" + s.Listener.Addr().String() + "/jobs/b: schema: /id: expected type integer but got string",
+			},
+			expectFailures: [][]string{nil, {
+				`expected status==done but got "failed"`,
+				"schema: /id: expected type integer but got string",
+				`schema: /status: value "failed" is not one of ["done","running"]`,
+			}},
+			expectExitCode: exitPartialFailure,
+		}, {
+			desc:   "tap report",
+			args:   []string{"get", "/jobs/$1", "--report", filepath.Join(tmpDir, "report.tap"), "--on-error", "silence"},
+			input:  "a c",
+			report: filepath.Join(tmpDir, "report.tap"),
+			expectReport: "TAP version 13\n1..2\n" +
+				"ok 1 - GET http://" + s.Listener.Addr().String() + "/jobs/a\n" +
+				"not ok 2 - GET http://" + s.Listener.Addr().String() + "/jobs/c\n" +
+				"  ---\n  failures:\n    - \"unexpected status 404\"\n  ...\n",
+			expectStdout:   `{"id":1,"status":"done"}` + "\n",
+			expectStderr:   []string{"Expectation failed for GET http://" + s.Listener.Addr().String() + "/jobs/c: unexpected status 404"},
+			expectFailures: [][]string{nil, {"unexpected status 404"}},
+			expectExitCode: exitPartialFailure,
+		}, {
+			desc:   "junit report",
+			args:   []string{"get", "/jobs/b", "--report", filepath.Join(tmpDir, "report.xml"), "--expect", "id==2"},
+			report: filepath.Join(tmpDir, "report.xml"),
+			expectReport: `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+				`<testsuites>` + "\n" +
+				`  <testsuite name="jaq" tests="1" failures="1" time="0">` + "\n" +
+				`    <testcase name="GET http://` + s.Listener.Addr().String() + `/jobs/b" classname="jaq" time="0">` + "\n" +
+				`      <failure message="expected id==2 but got &#34;2&#34;">expected id==2 but got &#34;2&#34;</failure>` + "\n" +
+				`    </testcase>` + "\n" +
+				`  </testsuite>` + "\n" +
+				`</testsuites>` + "\n",
+			expectStdout:   `{"id":"2","status":"failed"}` + "\n",
+			expectStderr:   []string{"Expectation failed for GET http://" + s.Listener.Addr().String() + `/jobs/b: expected id==2 but got "2"`},
+			expectFailures: [][]string{{`expected id==2 but got "2"`}},
+			expectExitCode: exitAllFailed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ResetSettings()
+			viper.Set("scheme", "http")
+			viper.Set("domain", s.Listener.Addr().String())
+
+			var input io.Reader
+			if tc.input != "" {
+				input = strings.NewReader(tc.input)
+			}
+
+			stdout, stderr, err := captureOutput(execute, tc.args, input)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if stdout != tc.expectStdout {
+				t.Errorf("Expected stdout %q, got %q", tc.expectStdout, stdout)
+			}
+			for _, e := range tc.expectStderr {
+				if !strings.Contains(stderr, e) {
+					t.Errorf("Expected stderr to contain %q, got %q", e, stderr)
+				}
+			}
+			if len(tc.expectStderr) == 0 && strings.Contains(stderr, "Expectation failed") {
+				t.Errorf("Expected no failed expectations in stderr, got %q", stderr)
+			}
+
+			var failures [][]string
+			for _, r := range checkResults {
+				failures = append(failures, r.Failures)
+			}
+			if !reflect.DeepEqual(failures, tc.expectFailures) {
+				t.Errorf("Expected failures %q, got %q", tc.expectFailures, failures)
+			}
+			if code := stats.exitCode(err); code != tc.expectExitCode {
+				t.Errorf("Expected exit code %v, got %v", tc.expectExitCode, code)
+			}
+
+			if tc.report != "" {
+				b, err := ioutil.ReadFile(tc.report)
+				if err != nil {
+					t.Fatalf("Unable to read report: %v", err)
+				}
+
+				// Durations vary; zero them out for comparison.
+				report := timeAttr.ReplaceAllString(string(b), `time="0"`)
+				if report != tc.expectReport {
+					t.Errorf("Expected report %q, got %q", tc.expectReport, report)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"time"
+)
+
+// runCtx is the context of every request in a run. It is cancelled by SIGINT
+// or once the --deadline has passed.
+var runCtx = context.Background()
+
+// cancelOnInterrupt cancels the run on the first SIGINT so that in-flight
+// requests stop cleanly and the summary is still printed. A second SIGINT
+// exits immediately. The returned function stops handling the signal.
+func cancelOnInterrupt(cancel context.CancelFunc) (stop func()) {
+	signals := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	signal.Notify(signals, os.Interrupt)
+
+	go func() {
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		log.Print("Interrupted; cancelling requests. Interrupt again to exit immediately.")
+		cancel()
+
+		select {
+		case <-signals:
+			os.Exit(exitInterrupted)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// runCancelled is the error of a run which was stopped by SIGINT or the
+// deadline, with the matching exit code.
+func runCancelled(ctx context.Context, deadline time.Duration) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return &exitError{code: exitDeadline, err: fmt.Errorf("deadline of %v exceeded", deadline)}
+	}
+	return &exitError{code: exitInterrupted, err: errors.New("interrupted")}
+}
+
+// timeoutBody cancels the request if no data is read from the body within the
+// timeout, so that stalled downloads fail without limiting the total time of
+// slow ones. The request is also cancelled once the body is closed.
+type timeoutBody struct {
+	io.ReadCloser
+	timeout  time.Duration
+	timer    *time.Timer
+	timedOut int32
+	cancel   context.CancelFunc
+}
+
+func newTimeoutBody(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) *timeoutBody {
+	b := &timeoutBody{ReadCloser: body, timeout: timeout, cancel: cancel}
+	if timeout > 0 {
+		b.timer = time.AfterFunc(timeout, func() {
+			atomic.StoreInt32(&b.timedOut, 1)
+			cancel()
+		})
+	}
+	return b
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	if b.timer != nil {
+		b.timer.Reset(b.timeout)
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && atomic.LoadInt32(&b.timedOut) == 1 {
+		err = fmt.Errorf("no data received for %v", b.timeout)
+	}
+	return n, err
+}
+
+func (b *timeoutBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
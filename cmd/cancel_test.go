@@ -0,0 +1,172 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestTimeoutsAndCancellation(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	var requests int64
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt64(&requests, 1)
+		wait := func(d time.Duration) {
+			select {
+			case <-time.After(d):
+			case <-req.Context().Done():
+			}
+		}
+
+		switch req.URL.Path {
+		case "/stream":
+			// Sends the body in chunks, 50ms apart.
+			for i := 0; i < 5; i++ {
+				fmt.Fprint(w, i)
+				w.(http.Flusher).Flush()
+				wait(50 * time.Millisecond)
+			}
+		case "/stall":
+			fmt.Fprint(w, "[")
+			w.(http.Flusher).Flush()
+			wait(time.Second)
+		case "/hang":
+			wait(3 * time.Second)
+		case "/slow":
+			wait(100 * time.Millisecond)
+			fmt.Fprint(w, `{}`)
+		case "/interrupt":
+			if n == 2 {
+				syscall.Kill(os.Getpid(), syscall.SIGINT)
+				wait(time.Second)
+			}
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+	defer s.Close()
+
+	testCases := []struct {
+		desc  string
+		args  []string
+		input string
+
+		expectRequests int64
+		expectStdout   string
+		expectStderr   string
+		expectErr      string
+		expectCode     int
+	}{
+		{
+			desc:           "slow body within read timeout",
+			args:           []string{"get", "/stream", "--read-timeout", "500ms", "--request-timeout", "0"},
+			expectRequests: 1,
+			expectStdout:   "01234\n",
+		}, {
+			desc:           "stalled body",
+			args:           []string{"get", "/stall", "--read-timeout", "100ms"},
+			expectRequests: 1,
+			expectStdout:   "[",
+			expectErr:      "no data received for 100ms",
+			expectCode:     exitAllFailed,
+		}, {
+			desc:           "request timeout",
+			args:           []string{"get", "/hang", "--request-timeout", "1"},
+			expectRequests: 1,
+			expectErr:      fmt.Sprintf("Get \"http://%v/hang\": context deadline exceeded (Client.Timeout exceeded while awaiting headers)", s.Listener.Addr()),
+			expectCode:     exitAllFailed,
+		}, {
+			desc:           "response header timeout",
+			args:           []string{"get", "/slow", "--response-header-timeout", "20ms"},
+			expectRequests: 1,
+			expectErr:      fmt.Sprintf("Get \"http://%v/slow\": net/http: timeout awaiting response headers", s.Listener.Addr()),
+			expectCode:     exitAllFailed,
+		}, {
+			desc:           "deadline",
+			args:           []string{"get", "/slow", "--deadline", "250ms"},
+			input:          rows(5),
+			expectRequests: 3,
+			expectStdout:   "{}\n{}\n",
+			expectStderr:   "Summary: 2 requests (2 succeeded, 0 failed)",
+			expectErr:      "deadline of 250ms exceeded",
+			expectCode:     exitDeadline,
+		}, {
+			desc:           "interrupted",
+			args:           []string{"get", "/interrupt"},
+			input:          rows(5),
+			expectRequests: 2,
+			expectStdout:   "{}\n",
+			expectStderr:   "Summary: 1 requests (1 succeeded, 0 failed)",
+			expectErr:      "interrupted",
+			expectCode:     exitInterrupted,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ResetSettings()
+			viper.Set("scheme", "http")
+			viper.Set("domain", s.Listener.Addr().String())
+			atomic.StoreInt64(&requests, 0)
+
+			var input io.Reader
+			if tc.input != "" {
+				input = strings.NewReader(tc.input)
+			}
+
+			stdout, stderr, err := captureOutput(execute, tc.args, input)
+			switch {
+			case err == nil && tc.expectErr != "":
+				t.Errorf("Expected error %q but got none", tc.expectErr)
+			case err != nil && err.Error() != tc.expectErr:
+				t.Errorf("Expected error %q, got %q", tc.expectErr, err)
+			}
+			if err != nil {
+				if code := stats.exitCode(err); code != tc.expectCode {
+					t.Errorf("Expected exit code %v, got %v", tc.expectCode, code)
+				}
+			}
+
+			if got := atomic.LoadInt64(&requests); got != tc.expectRequests {
+				t.Errorf("Expected %v requests, got %v", tc.expectRequests, got)
+			}
+			if stdout != tc.expectStdout {
+				t.Errorf("Expected stdout %q, got %q", tc.expectStdout, stdout)
+			}
+			if !strings.Contains(stderr, tc.expectStderr) {
+				t.Errorf("Expected stderr to contain %q, got %q", tc.expectStderr, stderr)
+			}
+		})
+	}
+}
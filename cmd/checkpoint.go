@@ -0,0 +1,186 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// rowFailed is set by runStats when any request for the row currently being
+// executed fails. Cobra commands can not return anything but an error and
+// responses handled via on-error do not return one, so this is how execute
+// finds out about them.
+var rowFailed bool
+
+// checkpointHeader is the first line of a checkpoint file and identifies the
+// input and args it was written for.
+type checkpointHeader struct {
+	Input string `json:"input"`
+}
+
+// checkpointEntry is written to the checkpoint file for each row which
+// completed successfully.
+type checkpointEntry struct {
+	Index int    `json:"index"`
+	Hash  string `json:"hash"`
+}
+
+// checkpoint records which rows of piped input have completed so that a run can
+// be resumed. A nil checkpoint is valid and records nothing.
+type checkpoint struct {
+	f    *os.File
+	done map[int]string
+}
+
+// openCheckpoint loads the checkpoint at path, if it exists, and opens it for
+// recording completed rows. It is an error if the checkpoint was written for
+// different input or args.
+func openCheckpoint(path string, args []string, data [][]string) (*checkpoint, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	rows := make([]string, len(data))
+	for i := range data {
+		rows[i] = hashStrings(data[i])
+	}
+	header := checkpointHeader{Input: hashStrings(append([]string{hashStrings(args)}, rows...))}
+
+	cp := &checkpoint{done: map[int]string{}}
+	fresh := false
+	existing, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+		fresh = true
+	case err != nil:
+		return nil, err
+	default:
+		defer existing.Close()
+		dec := json.NewDecoder(existing)
+
+		var h checkpointHeader
+		err := dec.Decode(&h)
+		switch {
+		case err == io.EOF:
+			fresh = true
+		case err != nil:
+			return nil, fmt.Errorf("invalid checkpoint %v: %v", path, err)
+		case h.Input != header.Input:
+			return nil, fmt.Errorf("input or args have changed since checkpoint %v was written; remove it to start over", path)
+		}
+
+		for dec.More() {
+			var e checkpointEntry
+			if err := dec.Decode(&e); err != nil {
+				return nil, fmt.Errorf("invalid checkpoint %v: %v", path, err)
+			}
+			cp.done[e.Index] = e.Hash
+		}
+	}
+
+	cp.f, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if fresh {
+		if err := json.NewEncoder(cp.f).Encode(header); err != nil {
+			cp.f.Close()
+			return nil, err
+		}
+	}
+
+	return cp, nil
+}
+
+// completed returns true if the row with the given index and command completed
+// in a previous run.
+func (cp *checkpoint) completed(i int, cmd []string) bool {
+	if cp == nil {
+		return false
+	}
+
+	hash, ok := cp.done[i]
+	return ok && hash == hashStrings(cmd)
+}
+
+// complete records the row as successfully completed.
+func (cp *checkpoint) complete(i int, cmd []string) error {
+	if cp == nil {
+		return nil
+	}
+
+	return json.NewEncoder(cp.f).Encode(checkpointEntry{Index: i, Hash: hashStrings(cmd)})
+}
+
+func (cp *checkpoint) Close() error {
+	if cp == nil {
+		return nil
+	}
+
+	return cp.f.Close()
+}
+
+// failedRows writes the input rows which failed so that they can be piped back
+// into jaq. A nil failedRows is valid and records nothing.
+type failedRows struct {
+	f *os.File
+}
+
+// openFailedRows truncates the file at path so it only contains the failures
+// from this run.
+func openFailedRows(path string) (*failedRows, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &failedRows{f: f}, nil
+}
+
+func (fr *failedRows) add(row []string) error {
+	if fr == nil || len(row) == 0 {
+		return nil
+	}
+
+	_, err := fmt.Fprintln(fr.f, strings.Join(row, " "))
+	return err
+}
+
+func (fr *failedRows) Close() error {
+	if fr == nil {
+		return nil
+	}
+
+	return fr.f.Close()
+}
+
+// hashStrings returns a hex encoded sha256 of the strings, unambiguously joined.
+func hashStrings(s []string) string {
+	h := sha256.New()
+	for _, v := range s {
+		fmt.Fprintf(h, "%d:%s;", len(v), v)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,109 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestCheckpoint(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	var got []string
+	failing := map[string]bool{"/posts/2": true, "/posts/4": true}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = append(got, req.URL.Path)
+		if failing[req.URL.Path] {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer s.Close()
+
+	cpPath := filepath.Join(tmpDir, "state.json")
+	failedPath := filepath.Join(tmpDir, "failed.json")
+	input := `{"id":1} {"id":2} {"id":3} {"id":4}`
+	args := []string{"get", "/posts/${1.id}", "--checkpoint", cpPath, "--failed-rows", failedPath}
+
+	run := func(input string) error {
+		ResetSettings()
+		viper.Set("scheme", "http")
+		viper.Set("domain", s.Listener.Addr().String())
+		viper.Set("on-error", "silence")
+		_, _, err := captureOutput(execute, args, strings.NewReader(input))
+		return err
+	}
+
+	if err := run(input); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"/posts/1", "/posts/2", "/posts/3", "/posts/4"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected requests %v, got %v", expected, got)
+	}
+
+	b, err := ioutil.ReadFile(failedPath)
+	if err != nil {
+		t.Fatalf("Unable to read failed rows: %v", err)
+	}
+	if string(b) != `{"id":2}`+"\n"+`{"id":4}`+"\n" {
+		t.Errorf("Unexpected failed rows: %q", string(b))
+	}
+
+	// Resuming should only run the rows which failed.
+	got = nil
+	delete(failing, "/posts/2")
+	if err := run(input); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected = []string{"/posts/2", "/posts/4"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected requests %v, got %v", expected, got)
+	}
+
+	b, err = ioutil.ReadFile(failedPath)
+	if err != nil {
+		t.Fatalf("Unable to read failed rows: %v", err)
+	}
+	if string(b) != `{"id":4}`+"\n" {
+		t.Errorf("Unexpected failed rows: %q", string(b))
+	}
+
+	// Changed input must not be matched against the old checkpoint.
+	got = nil
+	err = run(`{"id":5}`)
+	expectedErr := errors.New("input or args have changed since checkpoint " + cpPath + " was written; remove it to start over")
+	if !reflect.DeepEqual(err, expectedErr) {
+		t.Errorf("Expected error %v, got %v", expectedErr, err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Expected no requests, got %v", got)
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// transport is shared by all the requests of a run so that connections are
+// reused between rows rather than set up for each request. It is created at
+// the start of each execute.
+var transport *http.Transport
+
+// newTransport creates the transport from the connection settings.
+func newTransport() (*http.Transport, error) {
+	maxIdle := viper.GetInt("max-idle-conns")
+	maxIdlePerHost := viper.GetInt("max-idle-conns-per-host")
+	if maxIdle < 0 || maxIdlePerHost < 0 {
+		return nil, fmt.Errorf("invalid max-idle-conns %v and max-idle-conns-per-host %v; expected values >= 0", maxIdle, maxIdlePerHost)
+	}
+
+	proxy, err := newProxy()
+	if err != nil {
+		return nil, err
+	}
+
+	dial, err := newDial(&net.Dialer{
+		Timeout:   viper.GetDuration("connect-timeout"),
+		KeepAlive: 30 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Requests to a Unix socket can't go through a proxy.
+	if viper.GetString("unix-socket") != "" {
+		proxy = nil
+	}
+
+	t := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dial,
+		MaxIdleConns:          maxIdle,
+		MaxIdleConnsPerHost:   maxIdlePerHost,
+		IdleConnTimeout:       viper.GetDuration("idle-conn-timeout"),
+		DisableKeepAlives:     !viper.GetBool("keep-alive"),
+		ForceAttemptHTTP2:     viper.GetBool("http2"),
+		TLSHandshakeTimeout:   viper.GetDuration("tls-timeout"),
+		ResponseHeaderTimeout: viper.GetDuration("response-header-timeout"),
+		ExpectContinueTimeout: time.Second,
+	}
+
+	// A non-nil, empty map is the documented way to turn off HTTP/2.
+	if !t.ForceAttemptHTTP2 {
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	return t, nil
+}
+
+// newDial returns the function opening the connections of the transport. The
+// unix-socket setting sends every request over the socket and the resolve
+// setting connects to the given address instead of looking up the host. The
+// URL and Host header are left as they are in both cases.
+func newDial(dialer *net.Dialer) (func(context.Context, string, string) (net.Conn, error), error) {
+	socket, resolve := viper.GetString("unix-socket"), viper.GetStringSlice("resolve")
+	if socket != "" && len(resolve) > 0 {
+		return nil, errors.New("only one of unix-socket and resolve may be set")
+	}
+
+	addrs := map[string]string{}
+	for _, entry := range resolve {
+		host, addr, err := parseResolve(entry)
+		if err != nil {
+			return nil, err
+		}
+		addrs[host] = addr
+	}
+
+	trace := viper.GetBool("trace") || viper.GetBool("debug")
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		target := addr
+		switch {
+		case socket != "":
+			network, target = "unix", socket
+		case addrs[strings.ToLower(addr)] != "":
+			target = addrs[strings.ToLower(addr)]
+		}
+
+		if trace && target != addr {
+			log.Printf("Connecting to %v at %v", addr, target)
+		}
+		return dialer.DialContext(ctx, network, target)
+	}, nil
+}
+
+// parseResolve parses a resolve entry of the form host:port:addr, returning the
+// host:port to match and the addr:port to connect to instead. IPv6 addresses
+// may be given in brackets.
+func parseResolve(entry string) (string, string, error) {
+	parts := strings.SplitN(entry, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", fmt.Errorf("invalid resolve %q, expected host:port:addr", entry)
+	}
+
+	host, port := strings.ToLower(parts[0]), parts[1]
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", "", fmt.Errorf("invalid resolve %q, expected a port number but got %q", entry, port)
+	}
+	addr := strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]")
+	if net.ParseIP(addr) == nil {
+		return "", "", fmt.Errorf("invalid resolve %q, expected an IP address but got %q", entry, parts[2])
+	}
+	return net.JoinHostPort(host, port), net.JoinHostPort(addr, port), nil
+}
+
+// newProxy returns the function choosing the proxy of each request from the
+// proxy, socks5 and no-proxy settings. Without them, the proxy is taken from the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func newProxy() (func(*http.Request) (*url.URL, error), error) {
+	httpProxy, socksProxy := viper.GetString("proxy"), viper.GetString("socks5")
+	if httpProxy != "" && socksProxy != "" {
+		return nil, errors.New("only one of proxy and socks5 may be set")
+	}
+
+	var proxyURL *url.URL
+	var err error
+	switch {
+	case httpProxy != "":
+		proxyURL, err = parseProxyURL(httpProxy, "http", "http", "https")
+	case socksProxy != "":
+		proxyURL, err = parseProxyURL(socksProxy, "socks5", "socks5")
+	default:
+		return traceProxy(http.ProxyFromEnvironment), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Credentials in the URL win over those given separately.
+	if user := viper.GetString("proxy-user"); proxyURL.User == nil && user != "" {
+		proxyURL.User = url.UserPassword(user, viper.GetString("proxy-pass"))
+	}
+
+	noProxy := viper.GetStringSlice("no-proxy")
+	return traceProxy(func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(req.URL, noProxy) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}), nil
+}
+
+// parseProxyURL parses the proxy address, which may omit the scheme, and
+// checks the scheme is one of those allowed.
+func parseProxyURL(s, defaultScheme string, schemes ...string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = defaultScheme + "://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %v", s, err)
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid proxy %q, expected a scheme of %v", u.Redacted(), strings.Join(schemes, " or "))
+}
+
+// bypassProxy reports whether the URL matches an entry of the no-proxy list:
+// * for all hosts, an IP, a CIDR range, or a domain which also matches its
+// subdomains. Entries may have a port to only match that port.
+func bypassProxy(u *url.URL, noProxy []string) bool {
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	ip := net.ParseIP(host)
+
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		entryHost, entryPort, err := net.SplitHostPort(entry)
+		if err != nil {
+			entryHost, entryPort = entry, ""
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+
+		entryHost = strings.TrimPrefix(entryHost, ".")
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
+
+// traceProxy logs the proxy used by each request when tracing so that proxy
+// hops show up alongside the requests.
+func traceProxy(proxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	if !viper.GetBool("trace") && !viper.GetBool("debug") {
+		return proxy
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := proxy(req)
+		switch {
+		case err != nil:
+			log.Printf("Unable to choose proxy for %v: %v", req.URL, err)
+		case u != nil:
+			log.Printf("Sending request to %v via proxy %v", req.URL.Host, u.Redacted())
+		default:
+			log.Printf("Sending request to %v directly", req.URL.Host)
+		}
+		return u, err
+	}
+}
+
+// newClient creates a client for a single request using the shared transport
+// and cookie jar.
+func newClient(conf config) *http.Client {
+	c := &http.Client{
+		Timeout: time.Duration(conf.requestTimeout) * time.Second,
+	}
+	// A stream is read for as long as it lasts so only the read-timeout
+	// applies to its body.
+	if conf.stream {
+		c.Timeout = 0
+	}
+	// Redirects are returned as they are when an on-status rule handles
+	// them, rather than followed.
+	if conf.onStatus.handlesRedirects() {
+		c.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	if transport != nil {
+		c.Transport = transport
+	}
+	if jar != nil {
+		c.Jar = jar
+	}
+	return c
+}
@@ -0,0 +1,242 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// connServer is a server which counts the connections made to it.
+func connServer() (*httptest.Server, *int64) {
+	var conns int64
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"id":1}`)
+	}))
+	s.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	s.Start()
+	return s, &conns
+}
+
+// rows returns n rows of piped input.
+func rows(n int) string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf(`{"id":%d}`, i)
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestConnectionReuse(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	testCases := []struct {
+		desc        string
+		args        []string
+		expectConns int64
+		expectErr   string
+	}{
+		{
+			desc:        "one connection for all rows",
+			args:        []string{"get", "/posts/${1.id}"},
+			expectConns: 1,
+		}, {
+			desc:        "keep-alive disabled",
+			args:        []string{"get", "/posts/${1.id}", "--keep-alive=false"},
+			expectConns: 20,
+		}, {
+			desc:        "http2 disabled",
+			args:        []string{"get", "/posts/${1.id}", "--http2=false"},
+			expectConns: 1,
+		}, {
+			desc:      "invalid limit",
+			args:      []string{"get", "/posts/${1.id}", "--max-idle-conns", "-1"},
+			expectErr: "invalid max-idle-conns -1 and max-idle-conns-per-host 16; expected values >= 0",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			s, conns := connServer()
+			defer s.Close()
+
+			ResetSettings()
+			viper.Set("scheme", "http")
+			viper.Set("domain", s.Listener.Addr().String())
+
+			_, _, err := captureOutput(execute, tc.args, strings.NewReader(rows(20)))
+			switch {
+			case err == nil && tc.expectErr != "":
+				t.Errorf("Expected error %q but got none", tc.expectErr)
+			case err != nil && err.Error() != tc.expectErr:
+				t.Errorf("Expected error %q, got %q", tc.expectErr, err)
+			}
+
+			if got := atomic.LoadInt64(conns); got != tc.expectConns {
+				t.Errorf("Expected %v connections, got %v", tc.expectConns, got)
+			}
+		})
+	}
+}
+
+// BenchmarkRows sends a request for each of b.N rows of piped input, reporting
+// the number of connections made per request.
+func BenchmarkRows(b *testing.B) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		b.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	for _, keepAlive := range []bool{true, false} {
+		b.Run(fmt.Sprintf("keep-alive=%v", keepAlive), func(b *testing.B) {
+			s, conns := connServer()
+			defer s.Close()
+
+			ResetSettings()
+			viper.Set("scheme", "http")
+			viper.Set("domain", s.Listener.Addr().String())
+			input := strings.NewReader(rows(b.N))
+
+			b.ResetTimer()
+			_, _, err := captureOutput(execute, []string{"get", "/posts/${1.id}", fmt.Sprintf("--keep-alive=%v", keepAlive)}, input)
+			b.StopTimer()
+			if err != nil {
+				b.Fatalf("Unexpected error: %v", err)
+			}
+
+			b.ReportMetric(float64(atomic.LoadInt64(conns))/float64(b.N), "conns/op")
+		})
+	}
+}
+
+func TestDialTargets(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, `{"host":%q,"path":%q}`, req.Host, req.URL.Path)
+	})
+
+	s := httptest.NewServer(handler)
+	defer s.Close()
+	_, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+
+	socket := filepath.Join(tmpDir, "jaq.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on %v: %v", socket, err)
+	}
+	us := &httptest.Server{Listener: ln, Config: &http.Server{Handler: handler}}
+	us.Start()
+	defer us.Close()
+
+	testCases := []struct {
+		desc   string
+		domain string
+		args   []string
+
+		expectStdout string
+		expectErr    string
+	}{
+		{
+			desc:         "unix socket",
+			domain:       "docker",
+			args:         []string{"get", "/v1.43/containers/json", "--unix-socket", socket},
+			expectStdout: `{"host":"docker","path":"/v1.43/containers/json"}` + "\n",
+		}, {
+			desc:         "unix socket ignores proxy",
+			domain:       "docker",
+			args:         []string{"get", "/info", "--unix-socket", socket, "--proxy", "127.0.0.1:1"},
+			expectStdout: `{"host":"docker","path":"/info"}` + "\n",
+		}, {
+			desc:         "resolve",
+			domain:       "api.example.com:" + port,
+			args:         []string{"get", "/users", "--resolve", "API.example.com:" + port + ":127.0.0.1"},
+			expectStdout: `{"host":"api.example.com:` + port + `","path":"/users"}` + "\n",
+		}, {
+			desc:         "resolve for another host",
+			domain:       "127.0.0.1:" + port,
+			args:         []string{"get", "/users", "--resolve", "other.example.com:" + port + ":[::1]"},
+			expectStdout: `{"host":"127.0.0.1:` + port + `","path":"/users"}` + "\n",
+		}, {
+			desc:      "resolve and unix socket",
+			domain:    "docker",
+			args:      []string{"get", "/info", "--unix-socket", socket, "--resolve", "docker:80:127.0.0.1"},
+			expectErr: "only one of unix-socket and resolve may be set",
+		}, {
+			desc:      "resolve without addr",
+			domain:    "docker",
+			args:      []string{"get", "/info", "--resolve", "docker:80"},
+			expectErr: `invalid resolve "docker:80", expected host:port:addr`,
+		}, {
+			desc:      "resolve with invalid port",
+			domain:    "docker",
+			args:      []string{"get", "/info", "--resolve", "docker:http:127.0.0.1"},
+			expectErr: `invalid resolve "docker:http:127.0.0.1", expected a port number but got "http"`,
+		}, {
+			desc:      "resolve to a host name",
+			domain:    "docker",
+			args:      []string{"get", "/info", "--resolve", "docker:80:backend"},
+			expectErr: `invalid resolve "docker:80:backend", expected an IP address but got "backend"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ResetSettings()
+			viper.Set("scheme", "http")
+			viper.Set("domain", tc.domain)
+
+			stdout, _, err := captureOutput(execute, tc.args, nil)
+			switch {
+			case err == nil && tc.expectErr != "":
+				t.Errorf("Expected error %q but got none", tc.expectErr)
+			case err != nil && err.Error() != tc.expectErr:
+				t.Errorf("Expected error %q, got %q", tc.expectErr, err)
+			}
+			if stdout != tc.expectStdout {
+				t.Errorf("Expected stdout %q, got %q", tc.expectStdout, stdout)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Ericsson/jaq/openapi"
+	"github.com/spf13/cobra"
+)
+
+// bashCompletionFunc completes the paths of the HTTP commands and the names of
+// query parameters by calling back into jaq, which reads the OpenAPI spec.
+const bashCompletionFunc = `__jaq_complete_path()
+{
+    local out
+    if out=$(jaq __jaq_complete path "${cur}" 2>/dev/null); then
+        COMPREPLY=( $(compgen -W "${out}" -- "${cur}") )
+    fi
+}
+
+__jaq_complete_query()
+{
+    local out
+    if out=$(jaq __jaq_complete query "${last_command#jaq_}" "${nouns[0]}" 2>/dev/null); then
+        COMPREPLY=( $(compgen -W "${out}" -- "${cur}") )
+        if [[ $(type -t compopt) = "builtin" ]]; then
+            compopt -o nospace
+        fi
+    fi
+}
+
+__custom_func()
+{
+    case ${last_command} in
+        jaq_get | jaq_put | jaq_post | jaq_patch | jaq_head | jaq_delete | jaq_trace | jaq_options | jaq_describe)
+            __jaq_complete_path
+            return
+            ;;
+    esac
+}
+`
+
+func completionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "completion",
+		Short: "Output bash completion for jaq",
+		Long: `Output bash completion for jaq. When an OpenAPI spec is configured, the paths
+of the HTTP commands and the names of query parameters are completed from it.
+
+> source <(jaq completion)`,
+		Args: cobra.NoArgs,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			RootCmd.BashCompletionFunction = bashCompletionFunc
+			return RootCmd.GenBashCompletion(os.Stdout)
+		},
+	}
+}
+
+// completeCommand is called by the bash completion to list the candidates for
+// a path or query parameter. It is not named __complete since newer versions of
+// cobra use that name for their own completion.
+func completeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:    "__jaq_complete (path PREFIX | query METHOD PATH)",
+		Hidden: true,
+		Args:   cobra.RangeArgs(2, 3),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			spec, err := loadSpec()
+			if err != nil || spec == nil {
+				return err
+			}
+			return complete(os.Stdout, spec, args)
+		},
+	}
+}
+
+func complete(w io.Writer, spec *openapi.Spec, args []string) error {
+	switch {
+	case args[0] == "path" && len(args) == 2:
+		for _, p := range spec.Complete(args[1]) {
+			fmt.Fprintln(w, p)
+		}
+	case args[0] == "query" && len(args) == 3:
+		item, _ := spec.Find(args[2])
+		if item == nil {
+			return nil
+		}
+		op := item.Operation(args[1])
+		if op == nil {
+			return nil
+		}
+
+		// Listed as NAME= so that the value can be typed right after.
+		for _, p := range op.Parameters {
+			if p.In == "query" {
+				fmt.Fprintf(w, "%v=\n", p.Name)
+			}
+		}
+	default:
+		return fmt.Errorf("expected path PREFIX or query METHOD PATH")
+	}
+	return nil
+}
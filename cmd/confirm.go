@@ -0,0 +1,155 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// destructiveVerbs are the commands which require confirmation when run for
+// many rows of piped input.
+var destructiveVerbs = map[string]bool{
+	"delete": true,
+	"put":    true,
+	"patch":  true,
+}
+
+// openTTY opens the terminal for prompting the user. Stdin can not be used
+// since it is typically the piped input. Overridden in tests.
+var openTTY = func() (io.ReadWriteCloser, error) {
+	return os.OpenFile("/dev/tty", os.O_RDWR, 0)
+}
+
+// confirmRequests checks that the given number of requests may be sent for the
+// command given by args, once to each of the targets if there are any. n is -1
+// when the number of requests is not known in advance, in which case
+// destructive verbs always need confirmation.
+func confirmRequests(args []string, n int) error {
+	c, _, err := RootCmd.Find(args)
+	if err != nil || c.Parent() != RootCmd {
+		return nil
+	}
+
+	verb := c.Name()
+	if v, ok := c.Annotations[aliasVerbAnnotation]; ok {
+		verb = v
+	}
+
+	hosts := []string{configHost()}
+	// Invalid targets are reported when the command runs.
+	if targets, err := parseTargets(viper.GetStringSlice("targets"), viper.GetString("base-url")); err == nil && len(targets) > 0 {
+		hosts = nil
+		for _, t := range targets {
+			hosts = append(hosts, t.describe())
+		}
+		if n > 0 {
+			n *= len(targets)
+		}
+	}
+	return confirmSend(map[string]int{verb: n}, hosts)
+}
+
+// confirmSend checks that the requests, counted by verb, may be sent to the
+// given hosts. It enforces the max-requests limit and, when the destructive
+// requests are over the confirm-threshold, prompts the user via the terminal
+// unless --yes was set. A count of -1 means the number is not known in advance.
+func confirmSend(counts map[string]int, hosts []string) error {
+	// Nothing gets sent in dry-run mode so there is nothing to confirm.
+	if mode, err := dryRunMode(viper.GetString("dry-run")); err != nil || mode != dryRunOff {
+		return nil
+	}
+
+	var n, destructive int
+	var verbs []string
+	known := true
+	for verb, count := range counts {
+		if count < 0 {
+			known = false
+		}
+		n += count
+		if destructiveVerbs[strings.ToLower(verb)] && count != 0 {
+			destructive += count
+			verbs = append(verbs, verb)
+		}
+	}
+
+	if max := viper.GetInt("max-requests"); max > 0 && known && n > max {
+		return fmt.Errorf("refusing to send %v requests; exceeds --max-requests of %v", formatCount(n), formatCount(max))
+	}
+
+	if len(verbs) == 0 || (known && destructive <= viper.GetInt("confirm-threshold")) || viper.GetBool("yes") {
+		return nil
+	}
+
+	sort.Strings(verbs)
+	requests := make([]string, len(verbs))
+	for i, verb := range verbs {
+		requests[i] = fmt.Sprintf("%v %v", formatCount(counts[verb]), strings.ToUpper(verb))
+	}
+	to := strings.Join(hosts, ", ")
+	summary := fmt.Sprintf("about to send %v requests to %v", strings.Join(requests, " and "), to)
+	if !known {
+		summary = fmt.Sprintf("about to send a %v request to %v for each row of streamed input", strings.ToUpper(verbs[0]), to)
+	}
+
+	tty, err := openTTY()
+	if err != nil {
+		return fmt.Errorf("%v but unable to prompt for confirmation (%v); set --yes to proceed", summary, err)
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(tty, "jaq is %v. Continue? [y/N] ", summary)
+	answer, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	default:
+		return errors.New("aborted; not confirmed")
+	}
+}
+
+// configHost is the host requests are sent to according to the config.
+func configHost() string {
+	if u, err := configBaseURL(); err == nil {
+		return u.Host
+	}
+	return viper.GetString("domain")
+}
+
+// formatCount formats n with thousands separators for readability.
+func formatCount(n int) string {
+	if n < 0 {
+		return "-" + formatCount(-n)
+	}
+
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
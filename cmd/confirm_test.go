@@ -0,0 +1,180 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// fakeTTY answers prompts with the given input and records what was written.
+type fakeTTY struct {
+	io.Reader
+	bytes.Buffer
+}
+
+func (f *fakeTTY) Read(p []byte) (int, error) { return f.Reader.Read(p) }
+func (f *fakeTTY) Close() error               { return nil }
+
+func TestConfirmRequests(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	defer func(f func() (io.ReadWriteCloser, error)) { openTTY = f }(openTTY)
+
+	testCases := []struct {
+		desc   string
+		args   []string
+		n      int
+		setup  func()
+		answer string
+		ttyErr error
+
+		expectPrompt string
+		expectErr    error
+	}{
+		{
+			desc: "under threshold",
+			args: []string{"delete", "/posts/1"},
+			n:    10,
+		}, {
+			desc:         "confirmed",
+			args:         []string{"delete", "/posts/1"},
+			n:            3412,
+			answer:       "y\n",
+			expectPrompt: "jaq is about to send 3,412 DELETE requests to api.example.com. Continue? [y/N] ",
+		}, {
+			desc:         "not confirmed",
+			args:         []string{"put", "/posts/1"},
+			n:            11,
+			answer:       "\n",
+			expectPrompt: "jaq is about to send 11 PUT requests to api.example.com. Continue? [y/N] ",
+			expectErr:    errors.New("aborted; not confirmed"),
+		}, {
+			desc:      "no tty",
+			args:      []string{"patch", "/posts/1"},
+			n:         11,
+			ttyErr:    errors.New("no tty"),
+			expectErr: errors.New("about to send 11 PATCH requests to api.example.com but unable to prompt for confirmation (no tty); set --yes to proceed"),
+		}, {
+			desc:  "yes",
+			args:  []string{"delete", "/posts/1"},
+			n:     11,
+			setup: func() { viper.Set("yes", true) },
+		}, {
+			desc: "not destructive",
+			args: []string{"get", "/posts/1"},
+			n:    11,
+		}, {
+			desc: "plan does not send requests",
+			args: []string{"plan", "delete", "/posts/1"},
+			n:    11,
+		}, {
+			desc:  "dry-run",
+			args:  []string{"delete", "/posts/1"},
+			n:     11,
+			setup: func() { viper.Set("dry-run", "curl") },
+		}, {
+			desc: "max requests",
+			args: []string{"get", "/posts/1"},
+			n:    1001,
+			setup: func() {
+				viper.Set("max-requests", 1000)
+				viper.Set("yes", true)
+			},
+			expectErr: errors.New("refusing to send 1,001 requests; exceeds --max-requests of 1,000"),
+		}, {
+			desc:         "targets",
+			args:         []string{"delete", "/posts/1"},
+			n:            6,
+			setup:        func() { viper.Set("targets", []string{"eu", "us=us.example.com"}) },
+			answer:       "y\n",
+			expectPrompt: "jaq is about to send 12 DELETE requests to eu (eu.api.example.com), us (us.example.com). Continue? [y/N] ",
+		}, {
+			desc: "targets max requests",
+			args: []string{"get", "/posts/1"},
+			n:    6,
+			setup: func() {
+				viper.Set("targets", []string{"eu", "us"})
+				viper.Set("max-requests", 10)
+			},
+			expectErr: errors.New("refusing to send 12 requests; exceeds --max-requests of 10"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ResetSettings()
+			viper.Set("domain", "api.example.com")
+			if tc.setup != nil {
+				tc.setup()
+			}
+
+			tty := &fakeTTY{Reader: strings.NewReader(tc.answer)}
+			openTTY = func() (io.ReadWriteCloser, error) {
+				if tc.ttyErr != nil {
+					return nil, tc.ttyErr
+				}
+				return tty, nil
+			}
+
+			err := confirmRequests(tc.args, tc.n)
+			if !reflect.DeepEqual(err, tc.expectErr) {
+				t.Errorf("Expected error %v, got %v", tc.expectErr, err)
+			}
+			if tty.String() != tc.expectPrompt {
+				t.Errorf("Expected prompt %q, got %q", tc.expectPrompt, tty.String())
+			}
+		})
+	}
+}
+
+func TestConfirmSendMixedVerbs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	defer func(f func() (io.ReadWriteCloser, error)) { openTTY = f }(openTTY)
+	tty := &fakeTTY{Reader: strings.NewReader("y\n")}
+	openTTY = func() (io.ReadWriteCloser, error) { return tty, nil }
+
+	ResetSettings()
+	viper.Set("confirm-threshold", 2)
+	if err := confirmSend(map[string]int{"PUT": 2, "DELETE": 1, "GET": 5}, []string{"a.example.com", "b.example.com"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	expected := "jaq is about to send 1 DELETE and 2 PUT requests to a.example.com, b.example.com. Continue? [y/N] "
+	if tty.String() != expected {
+		t.Errorf("Expected prompt %q, got %q", expected, tty.String())
+	}
+}
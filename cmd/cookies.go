@@ -0,0 +1,413 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	// cookieJarNone disables the cookie jar.
+	cookieJarNone = "none"
+
+	netscapeHeader   = "# Netscape HTTP Cookie File"
+	netscapeHTTPOnly = "#HttpOnly_"
+)
+
+// jar holds the cookies shared by all the requests of a run. It is opened at
+// the start of each execute and is nil if cookies are disabled.
+var jar *cookieJar
+
+// storedCookie is a cookie as kept in the jar. Expires is a unix time, or 0 for
+// session cookies which are kept until cleared.
+type storedCookie struct {
+	Domain   string `json:"domain"`
+	HostOnly bool   `json:"hostOnly,omitempty"`
+	Path     string `json:"path"`
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Secure   bool   `json:"secure,omitempty"`
+	HTTPOnly bool   `json:"httpOnly,omitempty"`
+	Expires  int64  `json:"expires,omitempty"`
+}
+
+// cookieJar is an http.CookieJar persisted to a file, in JSON if the file has
+// the .json extension and in the Netscape cookies.txt format otherwise. Unlike
+// net/http/cookiejar it does not consult the public suffix list since cookies
+// are only ever set by the APIs jaq is configured for.
+type cookieJar struct {
+	path string
+
+	mu      sync.Mutex
+	cookies []storedCookie
+	changed bool
+}
+
+// cookieJarPath is the file of the jar for the current config: the one given
+// by --cookie-jar or a file per config under the state directory.
+func cookieJarPath() string {
+	if p := viper.GetString("cookie-jar"); p != "" {
+		return p
+	}
+
+	name := defaultSessionProfile
+	if profile := sessionProfile(); profile != defaultSessionProfile {
+		sum := sha256.Sum256([]byte(profile))
+		name = strings.TrimSuffix(filepath.Base(profile), filepath.Ext(profile)) + "-" + hex.EncodeToString(sum[:4])
+	}
+	return filepath.Join(stateDir(), "cookies", name+".txt")
+}
+
+// openCookieJar reads the jar of the current config. A missing file is not an
+// error since it just means no cookies have been set yet.
+func openCookieJar() (*cookieJar, error) {
+	if viper.GetString("cookie-jar") == cookieJarNone {
+		return nil, nil
+	}
+
+	j := &cookieJar{path: cookieJarPath()}
+	f, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if filepath.Ext(j.path) == ".json" {
+		err = json.NewDecoder(f).Decode(&j.cookies)
+	} else {
+		j.cookies, err = readNetscapeCookies(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid cookie jar %v: %v", j.path, err)
+	}
+	return j, nil
+}
+
+// save writes the jar back to its file if any cookies changed. Expired cookies
+// are dropped. The file is only readable by the user since cookies tend to
+// authenticate requests.
+func (j *cookieJar) save() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if !j.changed {
+		return nil
+	}
+
+	now := time.Now()
+	var live []storedCookie
+	for _, c := range j.cookies {
+		if !c.expired(now) {
+			live = append(live, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	if filepath.Ext(j.path) == ".json" {
+		if err := encodeJSON(&buf, live); err != nil {
+			return err
+		}
+	} else {
+		writeNetscapeCookies(&buf, live)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(j.path, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	j.changed = false
+	return nil
+}
+
+// SetCookies implements http.CookieJar. Cookies for domains other than that
+// of the request, or its parents, are ignored.
+func (j *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	host := strings.ToLower(u.Hostname())
+	for _, c := range cookies {
+		sc := storedCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HTTPOnly: c.HttpOnly,
+		}
+		if !strings.HasPrefix(sc.Path, "/") {
+			sc.Path = defaultCookiePath(u.Path)
+		}
+
+		domain := strings.TrimPrefix(strings.ToLower(c.Domain), ".")
+		switch {
+		case domain == "":
+			sc.Domain, sc.HostOnly = host, true
+		case domainMatch(host, domain):
+			sc.Domain = domain
+		default:
+			continue
+		}
+
+		switch {
+		case c.MaxAge < 0:
+			sc.Expires = now.Unix()
+		case c.MaxAge > 0:
+			sc.Expires = now.Add(time.Duration(c.MaxAge) * time.Second).Unix()
+		case !c.Expires.IsZero():
+			sc.Expires = c.Expires.Unix()
+		}
+
+		j.remove(func(existing storedCookie) bool {
+			return existing.Domain == sc.Domain && existing.Path == sc.Path && existing.Name == sc.Name
+		})
+		if !sc.expired(now) {
+			j.cookies = append(j.cookies, sc)
+		}
+		j.changed = true
+	}
+}
+
+// Cookies implements http.CookieJar.
+func (j *cookieJar) Cookies(u *url.URL) []*http.Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	host := strings.ToLower(u.Hostname())
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	var cookies []*http.Cookie
+	for _, c := range j.cookies {
+		switch {
+		case c.expired(now):
+		case c.Secure && u.Scheme != "https":
+		case c.HostOnly && host != c.Domain:
+		case !domainMatch(host, c.Domain):
+		case !pathMatch(path, c.Path):
+		default:
+			cookies = append(cookies, &http.Cookie{Name: c.Name, Value: c.Value})
+		}
+	}
+	return cookies
+}
+
+// clear removes the cookies of the given domains, and their subdomains, or all
+// cookies if there are none given.
+func (j *cookieJar) clear(domains []string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.remove(func(c storedCookie) bool {
+		if len(domains) == 0 {
+			return true
+		}
+		for _, d := range domains {
+			if domainMatch(c.Domain, strings.TrimPrefix(strings.ToLower(d), ".")) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// remove drops the cookies for which the function returns true. The lock must
+// be held.
+func (j *cookieJar) remove(f func(storedCookie) bool) {
+	kept := j.cookies[:0]
+	for _, c := range j.cookies {
+		if f(c) {
+			j.changed = true
+			continue
+		}
+		kept = append(kept, c)
+	}
+	j.cookies = kept
+}
+
+func (c storedCookie) expired(now time.Time) bool {
+	return c.Expires != 0 && c.Expires <= now.Unix()
+}
+
+// domainMatch reports whether host is the domain or one of its subdomains.
+func domainMatch(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// pathMatch reports whether the request path is within the cookie path.
+func pathMatch(path, cookiePath string) bool {
+	if !strings.HasPrefix(path, cookiePath) {
+		return false
+	}
+	return len(path) == len(cookiePath) || strings.HasSuffix(cookiePath, "/") || path[len(cookiePath)] == '/'
+}
+
+// defaultCookiePath is the path of cookies which do not set one: the directory
+// of the request path.
+func defaultCookiePath(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+// readNetscapeCookies parses the cookies.txt format used by curl and browser
+// extensions: tab separated domain, subdomains flag, path, secure flag, expiry,
+// name and value.
+func readNetscapeCookies(r io.Reader) ([]storedCookie, error) {
+	var cookies []storedCookie
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		var c storedCookie
+		if strings.HasPrefix(text, netscapeHTTPOnly) {
+			c.HTTPOnly = true
+			text = strings.TrimPrefix(text, netscapeHTTPOnly)
+		}
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: expected 7 tab separated fields, got %d", line, len(fields))
+		}
+
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiry %q", line, fields[4])
+		}
+
+		c.Domain = strings.TrimPrefix(strings.ToLower(fields[0]), ".")
+		c.HostOnly = !strings.EqualFold(fields[1], "TRUE")
+		c.Path = fields[2]
+		c.Secure = strings.EqualFold(fields[3], "TRUE")
+		c.Expires = expires
+		c.Name = fields[5]
+		c.Value = fields[6]
+		cookies = append(cookies, c)
+	}
+
+	return cookies, scanner.Err()
+}
+
+func writeNetscapeCookies(w io.Writer, cookies []storedCookie) {
+	upper := func(b bool) string {
+		return strings.ToUpper(strconv.FormatBool(b))
+	}
+
+	fmt.Fprintln(w, netscapeHeader)
+	for _, c := range cookies {
+		domain := c.Domain
+		if !c.HostOnly {
+			domain = "." + domain
+		}
+		if c.HTTPOnly {
+			domain = netscapeHTTPOnly + domain
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%d\t%v\t%v\n", domain, upper(!c.HostOnly), c.Path, upper(c.Secure), c.Expires, c.Name, c.Value)
+	}
+}
+
+func cookiesCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "cookies",
+		Short: "Manage the cookie jar of the current config",
+		Long: `Manage the cookie jar of the current config. Cookies set by responses are kept
+in a jar per config file, or the file given by --cookie-jar, and sent with
+later requests. Jars ending in .json are JSON; others use the Netscape
+cookies.txt format so they can be shared with curl.`,
+	}
+	c.AddCommand(cookiesListCommand(), cookiesClearCommand())
+	return c
+}
+
+func cookiesListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "Write the cookies in the jar to stdout as JSON",
+		Args:  cobra.NoArgs,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if jar == nil {
+				return fmt.Errorf("the cookie jar is disabled by --cookie-jar %v", cookieJarNone)
+			}
+
+			now := time.Now()
+			cookies := []storedCookie{}
+			for _, c := range jar.cookies {
+				if !c.expired(now) {
+					cookies = append(cookies, c)
+				}
+			}
+			return encodeJSON(os.Stdout, cookies)
+		},
+	}
+}
+
+func cookiesClearCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "clear [DOMAIN...]",
+		Short: "Remove the cookies of the given domains, or all of them, from the jar",
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if jar == nil {
+				return fmt.Errorf("the cookie jar is disabled by --cookie-jar %v", cookieJarNone)
+			}
+			jar.clear(args)
+			return nil
+		},
+	}
+}
+
+func init() {
+	ResetSettingsCookies()
+}
+
+func ResetSettingsCookies() {
+	RootCmd.AddCommand(cookiesCommand())
+}
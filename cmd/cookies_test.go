@@ -0,0 +1,214 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestCookieJar(t *testing.T) {
+	j := &cookieJar{}
+	set := func(rawurl string, cookies ...*http.Cookie) {
+		u, _ := url.Parse(rawurl)
+		j.SetCookies(u, cookies)
+	}
+	set("http://api.example.com/v1/login",
+		&http.Cookie{Name: "host", Value: "1"},
+		&http.Cookie{Name: "parent", Value: "2", Domain: ".example.com", Path: "/"},
+		&http.Cookie{Name: "secure", Value: "3", Path: "/", Secure: true},
+		&http.Cookie{Name: "foreign", Value: "4", Domain: "other.com"},
+		&http.Cookie{Name: "gone", Value: "5", Path: "/", MaxAge: -1},
+	)
+	set("http://api.example.com/", &http.Cookie{Name: "parent", Value: "6", Domain: "example.com", Path: "/"})
+
+	testCases := []struct {
+		url    string
+		expect []string
+	}{
+		{url: "http://api.example.com/v1/posts", expect: []string{"host=1", "parent=6"}},
+		{url: "https://api.example.com/", expect: []string{"secure=3", "parent=6"}},
+		{url: "http://www.example.com/v1", expect: []string{"parent=6"}},
+		{url: "http://sub.api.example.com/v1", expect: []string{"parent=6"}},
+		{url: "http://api.example.com/v10", expect: []string{"parent=6"}},
+		{url: "http://other.com/", expect: nil},
+	}
+	for _, tc := range testCases {
+		u, _ := url.Parse(tc.url)
+		var got []string
+		for _, c := range j.Cookies(u) {
+			got = append(got, c.String())
+		}
+		if !reflect.DeepEqual(got, tc.expect) {
+			t.Errorf("%v: Expected cookies %v, got %v", tc.url, tc.expect, got)
+		}
+	}
+
+	// Both formats should give back the same cookies.
+	var buf bytes.Buffer
+	writeNetscapeCookies(&buf, j.cookies)
+	expected := "# Netscape HTTP Cookie File\n" +
+		"api.example.com\tFALSE\t/v1\tFALSE\t0\thost\t1\n" +
+		"api.example.com\tFALSE\t/\tTRUE\t0\tsecure\t3\n" +
+		".example.com\tTRUE\t/\tFALSE\t0\tparent\t6\n"
+	if buf.String() != expected {
+		t.Errorf("Expected cookies.txt %q, got %q", expected, buf.String())
+	}
+	parsed, err := readNetscapeCookies(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error reading cookies.txt: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, j.cookies) {
+		t.Errorf("Expected cookies %+v, got %+v", j.cookies, parsed)
+	}
+
+	if _, err := readNetscapeCookies(strings.NewReader("#HttpOnly_.example.com\tTRUE\t/\n")); err == nil || err.Error() != "line 1: expected 7 tab separated fields, got 3" {
+		t.Errorf("Expected error for invalid line, got %v", err)
+	}
+}
+
+func TestCookies(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+	os.Unsetenv("XDG_STATE_HOME")
+
+	var got []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = append(got, strings.TrimSpace(req.Method+" "+req.URL.String()+" "+req.Header.Get("Cookie")))
+		switch req.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "abc", Path: "/", HttpOnly: true})
+		case "/logout":
+			http.SetCookie(w, &http.Cookie{Name: "sid", Path: "/", MaxAge: -1})
+		default:
+			http.SetCookie(w, &http.Cookie{Name: "seen", Value: req.URL.Path[1:]})
+		}
+	}))
+	defer s.Close()
+	host := strings.Split(s.Listener.Addr().String(), ":")[0]
+	jsonJar := filepath.Join(tmpDir, "jar.json")
+
+	// Each step relies on the cookies of those before it.
+	steps := []struct {
+		desc  string
+		args  []string
+		input string
+
+		expectRequests []string
+		expectStdout   string
+	}{
+		{
+			desc:           "login",
+			args:           []string{"get", "/login"},
+			expectRequests: []string{"GET /login"},
+			expectStdout:   "\n",
+		}, {
+			desc:           "shared across rows",
+			args:           []string{"get", "/${1}"},
+			input:          "a b",
+			expectRequests: []string{"GET /a sid=abc", "GET /b sid=abc; seen=a"},
+			expectStdout:   "\n\n",
+		}, {
+			desc:         "list",
+			args:         []string{"cookies", "list"},
+			expectStdout: `[{"domain":"HOST","hostOnly":true,"path":"/","name":"sid","value":"abc","httpOnly":true},{"domain":"HOST","hostOnly":true,"path":"/","name":"seen","value":"b"}]`,
+		}, {
+			desc:           "disabled",
+			args:           []string{"get", "/c", "--cookie-jar", "none"},
+			expectRequests: []string{"GET /c"},
+			expectStdout:   "\n",
+		}, {
+			desc:           "other jar",
+			args:           []string{"get", "/login", "--cookie-jar", jsonJar},
+			expectRequests: []string{"GET /login"},
+			expectStdout:   "\n",
+		}, {
+			desc:           "logout",
+			args:           []string{"get", "/logout"},
+			expectRequests: []string{"GET /logout sid=abc; seen=b"},
+			expectStdout:   "\n",
+		}, {
+			desc:           "clear",
+			args:           []string{"cookies", "clear", host},
+			expectRequests: nil,
+		}, {
+			desc:         "list after clear",
+			args:         []string{"cookies", "list"},
+			expectStdout: "[]",
+		}, {
+			desc:         "list other jar",
+			args:         []string{"cookies", "list", "--cookie-jar", jsonJar},
+			expectStdout: `[{"domain":"HOST","hostOnly":true,"path":"/","name":"sid","value":"abc","httpOnly":true}]`,
+		},
+	}
+
+	for _, tc := range steps {
+		ResetSettings()
+		viper.Set("scheme", "http")
+		viper.Set("domain", s.Listener.Addr().String())
+		got = nil
+
+		var input io.Reader
+		if tc.input != "" {
+			input = strings.NewReader(tc.input)
+		}
+
+		stdout, _, err := captureOutput(execute, tc.args, input)
+		if err != nil {
+			t.Errorf("%v: Unexpected error: %v", tc.desc, err)
+		}
+		if !reflect.DeepEqual(got, tc.expectRequests) {
+			t.Errorf("%v: Expected requests %q, got %q", tc.desc, tc.expectRequests, got)
+		}
+
+		// Compact the JSON of the list command for easier comparison.
+		expectStdout := strings.Replace(tc.expectStdout, "HOST", host, -1)
+		if strings.HasPrefix(expectStdout, "[") {
+			var buf bytes.Buffer
+			if err := json.Compact(&buf, []byte(stdout)); err != nil {
+				t.Errorf("%v: Unable to parse stdout %q: %v", tc.desc, stdout, err)
+			}
+			stdout = buf.String()
+		}
+		if stdout != expectStdout {
+			t.Errorf("%v: Expected stdout %q, got %q", tc.desc, expectStdout, stdout)
+		}
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(tmpDir, ".local", "state", "jaq", "cookies", "default.txt"))
+	if err != nil {
+		t.Fatalf("Expected the default jar to exist: %v", err)
+	}
+	if expected := "# Netscape HTTP Cookie File\n"; string(b) != expected {
+		t.Errorf("Expected jar %q, got %q", expected, string(b))
+	}
+}
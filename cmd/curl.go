@@ -0,0 +1,470 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// importedRequest is a request converted from another tool, split into the
+// config needed to reach the host and the jaq command to run against it.
+type importedRequest struct {
+	config  map[string]interface{}
+	command []string
+}
+
+// curlFlagsWithValue are the curl flags which take a value but do not affect
+// the request jaq would make; they are skipped along with their value.
+var curlFlagsWithValue = map[string]bool{
+	"-o": true, "--output": true, "--output-dir": true, "-w": true, "--write-out": true,
+	"-D": true, "--dump-header": true, "--stderr": true, "--trace": true, "--trace-ascii": true,
+	"--retry": true, "--retry-delay": true, "--retry-max-time": true, "--max-redirs": true,
+	"--cacert": true, "--capath": true, "-c": true, "--cookie-jar": true,
+	"--limit-rate": true, "-y": true, "--speed-time": true, "-Y": true, "--speed-limit": true,
+	"--keepalive-time": true, "--expect100-timeout": true,
+}
+
+// curlFlagsIgnored are the curl flags without a value which do not affect the
+// request jaq would make. Any other flag is rejected rather than risk
+// importing a different request, e.g. by taking the value of -F as the URL.
+var curlFlagsIgnored = map[string]bool{
+	"-s": true, "--silent": true, "-S": true, "--show-error": true, "--no-progress-meter": true,
+	"-#": true, "--progress-bar": true, "-v": true, "--verbose": true, "-i": true, "--include": true,
+	"-L": true, "--location": true, "-k": true, "--insecure": true, "--compressed": true,
+	"-f": true, "--fail": true, "--fail-with-body": true, "-N": true, "--no-buffer": true,
+	"-g": true, "--globoff": true, "--path-as-is": true, "-q": true, "--disable": true,
+	"-0": true, "--http1.0": true, "--http1.1": true, "--http2": true, "--http2-prior-knowledge": true,
+	"--tcp-nodelay": true, "--no-keepalive": true, "--retry-connrefused": true, "--retry-all-errors": true,
+}
+
+// curlShortFlagsWithValue are the short curl flags which take a value, which
+// may be joined to the flag as in -XPOST.
+var curlShortFlagsWithValue = map[string]bool{
+	"-X": true, "-H": true, "-d": true, "-u": true, "-b": true, "-A": true,
+	"-e": true, "-m": true, "-o": true, "-w": true, "-x": true, "-c": true,
+	"-D": true, "-y": true, "-Y": true, "-T": true, "-U": true, "-r": true,
+	"-F": true, "-E": true, "-K": true, "-C": true, "-z": true,
+}
+
+// parseCurl converts a curl command line, as copied from browser devtools, to
+// the equivalent jaq command and config.
+func parseCurl(command string) (importedRequest, error) {
+	args, err := splitShellWords(command)
+	if err != nil {
+		return importedRequest{}, err
+	}
+	if len(args) > 0 && (args[0] == "curl" || strings.HasSuffix(args[0], "/curl")) {
+		args = args[1:]
+	}
+
+	var (
+		method, rawURL string
+		headers        []string
+		data           []string
+		get            bool
+		user           string
+		timeout        string
+		uploadFile     string
+	)
+	config := map[string]interface{}{}
+
+	for i := 0; i < len(args); i++ {
+		// Short flags may be joined, as in -sL or -XPOST; split them so that
+		// each is handled on its own.
+		if arg := args[i]; len(arg) > 2 && arg[0] == '-' && arg[1] != '-' {
+			args = append(args[:i:i], append(splitShortFlags(arg), args[i+1:]...)...)
+		}
+
+		arg := args[i]
+		value := func() (string, error) {
+			// Support --flag=value as well as --flag value.
+			if strings.HasPrefix(arg, "--") && strings.Contains(arg, "=") {
+				parts := strings.SplitN(arg, "=", 2)
+				arg = parts[0]
+				return parts[1], nil
+			}
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("curl flag %v requires a value", arg)
+			}
+			i++
+			return args[i], nil
+		}
+
+		name := arg
+		if strings.HasPrefix(arg, "--") {
+			name = strings.SplitN(arg, "=", 2)[0]
+		}
+
+		switch name {
+		case "-X", "--request":
+			if method, err = value(); err != nil {
+				return importedRequest{}, err
+			}
+		case "-H", "--header":
+			h, err := value()
+			if err != nil {
+				return importedRequest{}, err
+			}
+			headers = append(headers, h)
+		case "-d", "--data", "--data-raw", "--data-binary", "--data-ascii", "--json":
+			d, err := value()
+			if err != nil {
+				return importedRequest{}, err
+			}
+			data = append(data, d)
+			if name == "--json" {
+				headers = append(headers, "Content-Type: application/json", "Accept: application/json")
+			}
+		case "--data-urlencode":
+			d, err := value()
+			if err != nil {
+				return importedRequest{}, err
+			}
+			encoded, err := curlURLEncode(d)
+			if err != nil {
+				return importedRequest{}, err
+			}
+			data = append(data, encoded)
+		case "-u", "--user":
+			if user, err = value(); err != nil {
+				return importedRequest{}, err
+			}
+		case "-b", "--cookie":
+			c, err := value()
+			if err != nil {
+				return importedRequest{}, err
+			}
+			headers = append(headers, "Cookie: "+c)
+		case "-A", "--user-agent":
+			ua, err := value()
+			if err != nil {
+				return importedRequest{}, err
+			}
+			headers = append(headers, "User-Agent: "+ua)
+		case "-e", "--referer":
+			r, err := value()
+			if err != nil {
+				return importedRequest{}, err
+			}
+			headers = append(headers, "Referer: "+r)
+		case "-m", "--max-time":
+			if timeout, err = value(); err != nil {
+				return importedRequest{}, err
+			}
+		case "-T", "--upload-file":
+			if uploadFile, err = value(); err != nil {
+				return importedRequest{}, err
+			}
+		case "-r", "--range":
+			r, err := value()
+			if err != nil {
+				return importedRequest{}, err
+			}
+			headers = append(headers, "Range: bytes="+r)
+		case "--oauth2-bearer":
+			token, err := value()
+			if err != nil {
+				return importedRequest{}, err
+			}
+			headers = append(headers, "Authorization: Bearer "+token)
+		case "--connect-timeout":
+			t, err := value()
+			if err != nil {
+				return importedRequest{}, err
+			}
+			seconds, err := strconv.ParseFloat(t, 64)
+			if err != nil {
+				return importedRequest{}, fmt.Errorf("invalid curl --connect-timeout %q", t)
+			}
+			config["connect-timeout"] = time.Duration(seconds * float64(time.Second)).String()
+		case "--resolve":
+			r, err := value()
+			if err != nil {
+				return importedRequest{}, err
+			}
+			resolve, _ := config["resolve"].([]string)
+			config["resolve"] = append(resolve, r)
+		case "--unix-socket":
+			if config["unix-socket"], err = value(); err != nil {
+				return importedRequest{}, err
+			}
+		case "-x", "--proxy":
+			if config["proxy"], err = value(); err != nil {
+				return importedRequest{}, err
+			}
+		case "--noproxy":
+			hosts, err := value()
+			if err != nil {
+				return importedRequest{}, err
+			}
+			config["no-proxy"] = strings.Split(hosts, ",")
+		case "-U", "--proxy-user":
+			proxyUser, err := value()
+			if err != nil {
+				return importedRequest{}, err
+			}
+			parts := strings.SplitN(proxyUser, ":", 2)
+			config["proxy-user"] = parts[0]
+			if len(parts) == 2 {
+				config["proxy-pass"] = parts[1]
+			}
+		case "--url":
+			if rawURL, err = value(); err != nil {
+				return importedRequest{}, err
+			}
+		case "-G", "--get":
+			get = true
+		case "-I", "--head":
+			method = http.MethodHead
+		default:
+			switch {
+			case curlFlagsWithValue[name]:
+				if _, err := value(); err != nil {
+					return importedRequest{}, err
+				}
+			case curlFlagsIgnored[name]:
+				log.Printf("Ignoring curl flag %v", arg)
+			case strings.HasPrefix(arg, "-"):
+				return importedRequest{}, fmt.Errorf("unsupported curl flag %v", name)
+			case rawURL == "":
+				rawURL = arg
+			default:
+				return importedRequest{}, fmt.Errorf("unexpected curl argument %q; only one URL is supported", arg)
+			}
+		}
+	}
+
+	if rawURL == "" {
+		return importedRequest{}, fmt.Errorf("no URL found in curl command")
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return importedRequest{}, fmt.Errorf("invalid URL in curl command: %v", err)
+	}
+
+	body := strings.Join(data, "&")
+	if uploadFile != "" {
+		if body != "" {
+			return importedRequest{}, fmt.Errorf("curl --upload-file can not be combined with --data")
+		}
+		body = "@" + uploadFile
+		if method == "" {
+			method = http.MethodPut
+		}
+		// Like curl, the file name is added to a URL which ends in a slash.
+		if strings.HasSuffix(u.Path, "/") {
+			u.Path += filepath.Base(uploadFile)
+		}
+	}
+	query := u.RawQuery
+	if get && body != "" {
+		if query != "" {
+			query += "&"
+		}
+		query += body
+		body = ""
+	}
+
+	switch {
+	case method != "":
+	case body != "":
+		method = http.MethodPost
+	default:
+		method = http.MethodGet
+	}
+
+	config["scheme"] = u.Scheme
+	config["domain"] = u.Host
+	imported := importedRequest{config: config}
+
+	if user != "" {
+		parts := strings.SplitN(user, ":", 2)
+		imported.config["auth"] = "basic"
+		imported.config["user"] = parts[0]
+		if len(parts) == 2 {
+			imported.config["pass"] = parts[1]
+		}
+	}
+	if timeout != "" {
+		seconds, err := strconv.ParseFloat(timeout, 64)
+		if err != nil {
+			return importedRequest{}, fmt.Errorf("invalid curl --max-time %q", timeout)
+		}
+		imported.config["request-timeout"] = int(seconds + 0.999)
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	imported.command = []string{"jaq", strings.ToLower(method), path}
+	if query != "" {
+		imported.command = append(imported.command, "--query", query)
+	}
+
+	for _, h := range headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 {
+			return importedRequest{}, fmt.Errorf("invalid header %q in curl command", h)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+		// Bearer tokens are set via the config like every other jaq command.
+		if strings.EqualFold(key, "Authorization") && strings.HasPrefix(value, "Bearer ") {
+			imported.config["auth"] = "token"
+			imported.config["token"] = strings.TrimPrefix(value, "Bearer ")
+			continue
+		}
+		imported.command = append(imported.command, "--header", headerFlagValue(key+"="+value))
+	}
+
+	switch {
+	case strings.HasPrefix(body, "@"):
+		imported.command = append(imported.command, "--file", strings.TrimPrefix(body, "@"))
+	case body != "":
+		imported.command = append(imported.command, "--body", body)
+	}
+
+	return imported, nil
+}
+
+// splitShortFlags splits joined short flags such as -sL into -s and -L. The
+// rest of the argument after a flag which takes a value is its value, so -XPOST
+// becomes -X and POST.
+func splitShortFlags(arg string) []string {
+	var flags []string
+	for i := 1; i < len(arg); i++ {
+		flag := "-" + arg[i:i+1]
+		flags = append(flags, flag)
+		if curlShortFlagsWithValue[flag] {
+			if i+1 < len(arg) {
+				flags = append(flags, arg[i+1:])
+			}
+			break
+		}
+	}
+	return flags
+}
+
+// curlURLEncode encodes the value of --data-urlencode the way curl does: the
+// content after the first = is encoded and the name before it, if any, is
+// kept. Reading the content from a file via @ is not supported.
+func curlURLEncode(d string) (string, error) {
+	name, content := "", d
+	if i := strings.IndexAny(d, "=@"); i >= 0 {
+		if d[i] == '@' {
+			return "", fmt.Errorf("curl --data-urlencode %q reads a file, which is not supported", d)
+		}
+		name, content = d[:i], d[i+1:]
+	}
+
+	encoded := strings.Replace(url.QueryEscape(content), "+", "%20", -1)
+	if name == "" {
+		return encoded, nil
+	}
+	return name + "=" + encoded, nil
+}
+
+// splitShellWords splits a command line into arguments the way a POSIX shell
+// would, handling quotes, escapes and line continuations.
+func splitShellWords(s string) ([]string, error) {
+	var words []string
+	var word bytes.Buffer
+	inWord := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			if s[i] != '\n' {
+				word.WriteByte(s[i])
+				inWord = true
+			}
+		case c == '$' && i+1 < len(s) && s[i+1] == '\'':
+			// ANSI-C quoting, used by browsers when copying bodies with
+			// special characters.
+			i += 2
+			for ; i < len(s) && s[i] != '\''; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+					switch s[i] {
+					case 'n':
+						word.WriteByte('\n')
+					case 't':
+						word.WriteByte('\t')
+					case 'r':
+						word.WriteByte('\r')
+					default:
+						word.WriteByte(s[i])
+					}
+					continue
+				}
+				word.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated quote in %q", s)
+			}
+			inWord = true
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote in %q", s)
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`\n", s[i+1]) >= 0 {
+					i++
+					if s[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated double quote in %q", s)
+			}
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
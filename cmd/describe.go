@@ -0,0 +1,251 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Ericsson/jaq/openapi"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// loadedSpecs caches OpenAPI specs by path so that they are not reloaded for
+// every row of input.
+var loadedSpecs = map[string]*openapi.Spec{}
+
+// loadSpec loads the OpenAPI spec set by the openapi config key, if any.
+func loadSpec() (*openapi.Spec, error) {
+	path := viper.GetString("openapi")
+	if path == "" {
+		return nil, nil
+	}
+
+	if s, ok := loadedSpecs[path]; ok {
+		return s, nil
+	}
+
+	s, err := openapi.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	loadedSpecs[path] = s
+	return s, nil
+}
+
+// findOperation finds the operation of the spec for the request. Unknown paths
+// and methods are errors which suggest similar paths or the allowed methods.
+func findOperation(spec *openapi.Spec, method, path string) (*openapi.Operation, map[string]string, error) {
+	item, params := spec.Find(path)
+	if item == nil {
+		msg := fmt.Sprintf("%v is not a path in the OpenAPI spec", path)
+		if suggestions := spec.Suggest(path, 3); len(suggestions) > 0 {
+			msg += "; did you mean " + strings.Join(suggestions, ", ") + "?"
+		}
+		return nil, nil, errors.New(msg)
+	}
+
+	op := item.Operation(method)
+	if op == nil {
+		return nil, nil, fmt.Errorf("%v is not an operation of %v in the OpenAPI spec; expected one of: %v", method, item.Template, strings.Join(item.Methods(), ", "))
+	}
+	return op, params, nil
+}
+
+// validateOperation checks the request against its operation in the spec
+// before it is sent. Only requests under the base URL are checked.
+func validateOperation(conf config, req *http.Request) error {
+	// Requests elsewhere, e.g. to full URLs of other hosts, are not to the
+	// API the spec describes.
+	path, ok := relativePath(conf, req)
+	if !ok {
+		return nil
+	}
+	op, params, err := findOperation(conf.spec, req.Method, path)
+	if err != nil {
+		return &exitError{code: exitInputError, err: err}
+	}
+
+	var body []byte
+	switch {
+	case len(conf.filepath) > 0:
+		if body, err = ioutil.ReadFile(conf.filepath); err != nil {
+			return err
+		}
+	case len(conf.body) > 0:
+		body = []byte(conf.body)
+	}
+
+	if problems := op.ValidateRequest(params, req.URL.Query(), req.Header, body); len(problems) > 0 {
+		err := fmt.Errorf("request does not match the OpenAPI spec for %v %v; not sending request:\n  %v", op.Method, req.URL.Path, strings.Join(problems, "\n  "))
+		return &exitError{code: exitInputError, err: err}
+	}
+	return nil
+}
+
+func describeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "describe PATH",
+		Short: "Describe the operations of a path from the OpenAPI spec",
+		Long: `Describe the operations of a path from the OpenAPI spec set by the openapi
+config key, including their parameters, request body and response schemas. The
+path may be a template (/pets/{id}) or a concrete path (/pets/1).`,
+		Args: cobra.ExactArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			spec, err := loadSpec()
+			if err != nil {
+				return err
+			}
+			if spec == nil {
+				return fmt.Errorf("no OpenAPI spec configured; set the openapi config key")
+			}
+
+			item, _ := spec.Find(args[0])
+			if item == nil {
+				_, _, err := findOperation(spec, "", args[0])
+				return err
+			}
+
+			return describe(os.Stdout, spec, item)
+		},
+	}
+}
+
+// describe writes the operations of the path followed by the schemas they
+// reference.
+func describe(w io.Writer, spec *openapi.Spec, item *openapi.PathItem) error {
+	var refs []string
+	for i, op := range item.Operations {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+
+		fmt.Fprintf(w, "%v %v\n", op.Method, item.Template)
+		if op.Summary != "" {
+			fmt.Fprintf(w, "  %v\n", op.Summary)
+		}
+
+		if len(op.Parameters) > 0 {
+			fmt.Fprintln(w, "\n  Parameters:")
+			for _, p := range op.Parameters {
+				required := ""
+				if p.Required {
+					required = ", required"
+				}
+				fmt.Fprintf(w, "    %v (%v%v) %v", p.Name, p.In, required, schemaJSON(p.Schema))
+				refs = collectRefs(p.Schema, refs)
+				if p.Description != "" {
+					fmt.Fprintf(w, " - %v", p.Description)
+				}
+				fmt.Fprintln(w)
+			}
+		}
+
+		if op.RequestBody != nil {
+			required := ""
+			if op.RequestBody.Required {
+				required = " (required)"
+			}
+			fmt.Fprintf(w, "\n  Request body%v:\n", required)
+			for _, mt := range op.RequestBody.Content {
+				fmt.Fprintf(w, "    %v %v\n", mt.Type, schemaJSON(mt.Schema))
+				refs = collectRefs(mt.Schema, refs)
+			}
+		}
+
+		if len(op.Responses) > 0 {
+			fmt.Fprintln(w, "\n  Responses:")
+			for _, r := range op.Responses {
+				fmt.Fprintf(w, "    %v %v\n", r.Status, r.Description)
+				for _, mt := range r.Content {
+					fmt.Fprintf(w, "      %v %v\n", mt.Type, schemaJSON(mt.Schema))
+					refs = collectRefs(mt.Schema, refs)
+				}
+			}
+		}
+	}
+
+	// Referenced schemas may reference others in turn; refs grows as they
+	// are written.
+	if len(refs) > 0 {
+		fmt.Fprintln(w, "\nSchemas:")
+	}
+	for i := 0; i < len(refs); i++ {
+		s, err := spec.Resolve(refs[i])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "  %v %v\n", refs[i], schemaJSON(s))
+		refs = collectRefs(s, refs)
+	}
+	return nil
+}
+
+// collectRefs appends each $ref within the schema which is not already in refs.
+func collectRefs(s interface{}, refs []string) []string {
+	switch n := s.(type) {
+	case map[string]interface{}:
+		if ref, ok := n["$ref"].(string); ok {
+			found := false
+			for _, r := range refs {
+				found = found || r == ref
+			}
+			if !found {
+				refs = append(refs, ref)
+			}
+		}
+		keys := make([]string, 0, len(n))
+		for k := range n {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			refs = collectRefs(n[k], refs)
+		}
+	case []interface{}:
+		for _, v := range n {
+			refs = collectRefs(v, refs)
+		}
+	}
+	return refs
+}
+
+func schemaJSON(s interface{}) string {
+	if s == nil {
+		return "{}"
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Sprint(s)
+	}
+	return string(b)
+}
+
+func init() {
+	ResetSettingsOpenAPI()
+}
+
+func ResetSettingsOpenAPI() {
+	RootCmd.AddCommand(describeCommand(), completionCommand(), completeCommand())
+}
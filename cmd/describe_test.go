@@ -0,0 +1,140 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestOpenAPI(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	sent := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sent++
+		w.Write([]byte(`{}`))
+	}))
+	defer s.Close()
+
+	testCases := []struct {
+		desc string
+		args []string
+
+		expectSent   int
+		expectStdout string
+		expectErr    string
+	}{
+		{
+			desc:         "valid request",
+			args:         []string{"get", "/pets", "-q", "limit=5"},
+			expectSent:   1,
+			expectStdout: "{}\n",
+		}, {
+			desc:      "unknown path suggests similar paths",
+			args:      []string{"get", "/pet/3"},
+			expectErr: "/pet/3 is not a path in the OpenAPI spec; did you mean /pets/{id}, /pets, /pets/mine?",
+		}, {
+			desc:         "other hosts are not validated",
+			args:         []string{"get", strings.Replace(s.URL, "127.0.0.1", "localhost", 1) + "/elsewhere"},
+			expectSent:   1,
+			expectStdout: "{}\n",
+		}, {
+			desc:      "unknown method",
+			args:      []string{"put", "/pets/3"},
+			expectErr: "PUT is not an operation of /pets/{id} in the OpenAPI spec; expected one of: GET, DELETE",
+		}, {
+			desc:      "invalid request is not sent",
+			args:      []string{"post", "/pets", "--body", `{"name":""}`, "-q", "x=1"},
+			expectErr: "request does not match the OpenAPI spec for POST /pets; not sending request:\n  request body: /name: length 0 is less than minLength 1",
+		}, {
+			desc:      "validated in dry-run",
+			args:      []string{"get", "/pets/0", "--dry-run"},
+			expectErr: "request does not match the OpenAPI spec for GET /pets/0; not sending request:\n  path parameter \"id\": 0 is less than minimum 1",
+		}, {
+			desc: "describe",
+			args: []string{"describe", "/pets/1"},
+			expectStdout: `GET /pets/{id}
+  Get a pet
+
+  Parameters:
+    id (path, required) {"minimum":1,"type":"integer"}
+
+  Responses:
+    200 A pet
+
+DELETE /pets/{id}
+  Delete a pet
+
+  Parameters:
+    X-Reason (header, required) {"type":"string"}
+    id (path, required) {"minimum":1,"type":"integer"}
+
+  Responses:
+    204 Deleted
+`,
+		}, {
+			desc:         "describe with base path",
+			args:         []string{"describe", "/v1/pets/mine"},
+			expectStdout: "GET /pets/mine\n  List my pets\n\n  Responses:\n    200 My pets\n",
+		}, {
+			desc:         "complete path",
+			args:         []string{"__jaq_complete", "path", "/pets/4/"},
+			expectStdout: "/pets/4/toys\n",
+		}, {
+			desc:         "complete query",
+			args:         []string{"__jaq_complete", "query", "get", "/pets"},
+			expectStdout: "limit=\ntags=\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ResetSettings()
+			viper.Set("scheme", "http")
+			viper.Set("domain", s.Listener.Addr().String())
+			viper.Set("openapi", "../openapi/testdata/petstore.yaml")
+			sent = 0
+
+			stdout, _, err := captureOutput(execute, tc.args, nil)
+			switch {
+			case err == nil && tc.expectErr != "":
+				t.Errorf("Expected error %q but got none", tc.expectErr)
+			case err != nil && err.Error() != tc.expectErr:
+				t.Errorf("Expected error %q, got %q", tc.expectErr, err)
+			}
+
+			if sent != tc.expectSent {
+				t.Errorf("Expected %v requests to be sent, got %v", tc.expectSent, sent)
+			}
+			if stdout != tc.expectStdout {
+				t.Errorf("Expected stdout %q, got %q", tc.expectStdout, stdout)
+			}
+		})
+	}
+}
@@ -0,0 +1,457 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// diffSettings are the settings which may differ between the sides of a diff.
+var diffSettings = []string{"scheme", "subdomain", "domain", "base-url", "base-path", "auth", "user", "pass", "token"}
+
+// diffSharedSettings are the settings of the connection and headers, which are
+// shared by both sides of a diff.
+var diffSharedSettings = []string{"proxy", "socks5", "proxy-user", "proxy-pass", "no-proxy", "resolve", "unix-socket", "header", "headers"}
+
+func init() {
+	ResetSettingsDiff()
+}
+
+// ResetSettingsDiff adds the diff command.
+func ResetSettingsDiff() {
+	RootCmd.AddCommand(diffCommand())
+}
+
+func diffCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "diff",
+		Short: "Send a request to two environments and show how the responses differ.",
+		Long: `Sends the request to the left and right sides and prints the differences
+between their status and JSON bodies, one changed path per line:
+
+  ~ path: left -> right   changed
+  - path: left            only on the left
+  + path: right           only on the right
+
+Each side is given as KEY=VALUE settings. profile=FILE uses the host and auth
+settings of the config file for the side. FILE is the path of the file, as
+given to --config, e.g. profile=prod.json or profile=~/.jaq-prod.json, not a
+name. Other keys override a single setting, e.g. base-url=https://v2.example.com.
+
+jaq exits with code 6 if any responses differ and 0 otherwise, even if both
+sides failed the same way. Errors which stop the diff, such as a connection
+failure, exit as they would for a single request.`,
+		Example: `  jaq diff get /users/1 --left profile=prod.json --right profile=staging.json
+  jaq diff get /users --right base-path=/v2 --ignore '**.updatedAt'`,
+	}
+
+	fs := c.PersistentFlags()
+	fs.StringSliceP("left", "", []string{}, "Comma-separated KEY=VALUE settings of the left side (e.g. profile=prod.json)")
+	fs.StringSliceP("right", "", []string{}, "Comma-separated KEY=VALUE settings of the right side (e.g. profile=staging.json)")
+	fs.StringSliceP("ignore", "", []string{}, "Comma-separated paths to ignore; * matches one key or index and ** any number (e.g. **.updatedAt,items.*.id)")
+	viper.BindPFlag("ignore", fs.Lookup("ignore"))
+
+	for _, verbCmd := range httpCommands() {
+		c.AddCommand(diffVerbCommand(strings.ToUpper(verbCmd.Name())))
+	}
+	return c
+}
+
+func diffVerbCommand(verb string) *cobra.Command {
+	return &cobra.Command{
+		Use:   strings.ToLower(verb),
+		Short: fmt.Sprintf("Diff the responses to a %v request.", verb),
+		Args:  cobra.ExactArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conf, err := newConfig(cmd)
+			if err != nil {
+				return err
+			}
+			// Each side is a single request; fanning out is not supported.
+			conf.targets = nil
+			conf.stream = false
+			stats.comparing = true
+
+			var sides [2]diffSide
+			for i, name := range []string{"left", "right"} {
+				entries, err := cmd.Flags().GetStringSlice(name)
+				if err != nil {
+					return err
+				}
+				if sides[i], err = parseDiffSide(name, entries); err != nil {
+					return err
+				}
+			}
+
+			ignore := viper.GetStringSlice("ignore")
+			if err := validateIgnore(ignore); err != nil {
+				return err
+			}
+
+			return runDiff(conf, verb, args[0], sides, ignore)
+		},
+	}
+}
+
+// diffSide is one side of a diff: the settings which differ from those of the
+// command.
+type diffSide struct {
+	label    string
+	settings map[string]string
+}
+
+// parseDiffSide parses the KEY=VALUE entries of the --left or --right flag. The
+// settings of a profile are all used, with unset ones left empty, so that the
+// side is configured just as if the profile had been given with --config.
+// Other keys take precedence over the profile.
+func parseDiffSide(flag string, entries []string) (diffSide, error) {
+	side := diffSide{label: flag, settings: map[string]string{}}
+	overrides := map[string]string{}
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return side, fmt.Errorf("invalid --%v %q, expected KEY=VALUE", flag, entry)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+		switch {
+		case key == "profile":
+			settings, err := readProfile(value)
+			if err != nil {
+				return side, err
+			}
+			side.label = value
+			for k, v := range settings {
+				side.settings[k] = v
+			}
+		case validDiffSetting(key):
+			overrides[key] = value
+		default:
+			return side, fmt.Errorf("invalid --%v %q, expected profile or one of %v", flag, entry, strings.Join(diffSettings, ", "))
+		}
+	}
+
+	for k, v := range overrides {
+		side.settings[k] = v
+	}
+	return side, nil
+}
+
+func validDiffSetting(key string) bool {
+	for _, s := range diffSettings {
+		if s == key {
+			return true
+		}
+	}
+	return false
+}
+
+// readProfile reads the diff settings from the config file of the profile.
+// The transport is shared by both sides, so profiles which set up connections
+// differently from the usual config are rejected rather than silently sent the
+// same way.
+func readProfile(file string) (map[string]string, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+	v.SetDefault("scheme", "https")
+	if err := v.ReadInConfig(); err != nil {
+		if _, statErr := os.Stat(file); os.IsNotExist(statErr) && filepath.Ext(file) == "" {
+			return nil, fmt.Errorf("unable to read profile %q: profile takes the path of a config file, e.g. profile=%v.json", file, file)
+		}
+		return nil, fmt.Errorf("unable to read profile %q: %v", file, err)
+	}
+
+	for _, k := range diffSharedSettings {
+		if v.IsSet(k) && fmt.Sprint(v.Get(k)) != fmt.Sprint(viper.Get(k)) {
+			return nil, fmt.Errorf("profile %q sets %v, which can not differ between the sides of a diff", file, k)
+		}
+	}
+
+	settings := map[string]string{}
+	for _, k := range diffSettings {
+		settings[k] = v.GetString(k)
+	}
+	return settings, nil
+}
+
+// apply returns the configuration of the request for the side.
+func (s diffSide) apply(conf config) config {
+	for k, v := range s.settings {
+		switch k {
+		case "scheme":
+			conf.scheme = v
+		case "subdomain":
+			conf.subdomain = v
+		case "domain":
+			conf.domain = v
+		case "base-url":
+			conf.baseURL = v
+		case "base-path":
+			conf.basePath = v
+		case "auth":
+			conf.auth = v
+		case "user":
+			conf.user = v
+		case "pass":
+			conf.pass = v
+		case "token":
+			conf.token = v
+		}
+	}
+	return conf
+}
+
+// runDiff sends the request to both sides and writes their differences.
+func runDiff(conf config, verb, path string, sides [2]diffSide, ignore []string) error {
+	if err := expandSessionConfig(&conf, &path); err != nil {
+		return err
+	}
+	if err := resolveSchemas(&conf, verb, path); err != nil {
+		return err
+	}
+	if err := validateRequestBody(conf); err != nil {
+		return err
+	}
+
+	var statuses [2]int
+	var bodies [2]*bytes.Buffer
+	for i, side := range sides {
+		sconf := side.apply(conf)
+		bodies[i] = &bytes.Buffer{}
+		// Dry-run output is written as usual rather than compared.
+		if conf.dryRun == dryRunOff {
+			sconf.stdout = bodies[i]
+			// Bodies reported as failures are still written to stderr.
+			sconf.stderr = io.MultiWriter(conf.stderr, bodies[i])
+		}
+
+		var err error
+		statuses[i], err = send(sconf, func() (*http.Request, error) {
+			return newRequest(sconf, path)
+		})
+		if e, ok := err.(*exitError); ok {
+			return &exitError{code: e.code, err: fmt.Errorf("%v: %v", side.label, e.err)}
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %v", side.label, err)
+		}
+	}
+
+	// Nothing was sent so there is nothing to compare.
+	if conf.dryRun != dryRunOff {
+		return nil
+	}
+
+	var changes []jsonChange
+	if statuses[0] != statuses[1] {
+		changes = append(changes, jsonChange{op: '~', path: "status", left: statuses[0], right: statuses[1]})
+	}
+	// Silenced bodies are not written so are not compared.
+	if bodies[0].Len() > 0 && bodies[1].Len() > 0 {
+		changes = append(changes, diffJSON(parseBody(bodies[0].Bytes()), parseBody(bodies[1].Bytes()), ignore)...)
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	stats.differed()
+	fmt.Fprintf(conf.stdout, "--- %v %v %v\n+++ %v %v %v\n", sides[0].label, verb, path, sides[1].label, verb, path)
+	return writeChanges(conf.stdout, changes)
+}
+
+// parseBody parses a JSON body, or returns it as a string if it is not JSON.
+func parseBody(b []byte) interface{} {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return strings.TrimSpace(string(b))
+	}
+	return v
+}
+
+// jsonChange is a difference at a single path of two JSON values. The op is
+// one of ~ (changed), - (only on the left) or + (only on the right).
+type jsonChange struct {
+	op          byte
+	path        string
+	segments    []string
+	left, right interface{}
+}
+
+// diffJSON returns the differences between the values, ordered by path, except
+// for those at paths matching the ignore patterns.
+func diffJSON(left, right interface{}, ignore []string) []jsonChange {
+	var changes []jsonChange
+	diffValues(nil, left, right, ignore, &changes)
+	return changes
+}
+
+func diffValues(p []string, left, right interface{}, ignore []string, changes *[]jsonChange) {
+	if ignored(p, ignore) {
+		return
+	}
+
+	switch l := left.(type) {
+	case map[string]interface{}:
+		r, ok := right.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := []string{}
+		for k := range l {
+			keys = append(keys, k)
+		}
+		for k := range r {
+			if _, ok := l[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			lv, inLeft := l[k]
+			rv, inRight := r[k]
+			kp := append(p[:len(p):len(p)], k)
+			switch {
+			case ignored(kp, ignore):
+			case !inRight:
+				*changes = append(*changes, jsonChange{op: '-', path: formatPath(kp), segments: kp, left: lv})
+			case !inLeft:
+				*changes = append(*changes, jsonChange{op: '+', path: formatPath(kp), segments: kp, right: rv})
+			default:
+				diffValues(kp, lv, rv, ignore, changes)
+			}
+		}
+		return
+	case []interface{}:
+		r, ok := right.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(l) || i < len(r); i++ {
+			ip := append(p[:len(p):len(p)], strconv.Itoa(i))
+			switch {
+			case ignored(ip, ignore):
+			case i >= len(r):
+				*changes = append(*changes, jsonChange{op: '-', path: formatPath(ip), segments: ip, left: l[i]})
+			case i >= len(l):
+				*changes = append(*changes, jsonChange{op: '+', path: formatPath(ip), segments: ip, right: r[i]})
+			default:
+				diffValues(ip, l[i], r[i], ignore, changes)
+			}
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(left, right) {
+		*changes = append(*changes, jsonChange{op: '~', path: formatPath(p), segments: p, left: left, right: right})
+	}
+}
+
+// formatPath formats the path in the dot notation used for fields elsewhere;
+// the root is ".".
+func formatPath(p []string) string {
+	if len(p) == 0 {
+		return "."
+	}
+	return strings.Join(p, ".")
+}
+
+// ignored reports whether the path matches any of the ignore patterns.
+func ignored(p []string, ignore []string) bool {
+	for _, pattern := range ignore {
+		if matchPath(strings.Split(pattern, "."), p) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath matches the path against the pattern segments. * matches a single
+// key or index and ** any number of them.
+func matchPath(pattern, p []string) bool {
+	if len(pattern) == 0 {
+		return len(p) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(p); i++ {
+			if matchPath(pattern[1:], p[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(p) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], p[0])
+	return ok && matchPath(pattern[1:], p[1:])
+}
+
+// validateIgnore checks the ignore patterns are valid.
+func validateIgnore(ignore []string) error {
+	for _, pattern := range ignore {
+		for _, segment := range strings.Split(pattern, ".") {
+			if _, err := path.Match(segment, ""); err != nil || segment == "" {
+				return fmt.Errorf("invalid ignore pattern %q", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// writeChanges writes a line for each change.
+func writeChanges(w io.Writer, changes []jsonChange) error {
+	for _, c := range changes {
+		var err error
+		switch c.op {
+		case '-':
+			_, err = fmt.Fprintf(w, "- %v: %v\n", c.path, formatValue(c.left))
+		case '+':
+			_, err = fmt.Fprintf(w, "+ %v: %v\n", c.path, formatValue(c.right))
+		default:
+			_, err = fmt.Fprintf(w, "~ %v: %v -> %v\n", c.path, formatValue(c.left), formatValue(c.right))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatValue formats the value as compact JSON.
+func formatValue(v interface{}) string {
+	b := &bytes.Buffer{}
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,222 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestDiff(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v1/users/1":
+			fmt.Fprint(w, `{"id":1,"name":"a","updatedAt":"t1","tags":["x","y"]}`)
+		case "/v2/users/1":
+			fmt.Fprint(w, `{"id":1,"name":"b","updatedAt":"t2","tags":["x"],"email":"e"}`)
+		case "/v2/missing":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"err":"not found"}`)
+		case "/v1/broken", "/v2/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, `{"err":%q}`, path.Dir(req.URL.Path))
+		default:
+			fmt.Fprint(w, `{"id":2}`)
+		}
+	}))
+	defer s.Close()
+
+	staging := filepath.Join(tmpDir, "staging.json")
+	ioutil.WriteFile(staging, []byte(fmt.Sprintf(`{"scheme":"http","domain":%q,"base-path":"/v2"}`, s.Listener.Addr().String())), 0777)
+	proxied := filepath.Join(tmpDir, "proxied.json")
+	ioutil.WriteFile(proxied, []byte(`{"proxy":"http://proxy.example.com:3128"}`), 0777)
+
+	users1Diff := `--- left GET /users/1
++++ right GET /users/1
++ email: "e"
+~ name: "a" -> "b"
+- tags.1: "y"
+`
+
+	testCases := []struct {
+		desc  string
+		args  []string
+		input string
+
+		expectStdout string
+		expectStderr string
+		expectErr    string
+		expectCode   int
+	}{
+		{
+			desc:         "differences",
+			args:         []string{"diff", "get", "/users/1", "--left", "base-path=/v1", "--right", "base-path=/v2", "--ignore", "**.updatedAt"},
+			expectStdout: users1Diff,
+			expectCode:   exitDifferences,
+		}, {
+			desc:         "without ignore",
+			args:         []string{"diff", "get", "/users/1", "--left", "base-path=/v1", "--right", "base-path=/v2"},
+			expectStdout: strings.Replace(users1Diff, "- tags.1: \"y\"\n", "- tags.1: \"y\"\n~ updatedAt: \"t1\" -> \"t2\"\n", 1),
+			expectCode:   exitDifferences,
+		}, {
+			desc:       "no differences",
+			args:       []string{"diff", "get", "/users/2", "--left", "base-path=/v1", "--right", "base-path=/v2"},
+			expectCode: exitOK,
+		}, {
+			desc:         "profile",
+			args:         []string{"diff", "get", "/users/1", "--left", "base-path=/v1", "--right", "profile=" + staging, "--ignore", "updatedAt,tags.*"},
+			expectStdout: "--- left GET /users/1\n+++ " + staging + " GET /users/1\n+ email: \"e\"\n~ name: \"a\" -> \"b\"\n",
+			expectCode:   exitDifferences,
+		}, {
+			desc:      "profile with its own proxy",
+			args:      []string{"diff", "get", "/users/1", "--right", "profile=" + proxied},
+			expectErr: fmt.Sprintf("profile %q sets proxy, which can not differ between the sides of a diff", proxied),
+		}, {
+			desc:         "reported failures",
+			args:         []string{"diff", "get", "/broken", "--left", "base-path=/v1", "--right", "base-path=/v2", "--on-error", "report"},
+			expectStdout: "--- left GET /broken\n+++ right GET /broken\n~ err: \"/v1\" -> \"/v2\"\n",
+			expectStderr: `{"err":"/v1"}` + "\n" + `{"err":"/v2"}`,
+			expectCode:   exitDifferences,
+		}, {
+			desc:         "same failure on both sides",
+			args:         []string{"diff", "get", "/missing", "--left", "base-path=/v2", "--right", "base-path=/v2"},
+			expectStderr: `{"err":"not found"}`,
+			expectCode:   exitOK,
+		}, {
+			desc:         "piped input",
+			args:         []string{"diff", "get", "/users/${1.id}", "--left", "base-path=/v1", "--right", "base-path=/v2", "--ignore", "**.updatedAt"},
+			input:        `{"id":1} {"id":2}`,
+			expectStdout: users1Diff,
+			expectCode:   exitDifferences,
+		}, {
+			desc:         "status",
+			args:         []string{"diff", "get", "/missing", "--left", "base-path=/v1", "--right", "base-path=/v2"},
+			expectStdout: "--- left GET /missing\n+++ right GET /missing\n~ status: 200 -> 404\n+ err: \"not found\"\n- id: 2\n",
+			expectStderr: `{"err":"not found"}`,
+			expectCode:   exitDifferences,
+		}, {
+			desc:         "dry run",
+			args:         []string{"diff", "get", "/users/1", "--left", "base-path=/v1", "--right", "base-path=/v2", "--dry-run=jaq"},
+			expectStdout: "jaq diff get /users/1\njaq diff get /users/1\n",
+		}, {
+			desc:      "invalid side",
+			args:      []string{"diff", "get", "/users/1", "--left", "host=prod"},
+			expectErr: `invalid --left "host=prod", expected profile or one of scheme, subdomain, domain, base-url, base-path, auth, user, pass, token`,
+		}, {
+			desc:      "invalid ignore",
+			args:      []string{"diff", "get", "/users/1", "--ignore", "a..b"},
+			expectErr: `invalid ignore pattern "a..b"`,
+		}, {
+			desc:      "profile name rather than file",
+			args:      []string{"diff", "get", "/users/1", "--left", "profile=prod"},
+			expectErr: `unable to read profile "prod": profile takes the path of a config file, e.g. profile=prod.json`,
+		}, {
+			desc:      "missing profile",
+			args:      []string{"diff", "get", "/users/1", "--left", "profile=" + filepath.Join(tmpDir, "prod.json")},
+			expectErr: fmt.Sprintf("unable to read profile %q: open %v: no such file or directory", filepath.Join(tmpDir, "prod.json"), filepath.Join(tmpDir, "prod.json")),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ResetSettings()
+			viper.Set("scheme", "http")
+			viper.Set("domain", s.Listener.Addr().String())
+
+			var input io.Reader
+			if tc.input != "" {
+				input = strings.NewReader(tc.input)
+			}
+
+			stdout, stderr, err := captureOutput(execute, tc.args, input)
+			switch {
+			case err == nil && tc.expectErr != "":
+				t.Errorf("Expected error %q but got none", tc.expectErr)
+			case err != nil && err.Error() != tc.expectErr:
+				t.Errorf("Expected error %q, got %q", tc.expectErr, err)
+			}
+			if err == nil {
+				if code := stats.exitCode(err); code != tc.expectCode {
+					t.Errorf("Expected exit code %v, got %v", tc.expectCode, code)
+				}
+			}
+
+			if stdout != tc.expectStdout {
+				t.Errorf("Expected stdout %q, got %q", tc.expectStdout, stdout)
+			}
+			if !strings.Contains(stderr, tc.expectStderr) {
+				t.Errorf("Expected stderr to contain %q, got %q", tc.expectStderr, stderr)
+			}
+		})
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	left := map[string]interface{}{
+		"id":    1.0,
+		"items": []interface{}{map[string]interface{}{"id": "a", "n": 1.0}, map[string]interface{}{"id": "b", "n": 2.0}},
+		"meta":  map[string]interface{}{"at": "t1"},
+	}
+	right := map[string]interface{}{
+		"id":    "1",
+		"items": []interface{}{map[string]interface{}{"id": "c", "n": 1.0}},
+		"meta":  map[string]interface{}{"at": "t2"},
+	}
+
+	testCases := []struct {
+		ignore []string
+		expect []string
+	}{
+		{
+			expect: []string{"id", "items.0.id", "items.1", "meta.at"},
+		}, {
+			ignore: []string{"**.id"},
+			expect: []string{"items.1", "meta.at"},
+		}, {
+			ignore: []string{"items.*.id", "meta"},
+			expect: []string{"id", "items.1"},
+		}, {
+			ignore: []string{"**"},
+		},
+	}
+	for _, tc := range testCases {
+		var paths []string
+		for _, c := range diffJSON(left, right, tc.ignore) {
+			paths = append(paths, c.path)
+		}
+		if !reflect.DeepEqual(paths, tc.expect) {
+			t.Errorf("Ignoring %v: expected changes at %v, got %v", tc.ignore, tc.expect, paths)
+		}
+	}
+}
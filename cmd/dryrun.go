@@ -0,0 +1,255 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	dryRunOff  = ""
+	dryRunText = "true"
+	dryRunJaq  = "jaq"
+	dryRunCurl = "curl"
+	dryRunJSON = "json"
+)
+
+// plannedRequest is the structured representation of a fully resolved request.
+// It is what gets printed with --dry-run=json and should contain everything
+// needed to reproduce the request without the original config or input.
+type plannedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+	File   string      `json:"file,omitempty"`
+
+	// FileHash is the SHA-256 of the file so that apply can refuse to send
+	// contents which were not reviewed.
+	FileHash string `json:"fileSha256,omitempty"`
+}
+
+// dryRunMode normalizes the value of the dry-run setting. Boolean values are
+// still accepted since that is how the setting was originally defined.
+func dryRunMode(s string) (string, error) {
+	switch strings.ToLower(s) {
+	case "", "false", "0":
+		return dryRunOff, nil
+	case "true", "1", "text":
+		return dryRunText, nil
+	case dryRunJaq, dryRunCurl, dryRunJSON:
+		return strings.ToLower(s), nil
+	default:
+		return "", fmt.Errorf("invalid dry-run mode %q, expected one of: true, jaq, curl, json", s)
+	}
+}
+
+// newPlannedRequest captures the request as it would be sent. The body is taken
+// from the configuration rather than the request so that the request body
+// remains unread.
+func newPlannedRequest(conf config, req *http.Request) (plannedRequest, error) {
+	p := plannedRequest{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: req.Header,
+	}
+
+	switch {
+	case len(conf.filepath) > 0:
+		b, err := ioutil.ReadFile(conf.filepath)
+		if err != nil {
+			return p, err
+		}
+		p.File = conf.filepath
+		p.FileHash = sha256Hex(b)
+	case len(conf.body) > 0:
+		p.Body = conf.body
+	}
+
+	return p, nil
+}
+
+// printDryRun writes the request to w in the format specified by the dry-run
+// mode instead of executing it.
+func printDryRun(w io.Writer, conf config, req *http.Request) error {
+	var line string
+	switch conf.dryRun {
+	case dryRunJaq:
+		line = jaqCommand(conf, req)
+	case dryRunCurl:
+		line = curlCommand(conf, req)
+	case dryRunJSON:
+		p, err := newPlannedRequest(conf, req)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		line = string(b)
+	default:
+		line = "DRYRUN: " + textCommand(conf, req)
+	}
+
+	_, err := fmt.Fprintln(w, line)
+	return err
+}
+
+// textCommand is the human-readable form of the command. Values are not quoted
+// for the shell.
+func textCommand(conf config, req *http.Request) string {
+	// Flags get stripped from args; add back the ones relevent to the actual
+	// request.
+	display := bytes.NewBufferString(conf.commandPath + " " + commandArg(conf, req))
+
+	if len(req.URL.RawQuery) > 0 {
+		display.WriteString(" --query ")
+		display.WriteString(req.URL.RawQuery)
+	}
+
+	if len(conf.headers) > 0 {
+		display.WriteString(" --header ")
+		display.WriteString(strings.Join(conf.headers, ","))
+	}
+
+	switch {
+	case len(conf.filepath) > 0:
+		display.WriteString(" --file ")
+		display.WriteString(conf.filepath)
+	case len(conf.body) > 0:
+		display.WriteString(" --body ")
+		display.WriteString(conf.body)
+	}
+
+	return display.String()
+}
+
+// commandArg is the path argument of the command for the request: the path
+// relative to the base URL, or the full URL of requests elsewhere.
+func commandArg(conf config, req *http.Request) string {
+	if path, ok := relativePath(conf, req); ok {
+		return path
+	}
+	u := *req.URL
+	u.RawQuery = ""
+	return u.String()
+}
+
+// jaqCommand is a jaq invocation which can be pasted back into the shell to
+// run the same request given the same configuration file.
+func jaqCommand(conf config, req *http.Request) string {
+	parts := append(strings.Fields(conf.commandPath), shellQuote(commandArg(conf, req)))
+
+	if len(req.URL.RawQuery) > 0 {
+		parts = append(parts, "--query", shellQuote(req.URL.RawQuery))
+	}
+
+	for _, h := range conf.headers {
+		parts = append(parts, "--header", shellQuote(headerFlagValue(h)))
+	}
+
+	switch {
+	case len(conf.filepath) > 0:
+		parts = append(parts, "--file", shellQuote(conf.filepath))
+	case len(conf.body) > 0:
+		parts = append(parts, "--body", shellQuote(conf.body))
+	}
+
+	if conf.stream {
+		parts = append(parts, "--stream")
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// curlCommand is a curl invocation for the fully resolved request, including
+// the host and any authorization headers.
+func curlCommand(conf config, req *http.Request) string {
+	parts := []string{"curl"}
+
+	if req.Method == http.MethodHead {
+		parts = append(parts, "--head")
+	} else {
+		parts = append(parts, "-X", req.Method)
+	}
+
+	keys := make([]string, 0, len(req.Header))
+	for k := range req.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range req.Header[k] {
+			parts = append(parts, "-H", shellQuote(k+": "+v))
+		}
+	}
+
+	switch {
+	case len(conf.filepath) > 0:
+		parts = append(parts, "--data-binary", shellQuote("@"+conf.filepath))
+	case len(conf.body) > 0:
+		parts = append(parts, "--data-binary", shellQuote(conf.body))
+	}
+
+	switch {
+	case conf.stream:
+		parts = append(parts, "--no-buffer")
+	case conf.requestTimeout > 0:
+		parts = append(parts, "--max-time", strconv.Itoa(conf.requestTimeout))
+	}
+
+	parts = append(parts, shellQuote(req.URL.String()))
+	return strings.Join(parts, " ")
+}
+
+// headerFlagValue quotes a header for --header, whose values are split on
+// commas, so that headers such as "Accept=text/html,application/json" are
+// kept whole.
+func headerFlagValue(h string) string {
+	if !strings.ContainsAny(h, `,"`) {
+		return h
+	}
+	return `"` + strings.Replace(h, `"`, `""`, -1) + `"`
+}
+
+// shellQuote quotes s for a POSIX shell. Strings made up of only safe
+// characters are left as-is for readability.
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+
+	safe := true
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=,@%+", r)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
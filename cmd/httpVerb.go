@@ -16,8 +16,11 @@ package cmd
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httputil"
@@ -27,6 +30,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Ericsson/jaq/openapi"
+	"github.com/Ericsson/jaq/schema"
 	"github.com/Jeffail/gabs"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -40,7 +45,9 @@ const (
 // vars, and config values.
 type config struct {
 	commandPath               string
+	stdout, stderr            io.Writer
 	scheme, subdomain, domain string
+	baseURL, basePath         string
 	verb                      string
 	query                     string
 	headers                   []string
@@ -48,12 +55,28 @@ type config struct {
 	trace, debug              bool
 	filepath                  string
 	body                      string
-	dryRun                    bool
+	dryRun                    string
 	explode                   bool
 	printHeaders              bool
 	requestTimeout            int
+	readTimeout               time.Duration
 	user, pass, token         string
-	onError                   string
+	onStatus                  statusPolicy
+	retries                   int
+	retryWait                 time.Duration
+	expect                    expectations
+	captures                  []sessionCapture
+	wait                      waitConfig
+	stream                    bool
+	streamReconnects          int
+	targets                   []target
+	target                    string
+	compare                   bool
+
+	requestSchemaPath, responseSchemaPath string
+	schemaMappings                        []schemaMapping
+	requestSchema, responseSchema         *schema.Schema
+	spec                                  *openapi.Spec
 }
 
 // httpCommand is a generator of *cobra.Commands which only differ by their HTTP
@@ -84,20 +107,31 @@ func httpCommand(httpVerb string) *cobra.Command {
 // httpRun is the shared logic of all the HTTP commands but has configuration
 // and input transformation logic extracted.
 func httpRun(conf config, verb string, path string) error {
-	req, err := newRequest(conf, path)
-	if err != nil {
+	// Session values are resolved up front so that the body is validated, and
+	// shown by dry-run and plan, as it will be sent.
+	if err := expandSessionConfig(&conf, &path); err != nil {
 		return err
 	}
-
-	resp, err := response(conf, req)
-	if err != nil {
+	if err := resolveSchemas(&conf, verb, path); err != nil {
+		return err
+	}
+	if err := validateRequestBody(conf); err != nil {
 		return err
 	}
 
-	return processResponse(conf, resp)
+	if len(conf.targets) > 0 {
+		return sendTargets(conf, path)
+	}
+
+	_, err := send(conf, func() (*http.Request, error) {
+		return newRequest(conf, path)
+	})
+	return err
 }
 
-func processResponse(conf config, resp *http.Response) error {
+// processResponse writes the response body according to the action chosen by
+// the status policy.
+func processResponse(conf config, resp *http.Response, action string) error {
 	if resp == nil {
 		return nil
 	}
@@ -108,30 +142,32 @@ func processResponse(conf config, resp *http.Response) error {
 		copyHeaders = resp.Header
 	}
 
-	if resp.StatusCode < 400 {
-		if _, err := copyNewline(os.Stdout, resp.Body, copyHeaders); err != nil {
+	var body io.Reader = resp.Body
+	if conf.target != "" && action != actionSilence {
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
 			return err
 		}
-	} else {
-		switch conf.onError {
-		case "silence":
-		case "fatal":
-			if _, err := copyNewline(os.Stderr, resp.Body, copyHeaders); err != nil {
-				return err
-			}
-			return fmt.Errorf("Unexpected status from response: %v", resp.Status)
-		case "continue":
-			if _, err := copyNewline(os.Stdout, resp.Body, copyHeaders); err != nil {
-				return err
-			}
-		case "report":
-			if _, err := copyNewline(os.Stderr, resp.Body, copyHeaders); err != nil {
-				return err
-			}
-		default:
-			if _, err := copyNewline(os.Stdout, resp.Body, copyHeaders); err != nil {
-				return err
-			}
+		if b, err = tagTarget(b, conf.target); err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	switch action {
+	case actionSilence:
+	case actionFatal:
+		if _, err := copyNewline(conf.stderr, body, copyHeaders); err != nil {
+			return err
+		}
+		return fmt.Errorf("Unexpected status from response: %v", resp.Status)
+	case actionReport:
+		if _, err := copyNewline(conf.stderr, body, copyHeaders); err != nil {
+			return err
+		}
+	default:
+		if _, err := copyNewline(conf.stdout, body, copyHeaders); err != nil {
+			return err
 		}
 	}
 
@@ -142,9 +178,7 @@ func processResponse(conf config, resp *http.Response) error {
 // modified. If trace/debug are set the request/responses are logged. If dryrun
 // is set then the request is not actually executed.
 func response(conf config, req *http.Request) (*http.Response, error) {
-	c := &http.Client{
-		Timeout: time.Duration(conf.requestTimeout) * time.Second,
-	}
+	c := newClient(conf)
 
 	if conf.trace || conf.debug {
 		dump, err := httputil.DumpRequestOut(req, conf.debug)
@@ -159,36 +193,14 @@ func response(conf config, req *http.Request) (*http.Response, error) {
 		log.Printf("Sending request: %v%v", string(dump), bodyMsg)
 	}
 
-	if conf.dryRun {
-		// Flags get stripped from args; add back the ones relevent to
-		// the actual request.
-		display := bytes.NewBufferString(conf.commandPath + " " + req.URL.Path)
-
-		if len(req.URL.RawQuery) > 0 {
-			display.WriteString(" --query ")
-			display.WriteString(req.URL.RawQuery)
-		}
-
-		if len(conf.headers) > 0 {
-			display.WriteString(" --headers ")
-			display.WriteString(strings.Join(conf.headers, ","))
-		}
-
-		switch {
-		case len(conf.filepath) > 0:
-			display.WriteString(" --file ")
-			display.WriteString(conf.filepath)
-		case len(conf.body) > 0:
-			display.WriteString(" --body ")
-			display.WriteString(conf.body)
-		}
-
-		fmt.Println("DRYRUN: " + display.String())
-		return nil, nil
+	if conf.dryRun != dryRunOff {
+		return nil, printDryRun(conf.stdout, conf, req)
 	}
 
-	resp, err := c.Do(req)
+	ctx, cancel := context.WithCancel(runCtx)
+	resp, err := c.Do(req.WithContext(ctx))
 	if err != nil {
+		cancel()
 		return resp, err
 	}
 
@@ -204,12 +216,13 @@ func response(conf config, req *http.Request) (*http.Response, error) {
 		log.Printf("Got response: %v%v", string(dump), bodyMsg)
 	}
 
+	resp.Body = newTimeoutBody(resp.Body, conf.readTimeout, cancel)
 	return resp, nil
 }
 
 // newRequest creates an *http.Request from the configuration.
 func newRequest(conf config, path string) (*http.Request, error) {
-	apiURL, err := getURL(conf.scheme, conf.subdomain, conf.domain)
+	apiURL, err := requestURL(conf, path)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to properly form URL from configuration: %v", err)
 	}
@@ -233,8 +246,7 @@ func newRequest(conf config, path string) (*http.Request, error) {
 		return nil, err
 	}
 
-	req.URL.Path = path
-	req.URL.RawQuery = conf.query
+	req.URL = apiURL
 
 	// Set auth here so that the user can overwrite it if desired.
 	switch conf.auth {
@@ -272,6 +284,12 @@ func newRequest(conf config, path string) (*http.Request, error) {
 		}
 	}
 
+	if conf.spec != nil {
+		if err := validateOperation(conf, req); err != nil {
+			return nil, err
+		}
+	}
+
 	return req, nil
 }
 
@@ -298,7 +316,7 @@ func copyNewline(w io.Writer, r io.Reader, copyHeaders http.Header) (n int64, er
 				}
 			}
 			b.Truncate(0)
-			fmt.Fprintf(b, jsonObj.String())
+			fmt.Fprint(b, jsonObj.String())
 			r = b
 		}
 	}
@@ -317,19 +335,33 @@ func init() {
 }
 
 func ResetSettingsHTTPVerbs() {
-	for _, cmd := range []*cobra.Command{
+	RootCmd.AddCommand(httpCommands()...)
+}
+
+// httpCommands returns a new command for each of the supported HTTP verbs.
+func httpCommands() []*cobra.Command {
+	return []*cobra.Command{
 		httpCommand(http.MethodGet),
 		httpCommand(http.MethodPut),
 		httpCommand(http.MethodPost),
+		httpCommand(http.MethodPatch),
 		httpCommand(http.MethodHead),
 		httpCommand(http.MethodDelete),
 		httpCommand(http.MethodTrace),
 		httpCommand(http.MethodOptions),
-	} {
-		RootCmd.AddCommand(cmd)
 	}
 }
 
+// validVerb reports whether there is a command for the given HTTP verb.
+func validVerb(verb string) bool {
+	for _, c := range httpCommands() {
+		if c.Name() == strings.ToLower(verb) {
+			return true
+		}
+	}
+	return false
+}
+
 func getURL(scheme, subdomain, domain string) (*url.URL, error) {
 	uStr := ""
 	if subdomain == "" {
@@ -340,26 +372,158 @@ func getURL(scheme, subdomain, domain string) (*url.URL, error) {
 	return url.Parse(uStr)
 }
 
+// baseURL is the URL which the paths of requests are relative to: base-url if
+// set, otherwise the scheme, subdomain and domain, followed by base-path.
+func baseURL(conf config) (*url.URL, error) {
+	var u *url.URL
+	var err error
+	if conf.baseURL != "" {
+		u, err = url.Parse(conf.baseURL)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid base-url %q, expected a URL such as https://api.example.com:8443/v3", conf.baseURL)
+		}
+	} else {
+		u, err = getURL(conf.scheme, conf.subdomain, conf.domain)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	u.Path = joinPath(u.Path, conf.basePath)
+	u.RawPath, u.RawQuery, u.Fragment = "", "", ""
+	return u, nil
+}
+
+// configBaseURL is the base URL from the configuration, for commands which
+// don't build a config.
+func configBaseURL() (*url.URL, error) {
+	return baseURL(baseURLConfig())
+}
+
+// baseURLConfig is the part of the config which makes up the base URL.
+func baseURLConfig() config {
+	return config{
+		scheme:    viper.GetString("scheme"),
+		subdomain: viper.GetString("subdomain"),
+		domain:    viper.GetString("domain"),
+		baseURL:   viper.GetString("base-url"),
+		basePath:  viper.GetString("base-path"),
+	}
+}
+
+// requestURL is the URL of a request for the given path. The path is joined
+// with the base URL whether or not it starts with a slash. Full http and https
+// URLs are used as they are, with the query appended to their own.
+func requestURL(conf config, path string) (*url.URL, error) {
+	if u, err := url.Parse(path); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		if conf.query != "" {
+			if u.RawQuery != "" {
+				u.RawQuery += "&"
+			}
+			u.RawQuery += conf.query
+		}
+		return u, nil
+	}
+
+	u, err := baseURL(conf)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = joinPath(u.Path, path)
+	u.RawQuery = conf.query
+	return u, nil
+}
+
+// relativePath is the path of the request relative to the base URL. It reports
+// false if the request is not for the base URL, in which case the full path is
+// returned.
+func relativePath(conf config, req *http.Request) (string, bool) {
+	base, err := baseURL(conf)
+	if err != nil || base.Scheme != req.URL.Scheme || base.Host != req.URL.Host {
+		return req.URL.Path, false
+	}
+
+	prefix := strings.TrimSuffix(base.Path, "/")
+	switch {
+	case prefix == "":
+		return req.URL.Path, true
+	case req.URL.Path == prefix:
+		return "/", true
+	case strings.HasPrefix(req.URL.Path, prefix+"/"):
+		return strings.TrimPrefix(req.URL.Path, prefix), true
+	}
+	return req.URL.Path, false
+}
+
+// joinPath joins the path onto the prefix with exactly one slash between them.
+func joinPath(prefix, p string) string {
+	switch {
+	case p == "":
+		return prefix
+	case prefix == "":
+		return "/" + strings.TrimPrefix(p, "/")
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(p, "/")
+}
+
 func newConfig(cmd *cobra.Command) (config, error) {
 	c := config{
-		commandPath:    cmd.CommandPath(),
-		requestTimeout: viper.GetInt("request_timeout"),
-		scheme:         viper.GetString("scheme"),
-		subdomain:      viper.GetString("subdomain"),
-		domain:         viper.GetString("domain"),
-		auth:           viper.GetString("auth"),
-		user:           viper.GetString("user"),
-		pass:           viper.GetString("pass"),
-		token:          viper.GetString("token"),
-		onError:        viper.GetString("on-error"),
-		printHeaders:   viper.GetBool("print-headers"),
-		dryRun:         viper.GetBool("dry-run"),
-		trace:          viper.GetBool("trace"),
-		debug:          viper.GetBool("debug"),
-		verb:           strings.ToUpper(cmd.Use),
+		commandPath:      cmd.CommandPath(),
+		stdout:           os.Stdout,
+		stderr:           os.Stderr,
+		requestTimeout:   viper.GetInt("request-timeout"),
+		readTimeout:      viper.GetDuration("read-timeout"),
+		scheme:           viper.GetString("scheme"),
+		subdomain:        viper.GetString("subdomain"),
+		domain:           viper.GetString("domain"),
+		baseURL:          viper.GetString("base-url"),
+		basePath:         viper.GetString("base-path"),
+		auth:             viper.GetString("auth"),
+		user:             viper.GetString("user"),
+		pass:             viper.GetString("pass"),
+		token:            viper.GetString("token"),
+		retries:          viper.GetInt("retries"),
+		retryWait:        viper.GetDuration("retry-wait"),
+		printHeaders:     viper.GetBool("print-headers"),
+		trace:            viper.GetBool("trace"),
+		debug:            viper.GetBool("debug"),
+		verb:             strings.ToUpper(cmd.Use),
+		compare:          viper.GetBool("compare"),
+		stream:           viper.GetBool("stream"),
+		streamReconnects: viper.GetInt("stream-reconnects"),
+	}
+
+	// The default request-timeout would cut short the slow responses which the
+	// finer timeouts are meant to allow, so it only applies with them when it
+	// was set explicitly.
+	finer := c.readTimeout > 0 || viper.GetDuration("response-header-timeout") > 0
+	if finer && !cmd.Flags().Changed("request-timeout") && !viper.InConfig("request-timeout") {
+		c.requestTimeout = 0
 	}
 
 	var err error
+	c.targets, err = parseTargets(viper.GetStringSlice("targets"), c.baseURL)
+	if err != nil {
+		return c, err
+	}
+
+	if c.stream && len(c.targets) > 0 {
+		return c, errors.New("stream can not be used with targets")
+	}
+
+	c.wait, err = newWaitConfig()
+	if err != nil {
+		return c, err
+	}
+
+	c.dryRun, err = dryRunMode(viper.GetString("dry-run"))
+	if err != nil {
+		return c, err
+	}
+
 	c.query, err = cmd.Flags().GetString("query")
 	if err != nil {
 		return c, err
@@ -380,5 +544,64 @@ func newConfig(cmd *cobra.Command) (config, error) {
 		return c, err
 	}
 
+	onStatus, err := cmd.Flags().GetStringSlice("on-status")
+	if err != nil {
+		return c, err
+	}
+
+	c.onStatus, err = newStatusPolicy(viper.GetString("on-error"), onStatus, viper.GetStringMapString("on-status"))
+	if err != nil {
+		return c, err
+	}
+
+	expectStatus, err := cmd.Flags().GetStringSlice("expect-status")
+	if err != nil {
+		return c, err
+	}
+
+	expect, err := cmd.Flags().GetStringArray("expect")
+	if err != nil {
+		return c, err
+	}
+
+	expectSchema, err := cmd.Flags().GetString("expect-schema")
+	if err != nil {
+		return c, err
+	}
+
+	c.expect, err = newExpectations(expectStatus, expect, expectSchema, viper.GetString("report") != "")
+	if err != nil {
+		return c, err
+	}
+
+	captures, err := cmd.Flags().GetStringArray("capture")
+	if err != nil {
+		return c, err
+	}
+
+	c.captures, err = parseCaptures(captures)
+	if err != nil {
+		return c, err
+	}
+
+	c.requestSchemaPath, err = cmd.Flags().GetString("request-schema")
+	if err != nil {
+		return c, err
+	}
+
+	c.responseSchemaPath, err = cmd.Flags().GetString("response-schema")
+	if err != nil {
+		return c, err
+	}
+
+	if err := viper.UnmarshalKey("schemas", &c.schemaMappings); err != nil {
+		return c, fmt.Errorf("invalid schemas config: %v", err)
+	}
+
+	c.spec, err = loadSpec()
+	if err != nil {
+		return c, err
+	}
+
 	return c, nil
 }
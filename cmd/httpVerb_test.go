@@ -72,6 +72,7 @@ func TestHTTPVerbs(t *testing.T) {
 		httpCommand(http.MethodGet),
 		httpCommand(http.MethodPut),
 		httpCommand(http.MethodPost),
+		httpCommand(http.MethodPatch),
 		httpCommand(http.MethodDelete),
 		httpCommand(http.MethodTrace),
 		httpCommand(http.MethodOptions),
@@ -102,15 +103,65 @@ func TestHTTPVerbs(t *testing.T) {
 		}, {
 			desc:           "get with dry-run query and header",
 			args:           []string{"get", "/", "--dry-run", "-q", "qKey=qVal", "-H", "Hkey=Hval"},
-			expectedOutput: `DRYRUN: jaq get / --query qKey=qVal --headers Hkey=Hval` + "\n",
+			expectedOutput: `DRYRUN: jaq get / --query qKey=qVal --header Hkey=Hval` + "\n",
 		}, {
 			desc:           "get with dry-run multiple values",
 			args:           []string{"get", "/", "--dry-run", "-q", "qKey=qVal&qKey2=qVal2", "-H", "Hkey=Hval,Hkey2=Hval2"},
-			expectedOutput: `DRYRUN: jaq get / --query qKey=qVal&qKey2=qVal2 --headers Hkey=Hval,Hkey2=Hval2` + "\n",
+			expectedOutput: `DRYRUN: jaq get / --query qKey=qVal&qKey2=qVal2 --header Hkey=Hval,Hkey2=Hval2` + "\n",
 		}, {
 			desc:           "get with dry-run repeated flags",
 			args:           []string{"get", "/", "--dry-run", "-q", "qKey=qVal&qKey2=qVal2", "-H", "Hkey=Hval", "-H", "Hkey2=Hval2"},
-			expectedOutput: `DRYRUN: jaq get / --query qKey=qVal&qKey2=qVal2 --headers Hkey=Hval,Hkey2=Hval2` + "\n",
+			expectedOutput: `DRYRUN: jaq get / --query qKey=qVal&qKey2=qVal2 --header Hkey=Hval,Hkey2=Hval2` + "\n",
+		}, {
+			desc:           "get with dry-run jaq",
+			args:           []string{"get", "/a b", "--dry-run=jaq", "-q", "qKey=qVal&qKey2=qVal2", "-H", "Hkey=Hval", "-H", "Hkey2=it's"},
+			expectedOutput: `jaq get '/a b' --query 'qKey=qVal&qKey2=qVal2' --header Hkey=Hval --header 'Hkey2=it'\''s'` + "\n",
+		}, {
+			desc:           "get with dry-run jaq header with commas",
+			args:           []string{"get", "/", "--dry-run=jaq", "-H", `"Accept=text/html,application/json"`},
+			expectedOutput: `jaq get / --header '"Accept=text/html,application/json"'` + "\n",
+		}, {
+			desc:           "post with dry-run curl",
+			args:           []string{"post", "/echo", "--dry-run=curl", "-q", "qKey=qVal", "-H", "Hkey=Hval", "--body", `{"flag":"data"}`},
+			expectedOutput: `curl -X POST -H 'Authorization: Bearer tok' -H 'Hkey: Hval' --data-binary '{"flag":"data"}' --max-time 15 'https://example.com/echo?qKey=qVal'` + "\n",
+			setup: func() {
+				viper.Set("scheme", "https")
+				viper.Set("domain", "example.com")
+				viper.Set("auth", "token")
+				viper.Set("token", "tok")
+			},
+		}, {
+			desc:           "get with dry-run curl and read timeout",
+			args:           []string{"get", "/echo", "--dry-run=curl", "--read-timeout", "1s"},
+			expectedOutput: `curl -X GET -H 'Authorization: Bearer tok' https://example.com/echo` + "\n",
+			setup: func() {
+				viper.Set("scheme", "https")
+				viper.Set("domain", "example.com")
+				viper.Set("auth", "token")
+				viper.Set("token", "tok")
+			},
+		}, {
+			desc:           "get with dry-run curl and explicit request timeout",
+			args:           []string{"get", "/echo", "--dry-run=curl", "--response-header-timeout", "1s", "--request-timeout", "30"},
+			expectedOutput: `curl -X GET -H 'Authorization: Bearer tok' --max-time 30 https://example.com/echo` + "\n",
+			setup: func() {
+				viper.Set("scheme", "https")
+				viper.Set("domain", "example.com")
+				viper.Set("auth", "token")
+				viper.Set("token", "tok")
+			},
+		}, {
+			desc:           "delete with dry-run json",
+			args:           []string{"delete", "/posts/1", "--dry-run=json", "--file", "testdata/testFile.json"},
+			expectedOutput: `{"method":"DELETE","url":"https://example.com/posts/1","file":"testdata/testFile.json","fileSha256":"807cae73ec4bf162a320f6e184ffea1ba6cd66103aa7cbbfd120398b31ecce4f"}` + "\n",
+			setup: func() {
+				viper.Set("scheme", "https")
+				viper.Set("domain", "example.com")
+			},
+		}, {
+			desc:        "get with invalid dry-run mode",
+			args:        []string{"get", "/", "--dry-run=yaml"},
+			expectedErr: errors.New(`invalid dry-run mode "yaml", expected one of: true, jaq, curl, json`),
 		}, {
 			desc: "on-error report",
 			args: []string{"get", "/error"},
@@ -411,3 +462,157 @@ func captureOutput(f func([]string, io.Reader) error, args []string, pipeIn io.R
 	io.Copy(&bufErr, rErr)
 	return buf.String(), bufErr.String(), err
 }
+
+func TestRequestURL(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		conf      config
+		path      string
+		expect    string
+		expectErr string
+	}{
+		{
+			desc:   "domain only",
+			conf:   config{scheme: "https", domain: "api.example.com"},
+			path:   "/users",
+			expect: "https://api.example.com/users",
+		}, {
+			desc:   "subdomain and base path",
+			conf:   config{scheme: "https", subdomain: "eu", domain: "example.com", basePath: "/api/v3/"},
+			path:   "/users",
+			expect: "https://eu.example.com/api/v3/users",
+		}, {
+			desc:   "base url with port and path",
+			conf:   config{scheme: "http", domain: "ignored", baseURL: "https://gw.example.com:8443/api/v3"},
+			path:   "/users",
+			expect: "https://gw.example.com:8443/api/v3/users",
+		}, {
+			desc:   "base url and base path",
+			conf:   config{baseURL: "https://gw.example.com/api/", basePath: "v3"},
+			path:   "users/1",
+			expect: "https://gw.example.com/api/v3/users/1",
+		}, {
+			desc:   "relative path",
+			conf:   config{baseURL: "https://gw.example.com/api/v3/"},
+			path:   "users",
+			expect: "https://gw.example.com/api/v3/users",
+		}, {
+			desc:   "empty path",
+			conf:   config{baseURL: "https://gw.example.com/api/v3"},
+			expect: "https://gw.example.com/api/v3",
+		}, {
+			desc:   "query",
+			conf:   config{baseURL: "https://gw.example.com/api/v3?ignored=true", query: "a=b"},
+			path:   "/users",
+			expect: "https://gw.example.com/api/v3/users?a=b",
+		}, {
+			desc:   "full url",
+			conf:   config{baseURL: "https://gw.example.com/api/v3", query: "a=b"},
+			path:   "http://other.example.com/users?page=2",
+			expect: "http://other.example.com/users?page=2&a=b",
+		}, {
+			desc:      "base url without scheme",
+			conf:      config{baseURL: "gw.example.com/api/v3"},
+			path:      "/users",
+			expectErr: `invalid base-url "gw.example.com/api/v3", expected a URL such as https://api.example.com:8443/v3`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			u, err := requestURL(tc.conf, tc.path)
+			switch {
+			case err == nil && tc.expectErr != "":
+				t.Fatalf("Expected error %q but got none", tc.expectErr)
+			case err != nil && err.Error() != tc.expectErr:
+				t.Fatalf("Expected error %q, got %q", tc.expectErr, err)
+			case err != nil:
+				return
+			}
+			if u.String() != tc.expect {
+				t.Errorf("Expected URL %v, got %v", tc.expect, u)
+			}
+		})
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, `{"path":%q,"query":%q}`, req.URL.Path, req.URL.RawQuery)
+	}))
+	defer s.Close()
+	base := "http://" + s.Listener.Addr().String()
+
+	testCases := []struct {
+		desc         string
+		args         []string
+		expectStdout string
+	}{
+		{
+			desc:         "base url",
+			args:         []string{"get", "/users", "--base-url", base + "/api/v3", "-q", "a=b"},
+			expectStdout: `{"path":"/api/v3/users","query":"a=b"}` + "\n",
+		}, {
+			desc:         "base path",
+			args:         []string{"get", "users", "--base-path", "/api/v3"},
+			expectStdout: `{"path":"/api/v3/users","query":""}` + "\n",
+		}, {
+			desc:         "full url",
+			args:         []string{"get", base + "/other", "--base-path", "/api/v3"},
+			expectStdout: `{"path":"/other","query":""}` + "\n",
+		}, {
+			desc:         "dry run shows the path relative to the base",
+			args:         []string{"get", "/users", "--base-path", "/api/v3", "--dry-run=jaq"},
+			expectStdout: "jaq get /users\n",
+		}, {
+			desc:         "dry run shows full urls",
+			args:         []string{"get", base + "/other", "--base-path", "/api/v3", "--dry-run=jaq"},
+			expectStdout: "jaq get " + base + "/other\n",
+		}, {
+			desc:         "curl dry run shows the full url",
+			args:         []string{"get", "/users", "--base-url", base + "/api/v3", "--dry-run=curl"},
+			expectStdout: "curl -X GET --max-time 15 " + base + "/api/v3/users\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ResetSettings()
+			viper.Set("scheme", "http")
+			viper.Set("domain", s.Listener.Addr().String())
+
+			stdout, _, err := captureOutput(execute, tc.args, nil)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if stdout != tc.expectStdout {
+				t.Errorf("Expected stdout %q, got %q", tc.expectStdout, stdout)
+			}
+		})
+	}
+}
+
+func TestDryRunWriter(t *testing.T) {
+	var stdout bytes.Buffer
+	conf := config{dryRun: dryRunJSON, stdout: &stdout}
+	req, err := http.NewRequest(http.MethodGet, "https://example.com/posts", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	resp, err := response(conf, req)
+	if resp != nil || err != nil {
+		t.Fatalf("Expected no response or error in dry-run, got %v, %v", resp, err)
+	}
+	if expected := `{"method":"GET","url":"https://example.com/posts"}` + "\n"; stdout.String() != expected {
+		t.Errorf("Expected output %q, got %q", expected, stdout.String())
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func importCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "import",
+		Short: "Convert requests from other tools to jaq commands and config",
+	}
+	c.AddCommand(importCurlCommand(), importPostmanCommand())
+	return c
+}
+
+func importCurlCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "curl COMMAND",
+		Short: "Convert a curl command to the equivalent jaq command and config",
+		Long: `Convert a curl command, such as one copied from browser devtools, to the
+equivalent jaq command and the config it needs. The host, basic auth and bearer
+tokens are moved to the config; the rest of the request becomes the command.
+
+The config is written before the command unless --output is given, in which case
+the config is written to that file and the command uses it via --config.
+
+> jaq import curl 'curl -X POST https://example.com/posts -H "Authorization: Bearer abc" -d "{\"title\":\"a\"}"'`,
+		Args: cobra.MinimumNArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			imported, err := parseCurl(strings.Join(args, " "))
+			if err != nil {
+				return err
+			}
+
+			output, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+
+			command := imported.command
+			if output != "" {
+				if err := writeJSON(output, imported.config); err != nil {
+					return err
+				}
+				command = append(command, "--config", output)
+			} else {
+				if err := encodeJSON(os.Stdout, imported.config); err != nil {
+					return err
+				}
+				fmt.Println()
+			}
+
+			quoted := make([]string, len(command))
+			for i, part := range command {
+				quoted[i] = shellQuote(part)
+			}
+			fmt.Println(strings.Join(quoted, " "))
+			return nil
+		},
+	}
+	c.Flags().StringP("output", "o", "", "File to write the config to")
+	return c
+}
+
+func importPostmanCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "postman COLLECTION",
+		Short: "Convert a Postman collection to a config with an alias for each request",
+		Long: `Convert a Postman collection (v2.1) to a config with an alias for each request,
+named by its folder and name (e.g. users-list-users). Variables used in the
+requests become alias params which default to the collection's variables, and
+the collection's host and auth set the scheme, domain and auth of the config.
+
+The config is written to stdout unless --output is given. Use it as a profile
+for the collection via --config.
+
+> jaq import postman collection.json -o petstore.json
+> jaq --config petstore.json users-list-users --tenant acme`,
+		Args: cobra.ExactArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+
+			var collection postmanCollection
+			if err := json.Unmarshal(b, &collection); err != nil {
+				return fmt.Errorf("invalid Postman collection %v: %v", args[0], err)
+			}
+
+			config, err := importPostman(collection)
+			if err != nil {
+				return err
+			}
+
+			output, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+			if output != "" {
+				return writeJSON(output, config)
+			}
+			return encodeJSON(os.Stdout, config)
+		},
+	}
+	c.Flags().StringP("output", "o", "", "File to write the config to")
+	return c
+}
+
+func exportCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "export",
+		Short: "Convert jaq config to the formats of other tools",
+	}
+	c.AddCommand(exportPostmanCommand())
+	return c
+}
+
+func exportPostmanCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "postman",
+		Short: "Convert the aliases of the config to a Postman collection",
+		Long: `Convert the aliases of the config to a Postman collection (v2.1). Alias params
+become collection variables and requests are sent to the {{baseUrl}} variable,
+which is set from the scheme and domain of the config. Credentials are not
+exported; the token, user and pass variables are left empty.`,
+		Args: cobra.NoArgs,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			aliases, err := loadAliases()
+			if err != nil {
+				return err
+			}
+
+			baseURL, err := configBaseURL()
+			if err != nil {
+				return fmt.Errorf("Unable to properly form URL from configuration: %v", err)
+			}
+
+			name, err := cmd.Flags().GetString("name")
+			if err != nil {
+				return err
+			}
+			collection := exportPostman(name, baseURL.String(), viper.GetString("auth"), aliases)
+
+			output, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+			if output != "" {
+				return writeJSON(output, collection)
+			}
+			return encodeJSON(os.Stdout, collection)
+		},
+	}
+	c.Flags().StringP("name", "", "jaq", "Name of the collection")
+	c.Flags().StringP("output", "o", "", "File to write the collection to")
+	return c
+}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+func writeJSON(path string, v interface{}) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return encodeJSON(f, v)
+}
+
+func init() {
+	ResetSettingsImport()
+}
+
+func ResetSettingsImport() {
+	RootCmd.AddCommand(importCommand(), exportCommand())
+}
@@ -0,0 +1,328 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestParseCurl(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		curl          string
+		expectCommand []string
+		expectConfig  map[string]interface{}
+		expectErr     error
+	}{
+		{
+			desc:          "simple get",
+			curl:          "curl https://example.com/posts?userId=1",
+			expectCommand: []string{"jaq", "get", "/posts", "--query", "userId=1"},
+			expectConfig:  map[string]interface{}{"scheme": "https", "domain": "example.com"},
+		}, {
+			desc: "copied from devtools",
+			curl: `curl 'https://example.com:8443/posts' \
+  -H 'accept: application/json' \
+  -H 'authorization: Bearer abc' \
+  --data-raw $'{"title":"it\'s"}' \
+  --compressed`,
+			expectCommand: []string{"jaq", "post", "/posts", "--header", "accept=application/json", "--body", `{"title":"it's"}`},
+			expectConfig:  map[string]interface{}{"scheme": "https", "domain": "example.com:8443", "auth": "token", "token": "abc"},
+		}, {
+			desc:          "method, basic auth and file",
+			curl:          `curl -X PUT -u me:secret --max-time 2.5 "http://example.com/posts/1" --data-binary @post.json`,
+			expectCommand: []string{"jaq", "put", "/posts/1", "--file", "post.json"},
+			expectConfig:  map[string]interface{}{"scheme": "http", "domain": "example.com", "auth": "basic", "user": "me", "pass": "secret", "request-timeout": 3},
+		}, {
+			desc:          "get with data",
+			curl:          "curl -G example.com/posts -d userId=1 -d id=2",
+			expectCommand: []string{"jaq", "get", "/posts", "--query", "userId=1&id=2"},
+			expectConfig:  map[string]interface{}{"scheme": "http", "domain": "example.com"},
+		}, {
+			desc:          "joined short flags",
+			curl:          `curl -sSLXPATCH -HAccept:text/html,application/json -d'{"a":1}' example.com/posts/1`,
+			expectCommand: []string{"jaq", "patch", "/posts/1", "--header", `"Accept=text/html,application/json"`, "--body", `{"a":1}`},
+			expectConfig:  map[string]interface{}{"scheme": "http", "domain": "example.com"},
+		}, {
+			desc:          "url encoded data",
+			curl:          `curl -G example.com/search --data-urlencode 'q=a b&c' --data-urlencode '=x/y' --data-urlencode 'z z'`,
+			expectCommand: []string{"jaq", "get", "/search", "--query", "q=a%20b%26c&x%2Fy&z%20z"},
+			expectConfig:  map[string]interface{}{"scheme": "http", "domain": "example.com"},
+		}, {
+			desc:      "url encoded file",
+			curl:      "curl example.com --data-urlencode name@file.txt",
+			expectErr: errors.New(`curl --data-urlencode "name@file.txt" reads a file, which is not supported`),
+		}, {
+			desc:          "upload file",
+			curl:          "curl -T post.json -r 0-99 --oauth2-bearer abc https://example.com/posts/",
+			expectCommand: []string{"jaq", "put", "/posts/post.json", "--header", "Range=bytes=0-99", "--file", "post.json"},
+			expectConfig:  map[string]interface{}{"scheme": "https", "domain": "example.com", "auth": "token", "token": "abc"},
+		}, {
+			desc:          "connection settings",
+			curl:          "curl --unix-socket /var/run/docker.sock --resolve a:80:1.2.3.4 --resolve b:80:1.2.3.5 -x http://proxy:3128 --noproxy a,b -U me:pw --connect-timeout 1.5 http://localhost/containers/json",
+			expectCommand: []string{"jaq", "get", "/containers/json"},
+			expectConfig: map[string]interface{}{
+				"scheme": "http", "domain": "localhost", "unix-socket": "/var/run/docker.sock",
+				"resolve": []string{"a:80:1.2.3.4", "b:80:1.2.3.5"}, "proxy": "http://proxy:3128",
+				"no-proxy": []string{"a", "b"}, "proxy-user": "me", "proxy-pass": "pw", "connect-timeout": "1.5s",
+			},
+		}, {
+			desc:      "form",
+			curl:      "curl -F a=b https://example.com/upload",
+			expectErr: errors.New("unsupported curl flag -F"),
+		}, {
+			desc:      "client certificate",
+			curl:      "curl -sE cert.pem https://example.com/",
+			expectErr: errors.New("unsupported curl flag -E"),
+		}, {
+			desc:      "unknown flag",
+			curl:      "curl --digest -u me:pw https://example.com/",
+			expectErr: errors.New("unsupported curl flag --digest"),
+		}, {
+			desc:      "no url",
+			curl:      "curl -X GET",
+			expectErr: errors.New("no URL found in curl command"),
+		}, {
+			desc:      "unterminated quote",
+			curl:      "curl 'example.com",
+			expectErr: errors.New(`unterminated single quote in "curl 'example.com"`),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			imported, err := parseCurl(tc.curl)
+			if !reflect.DeepEqual(err, tc.expectErr) {
+				t.Fatalf("Expected error %v, got %v", tc.expectErr, err)
+			}
+			if !reflect.DeepEqual(imported.command, tc.expectCommand) {
+				t.Errorf("Expected command %q, got %q", tc.expectCommand, imported.command)
+			}
+			if !reflect.DeepEqual(imported.config, tc.expectConfig) {
+				t.Errorf("Expected config %v, got %v", tc.expectConfig, imported.config)
+			}
+		})
+	}
+}
+
+func TestImportCurlRoundTrip(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	var got string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		got = fmt.Sprintf("%v %v accept=%q auth=%q %s", req.Method, req.URL, req.Header.Get("Accept"), req.Header.Get("Authorization"), b)
+	}))
+	defer s.Close()
+
+	testCases := []struct {
+		desc          string
+		curl          string
+		expectRequest string
+	}{
+		{
+			desc:          "header with commas",
+			curl:          `curl URL/posts -H 'accept: text/html,application/json;q=0.9' -H 'authorization: Bearer abc'`,
+			expectRequest: `GET /posts accept="text/html,application/json;q=0.9" auth="Bearer abc" `,
+		}, {
+			desc:          "joined short flags",
+			curl:          `curl -sXPUT -HAccept:*/* -d'{"a":1}' URL/posts/1`,
+			expectRequest: `PUT /posts/1 accept="*/*" auth="" {"a":1}`,
+		}, {
+			desc:          "url encoded data",
+			curl:          `curl URL/search --data-urlencode 'q=a b&c' --data-urlencode 'tag=x,y'`,
+			expectRequest: `POST /search accept="" auth="" q=a%20b%26c&tag=x%2Cy`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			imported, err := parseCurl(strings.Replace(tc.curl, "URL", s.URL, -1))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			ResetSettings()
+			for k, v := range imported.config {
+				viper.Set(k, v)
+			}
+			got = ""
+			if _, _, err := captureOutput(execute, imported.command[1:], nil); err != nil {
+				t.Fatalf("Unexpected error running %q: %v", imported.command, err)
+			}
+			if got != tc.expectRequest {
+				t.Errorf("Expected request %q, got %q", tc.expectRequest, got)
+			}
+		})
+	}
+}
+
+func TestImportPostmanCommandNames(t *testing.T) {
+	c := postmanCollection{
+		Info: postmanInfo{Name: "Tenants"},
+		Item: []postmanItem{
+			{Name: "Get", Request: &postmanRequest{Method: "GET", URL: postmanURL{Raw: "https://api.example.com/tenants"}}},
+			{Name: "Run", Item: []postmanItem{
+				{Name: "Start", Request: &postmanRequest{Method: "POST", URL: postmanURL{Raw: "https://api.example.com/runs"}}},
+			}},
+		},
+	}
+
+	config, err := importPostman(c)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var names []string
+	for name := range config["aliases"].(map[string]alias) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if expected := []string{"run-start", "tenants-get"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected aliases %q, got %q", expected, names)
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	ResetSettings()
+	stdout, _, err := captureOutput(execute, []string{"import", "curl", "curl -X DELETE https://example.com/posts/1", "-o", filepath.Join(tmpDir, "curl.json")}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "jaq delete /posts/1 --config " + filepath.Join(tmpDir, "curl.json") + "\n"; stdout != expected {
+		t.Errorf("Expected stdout %q, got %q", expected, stdout)
+	}
+
+	ResetSettings()
+	stdout, _, err = captureOutput(execute, []string{"import", "postman", "testdata/collection.json"}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := `{
+  "aliases": {
+    "create-tenant": {
+      "verb": "post",
+      "path": "/v2/tenants",
+      "body": "{\"name\":\"${name}\"}",
+      "params": {
+        "name": {}
+      }
+    },
+    "users-delete-user": {
+      "verb": "delete",
+      "path": "/v2/tenants/${tenant}/users/${id}",
+      "params": {
+        "id": {
+          "default": "1"
+        },
+        "tenant": {
+          "default": "acme"
+        }
+      }
+    },
+    "users-list-users": {
+      "verb": "get",
+      "path": "/v2/tenants/${tenant}/users",
+      "query": "active=true",
+      "headers": [
+        "X-Api-Version=2"
+      ],
+      "help": "List the users of a tenant",
+      "params": {
+        "tenant": {
+          "default": "acme"
+        }
+      }
+    }
+  },
+  "auth": "token",
+  "domain": "api.example.com",
+  "scheme": "https",
+  "token": "abc"
+}
+`
+	if stdout != expected {
+		t.Errorf("Expected stdout %v, got %v", expected, stdout)
+	}
+
+	// Exporting the imported aliases should give back the same requests.
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(stdout), &config); err != nil {
+		t.Fatalf("Unable to parse config: %v", err)
+	}
+
+	ResetSettings()
+	for k, v := range config {
+		viper.Set(k, v)
+	}
+	stdout, _, err = captureOutput(execute, []string{"export", "postman", "--name", "Tenants"}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var collection postmanCollection
+	if err := json.Unmarshal([]byte(stdout), &collection); err != nil {
+		t.Fatalf("Unable to parse collection: %v", err)
+	}
+
+	expectedVars := []postmanKeyValue{{Key: "baseUrl", Value: "https://api.example.com"}, {Key: "token"}, {Key: "name"}, {Key: "id", Value: "1"}, {Key: "tenant", Value: "acme"}}
+	if !reflect.DeepEqual(collection.Variable, expectedVars) {
+		t.Errorf("Expected variables %+v, got %+v", expectedVars, collection.Variable)
+	}
+
+	var raw []string
+	for _, item := range collection.Item {
+		raw = append(raw, item.Name+" "+item.Request.Method+" "+item.Request.URL.Raw)
+	}
+	expectedRaw := []string{
+		"create-tenant POST {{baseUrl}}/v2/tenants",
+		"users-delete-user DELETE {{baseUrl}}/v2/tenants/{{tenant}}/users/{{id}}",
+		"users-list-users GET {{baseUrl}}/v2/tenants/{{tenant}}/users?active=true",
+	}
+	if !reflect.DeepEqual(raw, expectedRaw) {
+		t.Errorf("Expected requests %q, got %q", expectedRaw, raw)
+	}
+	if body := collection.Item[0].Request.Body; body == nil || body.Raw != `{"name":"{{name}}"}` {
+		t.Errorf("Expected body to be exported with variables, got %+v", body)
+	}
+}
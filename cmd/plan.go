@@ -0,0 +1,337 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// applyResult records the outcome of a single entry of a plan so that a
+// subsequent apply can skip the entries that already succeeded.
+type applyResult struct {
+	Index  int    `json:"index"`
+	Hash   string `json:"hash"`
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (r applyResult) succeeded() bool {
+	return r.Error == "" && r.Status > 0 && r.Status < 400
+}
+
+// planCommand generates the plan command which has a subcommand for each HTTP
+// verb. Rather than executing the requests, each one is written to stdout as a
+// JSON line so they can be reviewed and later run via apply.
+func planCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "plan",
+		Short: "Write the fully resolved requests to stdout as JSON instead of running them.",
+		Long: `Write the fully resolved requests to stdout as JSON instead of running them.
+All input substitutions are resolved so the resulting plan can be reviewed and
+then executed as-is via apply.
+
+Examples:
+
+> jaq plan delete /posts/${1.id} < ids.json > plan.json
+> jaq apply plan.json
+`,
+
+		// All the subcommands are just the normal HTTP commands run in the
+		// json dry-run mode.
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			viper.Set("dry-run", dryRunJSON)
+		},
+	}
+	c.AddCommand(httpCommands()...)
+	return c
+}
+
+// applyCommand generates the apply command which executes a plan.
+func applyCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "apply <plan>",
+		Short: "Execute the requests in a plan generated by the plan command.",
+		Long: `Execute the requests in a plan generated by the plan command.
+The outcome of each request is appended to the results file. Requests which
+already succeeded according to the results file are skipped so that apply can
+be re-run after a failure. Requests with a --file are refused if the file has
+changed since the plan was made.`,
+		Args: cobra.ExactArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conf, err := newConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			resultsPath, err := cmd.Flags().GetString("results")
+			if err != nil {
+				return err
+			}
+			if resultsPath == "" {
+				resultsPath = args[0] + ".results"
+			}
+
+			return applyPlan(conf, args[0], resultsPath)
+		},
+	}
+	c.Flags().StringP("results", "", "", "File to record the outcome of each request (defaults to <plan>.results)")
+	return c
+}
+
+// applyPlan executes each request from the plan file in order, recording the
+// outcome of each to the results file.
+func applyPlan(conf config, planPath, resultsPath string) error {
+	plan, err := readPlan(planPath)
+	if err != nil {
+		return err
+	}
+
+	done, err := readResults(resultsPath)
+	if err != nil {
+		return err
+	}
+
+	// The requests which are left to apply are confirmed as a whole, like
+	// the rows of piped input are.
+	counts := map[string]int{}
+	var hosts []string
+	seen := map[string]bool{}
+	for i, p := range plan {
+		hash, err := p.hash()
+		if err != nil {
+			return err
+		}
+		if r, ok := done[i]; ok && r.Hash == hash {
+			continue
+		}
+		if p.File != "" {
+			if _, err := p.readFile(); err != nil {
+				return fmt.Errorf("entry %d of plan %v: %v", i+1, planPath, err)
+			}
+		}
+		counts[p.Method]++
+		if u, err := url.Parse(p.URL); err == nil && !seen[u.Host] {
+			seen[u.Host] = true
+			hosts = append(hosts, u.Host)
+		}
+	}
+	if err := confirmSend(counts, hosts); err != nil {
+		return err
+	}
+
+	var results io.Writer
+	if conf.dryRun == dryRunOff {
+		f, err := os.OpenFile(resultsPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		results = f
+	}
+
+	for i, p := range plan {
+		hash, err := p.hash()
+		if err != nil {
+			return err
+		}
+
+		if r, ok := done[i]; ok && r.Hash == hash {
+			log.Printf("[%d/%d] %s %s: already applied, skipping", i+1, len(plan), p.Method, p.URL)
+			continue
+		}
+
+		result := applyResult{Index: i, Hash: hash, Method: p.Method, URL: p.URL}
+		runErr := applyRequest(conf, p, &result)
+		if results != nil {
+			if err := json.NewEncoder(results).Encode(result); err != nil {
+				return err
+			}
+		}
+
+		if runErr != nil {
+			log.Printf("[%d/%d] %s %s: %v", i+1, len(plan), p.Method, p.URL, runErr)
+			return runErr
+		}
+		if result.Status > 0 {
+			log.Printf("[%d/%d] %s %s: %v", i+1, len(plan), p.Method, p.URL, result.Status)
+		}
+	}
+
+	return nil
+}
+
+// applyRequest runs a single request from the plan, filling in the status of
+// the result.
+func applyRequest(conf config, p plannedRequest, result *applyResult) error {
+	// Only used for the purposes of dry-run output.
+	conf.verb = p.Method
+	conf.filepath = p.File
+	conf.body = p.Body
+	conf.headers = nil
+
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return err
+	}
+	if err := resolveSchemas(&conf, p.Method, u.Path); err != nil {
+		return err
+	}
+	if err := validateRequestBody(conf); err != nil {
+		return err
+	}
+
+	status, err := send(conf, p.request)
+	if status > 0 {
+		result.Status = status
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return err
+	}
+
+	return nil
+}
+
+// request creates the *http.Request described by the plan entry.
+func (p plannedRequest) request() (*http.Request, error) {
+	var body io.Reader
+
+	// File contents supercedes json body.
+	if p.File != "" {
+		b, err := p.readFile()
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	} else if len(p.Body) > 0 {
+		body = bytes.NewBufferString(p.Body)
+	}
+
+	req, err := http.NewRequest(p.Method, p.URL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range p.Header {
+		req.Header[k] = v
+	}
+
+	return req, nil
+}
+
+// readFile reads the file of the plan entry, checking that it has not changed
+// since the plan was made.
+func (p plannedRequest) readFile() ([]byte, error) {
+	b, err := ioutil.ReadFile(p.File)
+	if err != nil {
+		return nil, err
+	}
+	if p.FileHash == "" {
+		return nil, fmt.Errorf("no fileSha256 for file %v; re-create the plan", p.File)
+	}
+	if sha256Hex(b) != p.FileHash {
+		return nil, fmt.Errorf("file %v has changed since the plan was made; re-create the plan to review it", p.File)
+	}
+	return b, nil
+}
+
+// hash identifies the plan entry so that changes to the plan between runs of
+// apply can be detected.
+func (p plannedRequest) hash() (string, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+
+	return sha256Hex(b), nil
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// readPlan reads the stream of JSON requests from the given file.
+func readPlan(path string) ([]plannedRequest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var plan []plannedRequest
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var p plannedRequest
+		if err := dec.Decode(&p); err != nil {
+			return nil, fmt.Errorf("invalid plan %v: %v", path, err)
+		}
+		plan = append(plan, p)
+	}
+
+	return plan, nil
+}
+
+// readResults reads the successful results, by index, from a previous apply. A
+// missing file is not an error since it just means nothing has been applied yet.
+func readResults(path string) (map[int]applyResult, error) {
+	done := map[int]applyResult{}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var r applyResult
+		if err := dec.Decode(&r); err != nil {
+			return nil, fmt.Errorf("invalid results file %v: %v", path, err)
+		}
+		if r.succeeded() {
+			done[r.Index] = r
+		}
+	}
+
+	return done, nil
+}
+
+func init() {
+	ResetSettingsPlan()
+}
+
+func ResetSettingsPlan() {
+	RootCmd.AddCommand(planCommand(), applyCommand())
+}
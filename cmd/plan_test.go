@@ -0,0 +1,200 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestPlanApply(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	var got []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = append(got, req.Method+" "+req.URL.Path)
+		if req.URL.Path == "/posts/3" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer s.Close()
+
+	reset := func() {
+		ResetSettings()
+		viper.Set("scheme", "http")
+		viper.Set("domain", s.Listener.Addr().String())
+		viper.Set("on-error", "fatal")
+	}
+
+	reset()
+	stdout, _, err := captureOutput(execute, []string{"plan", "delete", "/posts/${1.id}"}, strings.NewReader(`{"id":1} {"id":2} {"id":3}`))
+	if err != nil {
+		t.Fatalf("Unexpected error from plan: %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("Expected plan to not send requests, got %v", got)
+	}
+
+	expectedPlan := `{"method":"DELETE","url":"http://` + s.Listener.Addr().String() + `/posts/1"}` + "\n" +
+		`{"method":"DELETE","url":"http://` + s.Listener.Addr().String() + `/posts/2"}` + "\n" +
+		`{"method":"DELETE","url":"http://` + s.Listener.Addr().String() + `/posts/3"}` + "\n"
+	if stdout != expectedPlan {
+		t.Fatalf("Expected plan %q, got %q", expectedPlan, stdout)
+	}
+
+	planPath := filepath.Join(tmpDir, "plan.json")
+	if err := ioutil.WriteFile(planPath, []byte(stdout), 0644); err != nil {
+		t.Fatalf("Failed to write plan: %v", err)
+	}
+
+	// The entries of the plan are confirmed like rows of piped input.
+	reset()
+	viper.Set("max-requests", 2)
+	_, _, err = captureOutput(execute, []string{"apply", planPath}, nil)
+	if expected := "refusing to send 3 requests; exceeds --max-requests of 2"; err == nil || err.Error() != expected {
+		t.Errorf("Expected error %q, got %v", expected, err)
+	}
+
+	defer func(f func() (io.ReadWriteCloser, error)) { openTTY = f }(openTTY)
+	openTTY = func() (io.ReadWriteCloser, error) { return nil, errors.New("no tty") }
+	reset()
+	viper.Set("confirm-threshold", 2)
+	_, _, err = captureOutput(execute, []string{"apply", planPath}, nil)
+	if expected := "about to send 3 DELETE requests to " + s.Listener.Addr().String() + " but unable to prompt for confirmation (no tty); set --yes to proceed"; err == nil || err.Error() != expected {
+		t.Errorf("Expected error %q, got %v", expected, err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("Expected no requests without confirmation, got %v", got)
+	}
+
+	reset()
+	_, stderr, err := captureOutput(execute, []string{"apply", planPath}, nil)
+	if err == nil {
+		t.Errorf("Expected error from failed request")
+	}
+	if !strings.Contains(stderr, "[2/3] DELETE") {
+		t.Errorf("Expected progress in stderr, got %q", stderr)
+	}
+	expected := []string{"DELETE /posts/1", "DELETE /posts/2", "DELETE /posts/3"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected requests %v, got %v", expected, got)
+	}
+
+	// Re-running should only retry the failed request.
+	got = nil
+	reset()
+	_, stderr, _ = captureOutput(execute, []string{"apply", planPath}, nil)
+	expected = []string{"DELETE /posts/3"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected requests %v, got %v", expected, got)
+	}
+	if !strings.Contains(stderr, "already applied, skipping") {
+		t.Errorf("Expected skipped requests to be logged, got %q", stderr)
+	}
+
+	results, err := readResults(planPath + ".results")
+	if err != nil {
+		t.Fatalf("Unable to read results: %v", err)
+	}
+	if len(results) != 2 || !results[0].succeeded() || !results[1].succeeded() {
+		t.Errorf("Expected first two entries to have succeeded, got %v", results)
+	}
+}
+
+func TestPlanApplyFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	var got []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		got = append(got, req.Method+" "+req.URL.Path+" "+string(b))
+	}))
+	defer s.Close()
+
+	reset := func() {
+		ResetSettings()
+		viper.Set("scheme", "http")
+		viper.Set("domain", s.Listener.Addr().String())
+	}
+
+	bodyPath := filepath.Join(tmpDir, "post.json")
+	if err := ioutil.WriteFile(bodyPath, []byte(`{"title":"reviewed"}`), 0644); err != nil {
+		t.Fatalf("Failed to write body: %v", err)
+	}
+
+	reset()
+	stdout, _, err := captureOutput(execute, []string{"plan", "put", "/posts/1", "--file", bodyPath}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error from plan: %v", err)
+	}
+	planPath := filepath.Join(tmpDir, "plan.json")
+	if err := ioutil.WriteFile(planPath, []byte(stdout), 0644); err != nil {
+		t.Fatalf("Failed to write plan: %v", err)
+	}
+
+	// The file is changed after the plan was reviewed.
+	if err := ioutil.WriteFile(bodyPath, []byte(`{"title":"changed"}`), 0644); err != nil {
+		t.Fatalf("Failed to write body: %v", err)
+	}
+	reset()
+	_, _, err = captureOutput(execute, []string{"apply", planPath}, nil)
+	if expected := "entry 1 of plan " + planPath + ": file " + bodyPath + " has changed since the plan was made; re-create the plan to review it"; err == nil || err.Error() != expected {
+		t.Errorf("Expected error %q, got %v", expected, err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("Expected no requests for a changed file, got %v", got)
+	}
+
+	if err := ioutil.WriteFile(bodyPath, []byte(`{"title":"reviewed"}`), 0644); err != nil {
+		t.Fatalf("Failed to write body: %v", err)
+	}
+	reset()
+	if _, _, err := captureOutput(execute, []string{"apply", planPath}, nil); err != nil {
+		t.Fatalf("Unexpected error from apply: %v", err)
+	}
+	if expected := []string{`PUT /posts/1 {"title":"reviewed"}`}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected requests %q, got %q", expected, got)
+	}
+
+	info, err := os.Stat(planPath + ".results")
+	if err != nil {
+		t.Fatalf("Unable to stat results: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("Expected results file mode 0600, got %v", mode)
+	}
+}
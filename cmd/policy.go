@@ -0,0 +1,382 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	actionSilence  = "silence"
+	actionFatal    = "fatal"
+	actionContinue = "continue"
+	actionReport   = "report"
+	actionRetry    = "retry"
+
+	// statusError is the status used for requests which got no response at
+	// all; e.g. network errors and timeouts.
+	statusError = -1
+
+	// statusInvalid is the status used for successful responses whose body
+	// does not match the response schema.
+	statusInvalid = -2
+)
+
+// statusRule is a single entry of the on-status table, applying the action to
+// responses with codes in the range [low, high].
+type statusRule struct {
+	low, high int
+	action    string
+
+	// fallback is the action to take once retries are exhausted.
+	fallback string
+
+	// fromFlag is set for rules given via --on-status, which take precedence
+	// over those from the config file.
+	fromFlag bool
+}
+
+// statusPolicy decides how to handle a response based on its status code.
+type statusPolicy struct {
+	rules []statusRule
+
+	// onError is the action for codes >= 400 when no rule matches.
+	onError string
+}
+
+// newStatusPolicy creates the policy from the on-status rules and on-error
+// default. Any rule from the flags which matches a status wins over the rules
+// from the config file, however specific they are.
+func newStatusPolicy(onError string, flagRules []string, configRules map[string]string) (statusPolicy, error) {
+	p := statusPolicy{onError: onError}
+
+	if !validAction(onError) || onError == actionRetry {
+		return p, fmt.Errorf("invalid on-error value %q, expected one of: silence, fatal, continue, report", onError)
+	}
+
+	for _, r := range flagRules {
+		rule, err := parseStatusRule(r)
+		if err != nil {
+			return p, err
+		}
+		rule.fromFlag = true
+		p.rules = append(p.rules, rule)
+	}
+
+	// Maps have no order; sort so that results are consistent.
+	keys := make([]string, 0, len(configRules))
+	for k := range configRules {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		rule, err := parseStatusRule(k + "=" + configRules[k])
+		if err != nil {
+			return p, err
+		}
+		p.rules = append(p.rules, rule)
+	}
+
+	return p, nil
+}
+
+// parseStatusRule parses rules of the form CODES=ACTION. CODES may be a single
+// code (404), a class (5xx), a range (500-504) or "error" for requests which got
+// no response. ACTION may be retry:FALLBACK to choose what happens when retries
+// run out.
+func parseStatusRule(s string) (statusRule, error) {
+	var rule statusRule
+
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return rule, fmt.Errorf("invalid on-status rule %q, expected CODES=ACTION (e.g. 404=silence)", s)
+	}
+	codes, action := strings.ToLower(strings.TrimSpace(parts[0])), strings.ToLower(strings.TrimSpace(parts[1]))
+
+	var err error
+	rule.low, rule.high, err = parseStatusCodes(codes)
+	if err != nil {
+		return rule, fmt.Errorf("invalid status codes %q in on-status rule %q", codes, s)
+	}
+
+	actionParts := strings.SplitN(action, ":", 2)
+	rule.action = actionParts[0]
+	if len(actionParts) == 2 {
+		rule.fallback = actionParts[1]
+		if rule.action != actionRetry || !validAction(rule.fallback) || rule.fallback == actionRetry {
+			return rule, fmt.Errorf("invalid action %q in on-status rule %q; only retry may have a fallback (e.g. retry:report)", action, s)
+		}
+	}
+	if !validAction(rule.action) {
+		return rule, fmt.Errorf("invalid action %q in on-status rule %q, expected one of: silence, fatal, continue, report, retry", action, s)
+	}
+
+	return rule, nil
+}
+
+// parseStatusCodes parses a single code (404), a class (5xx), a range
+// (500-504), "error" or "invalid" into the range of codes it represents.
+func parseStatusCodes(codes string) (low, high int, err error) {
+	switch {
+	case codes == "error":
+		low, high = statusError, statusError
+	case codes == "invalid":
+		low, high = statusInvalid, statusInvalid
+	case len(codes) == 3 && strings.HasSuffix(codes, "xx"):
+		var class int
+		class, err = strconv.Atoi(codes[:1])
+		low, high = class*100, class*100+99
+	case strings.Contains(codes, "-"):
+		bounds := strings.SplitN(codes, "-", 2)
+		low, err = strconv.Atoi(bounds[0])
+		if err == nil {
+			high, err = strconv.Atoi(bounds[1])
+		}
+	default:
+		low, err = strconv.Atoi(codes)
+		high = low
+	}
+	if err == nil && low > high {
+		err = fmt.Errorf("invalid range %v", codes)
+	}
+	return low, high, err
+}
+
+// precedes reports whether the rule wins over another which also matches.
+func (r statusRule) precedes(other statusRule) bool {
+	if r.fromFlag != other.fromFlag {
+		return r.fromFlag
+	}
+	return r.high-r.low < other.high-other.low
+}
+
+func validAction(a string) bool {
+	switch a {
+	case actionSilence, actionFatal, actionContinue, actionReport, actionRetry:
+		return true
+	}
+	return false
+}
+
+// handlesRedirects reports whether any rule applies to 3xx codes, in which case
+// redirects must not be followed so that the rule sees them.
+func (p statusPolicy) handlesRedirects() bool {
+	for _, r := range p.rules {
+		if r.low < 400 && r.high >= 300 {
+			return true
+		}
+	}
+	return false
+}
+
+// action returns the action to take for the given status along with the
+// fallback to use if the action is retry and retries have run out. The most
+// specific matching rule from the flags wins, or failing that the most specific
+// one from the config file.
+func (p statusPolicy) action(status int) (action, fallback string) {
+	var match *statusRule
+	for i, r := range p.rules {
+		if status < r.low || status > r.high {
+			continue
+		}
+		if match == nil || r.precedes(*match) {
+			match = &p.rules[i]
+		}
+	}
+
+	defaultAction := actionContinue
+	switch {
+	case status == statusError:
+		defaultAction = actionFatal
+	case status == statusInvalid, status >= 400:
+		defaultAction = p.onError
+	}
+
+	if match == nil {
+		return defaultAction, defaultAction
+	}
+	if match.fallback == "" {
+		return match.action, defaultAction
+	}
+	return match.action, match.fallback
+}
+
+// send builds and runs a request, retrying according to the status policy, and
+// then processes the final response. The request is built for each attempt so
+// that the body can be read again. It returns the final status code, or
+// statusError if there was no response.
+func send(conf config, build func() (*http.Request, error)) (int, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := build()
+		if err != nil {
+			return statusError, err
+		}
+
+		start := time.Now()
+		resp, err := response(conf, req)
+		if err == nil && resp != nil && resp.StatusCode == http.StatusAccepted && conf.wait.enabled {
+			resp, err = waitForCompletion(conf, req, resp)
+		}
+		latency := time.Since(start)
+
+		// Dry-run; nothing was sent.
+		if resp == nil && err == nil {
+			return 0, nil
+		}
+
+		// The run was cancelled so the request neither failed nor succeeded.
+		if err != nil && runCtx.Err() != nil {
+			return statusError, err
+		}
+
+		status := statusError
+		if resp != nil {
+			status = resp.StatusCode
+		}
+
+		// A response which does not match the schema is handled by the
+		// rules for the invalid pseudo-status rather than its own code.
+		invalid := validateResponse(conf, resp)
+		actionStatus := status
+		if len(invalid) > 0 {
+			actionStatus = statusInvalid
+		}
+
+		action, fallback := conf.onStatus.action(actionStatus)
+		if action == actionRetry && attempt < conf.retries {
+			wait := conf.retryWait * time.Duration(1<<uint(attempt))
+			log.Printf("Retrying %v %v in %v (attempt %d of %d): %v", req.Method, req.URL, wait, attempt+1, conf.retries, describeStatus(resp, err))
+			if resp != nil {
+				resp.Body.Close()
+			}
+			select {
+			case <-time.After(wait):
+			case <-runCtx.Done():
+				return status, runCtx.Err()
+			}
+			continue
+		}
+		if action == actionRetry {
+			action = fallback
+		}
+
+		if len(invalid) > 0 && action != actionSilence {
+			for _, msg := range invalid {
+				log.Printf("Invalid response from %v %v: %v", req.Method, req.URL, msg)
+			}
+		}
+
+		var failed bool
+		if conf.expect.enabled() {
+			failed = checkResponse(conf, req, resp, err, latency, invalid)
+		} else {
+			failed = err != nil || resp == nil || resp.StatusCode >= 400 || len(invalid) > 0
+			stats.add(resp, err, latency, failed)
+		}
+
+		if err != nil {
+			return status, handleRequestError(action, err)
+		}
+		if len(conf.captures) > 0 {
+			if err := captureSession(conf, resp); err != nil {
+				return status, fmt.Errorf("Unable to capture session values: %v", err)
+			}
+		}
+		if len(invalid) > 0 && action == actionFatal {
+			if err := processResponse(conf, resp, actionReport); err != nil {
+				return status, err
+			}
+			return status, fmt.Errorf("Response from %v %v does not match schema", req.Method, req.URL)
+		}
+		if conf.stream && action == actionContinue {
+			if err := streamResponse(conf, build, req, resp); err != nil {
+				if !failed && runCtx.Err() == nil {
+					stats.bodyFailed()
+				}
+				return status, err
+			}
+			return status, nil
+		}
+		if err := processResponse(conf, resp, action); err != nil {
+			if !failed && action != actionFatal && runCtx.Err() == nil {
+				stats.bodyFailed()
+			}
+			return status, err
+		}
+		return status, nil
+	}
+}
+
+// checkResponse checks the response against the expectations, logging each
+// failure, and records the result along with any schema errors from the
+// response schema, which are logged by send. If the body
+// needs to be read it is replaced so that it can still be output afterwards.
+// It returns whether the request failed.
+func checkResponse(conf config, req *http.Request, resp *http.Response, err error, latency time.Duration, invalid []string) bool {
+	status := statusError
+	var body []byte
+	if resp != nil {
+		status = resp.StatusCode
+		if conf.expect.needsBody() {
+			var readErr error
+			body, readErr = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+			if readErr != nil {
+				err = readErr
+			}
+		}
+	}
+
+	failures := conf.expect.check(status, err, body)
+	for _, msg := range failures {
+		log.Printf("Expectation failed for %v %v: %v", req.Method, req.URL, msg)
+	}
+	failures = append(failures, invalid...)
+	checkResults = append(checkResults, checkResult{
+		Name:     req.Method + " " + req.URL.String(),
+		Status:   status,
+		Duration: latency,
+		Failures: failures,
+	})
+	stats.recordChecked(resp, err, latency, failures)
+	return len(failures) > 0
+}
+
+// handleRequestError applies the action to a request which got no response.
+func handleRequestError(action string, err error) error {
+	switch action {
+	case actionFatal:
+		return err
+	case actionReport:
+		log.Println(err)
+	}
+	return nil
+}
+
+func describeStatus(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
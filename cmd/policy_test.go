@@ -0,0 +1,232 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestStatusPolicy(t *testing.T) {
+	p, err := newStatusPolicy("report",
+		[]string{"404=silence", "409=continue", "5xx=retry:report", "429=retry", "401=fatal"},
+		map[string]string{"error": "report", "300-399": "report", "4xx": "continue"},
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		status           int
+		expectAction     string
+		expectedFallback string
+	}{
+		{status: 200, expectAction: actionContinue, expectedFallback: actionContinue},
+		{status: 302, expectAction: actionReport, expectedFallback: actionContinue},
+		{status: 404, expectAction: actionSilence, expectedFallback: actionReport},
+		{status: 409, expectAction: actionContinue, expectedFallback: actionReport},
+		{status: 400, expectAction: actionContinue, expectedFallback: actionReport},
+		{status: 429, expectAction: actionRetry, expectedFallback: actionReport},
+		{status: 503, expectAction: actionRetry, expectedFallback: actionReport},
+		{status: 401, expectAction: actionFatal, expectedFallback: actionReport},
+		{status: statusError, expectAction: actionReport, expectedFallback: actionFatal},
+	}
+	for _, tc := range testCases {
+		action, fallback := p.action(tc.status)
+		if action != tc.expectAction || fallback != tc.expectedFallback {
+			t.Errorf("Status %v: expected %v/%v, got %v/%v", tc.status, tc.expectAction, tc.expectedFallback, action, fallback)
+		}
+	}
+}
+
+func TestStatusPolicyFlagPrecedence(t *testing.T) {
+	p, err := newStatusPolicy("report",
+		[]string{"4xx=continue"},
+		map[string]string{"404": "silence", "5xx": "fatal"},
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		status       int
+		expectAction string
+	}{
+		{status: 404, expectAction: actionContinue},
+		{status: 503, expectAction: actionFatal},
+	}
+	for _, tc := range testCases {
+		if action, _ := p.action(tc.status); action != tc.expectAction {
+			t.Errorf("Status %v: expected %v, got %v", tc.status, tc.expectAction, action)
+		}
+	}
+}
+
+func TestParseStatusRule(t *testing.T) {
+	testCases := []struct {
+		rule       string
+		expectRule statusRule
+		expectErr  error
+	}{
+		{rule: "404=silence", expectRule: statusRule{low: 404, high: 404, action: actionSilence}},
+		{rule: "5xx=retry:report", expectRule: statusRule{low: 500, high: 599, action: actionRetry, fallback: actionReport}},
+		{rule: "500-504=fatal", expectRule: statusRule{low: 500, high: 504, action: actionFatal}},
+		{rule: "error=report", expectRule: statusRule{low: statusError, high: statusError, action: actionReport}},
+		{rule: "invalid=continue", expectRule: statusRule{low: statusInvalid, high: statusInvalid, action: actionContinue}},
+		{rule: "404", expectErr: errors.New(`invalid on-status rule "404", expected CODES=ACTION (e.g. 404=silence)`)},
+		{rule: "504-500=fatal", expectErr: errors.New(`invalid status codes "504-500" in on-status rule "504-500=fatal"`)},
+		{rule: "404=ignore", expectErr: errors.New(`invalid action "ignore" in on-status rule "404=ignore", expected one of: silence, fatal, continue, report, retry`)},
+		{rule: "404=report:fatal", expectErr: errors.New(`invalid action "report:fatal" in on-status rule "404=report:fatal"; only retry may have a fallback (e.g. retry:report)`)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.rule, func(t *testing.T) {
+			rule, err := parseStatusRule(tc.rule)
+			if !reflect.DeepEqual(err, tc.expectErr) {
+				t.Fatalf("Expected error %v, got %v", tc.expectErr, err)
+			}
+			if err == nil && rule != tc.expectRule {
+				t.Errorf("Expected rule %+v, got %+v", tc.expectRule, rule)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	var bodies []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		bodies = append(bodies, string(b))
+		if len(bodies) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"retry":true}`))
+			return
+		}
+		w.Write(b)
+	}))
+	defer s.Close()
+
+	ResetSettings()
+	viper.Set("scheme", "http")
+	viper.Set("domain", s.Listener.Addr().String())
+	viper.Set("retry-wait", time.Millisecond)
+
+	stdout, stderr, err := captureOutput(execute, []string{"post", "/", "--body", `{"a":"b"}`, "--on-status", "5xx=retry:fatal"}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stdout != `{"a":"b"}`+"\n" {
+		t.Errorf("Expected final response on stdout, got %q", stdout)
+	}
+	if strings.Count(stderr, "Retrying POST") != 2 {
+		t.Errorf("Expected 2 retries to be logged, got %q", stderr)
+	}
+	if expected := []string{`{"a":"b"}`, `{"a":"b"}`, `{"a":"b"}`}; !reflect.DeepEqual(bodies, expected) {
+		t.Errorf("Expected body to be sent on each attempt; got %v", bodies)
+	}
+
+	// Once retries are exhausted the fallback applies.
+	bodies = nil
+	ResetSettings()
+	viper.Set("scheme", "http")
+	viper.Set("domain", s.Listener.Addr().String())
+	viper.Set("retry-wait", time.Millisecond)
+
+	_, _, err = captureOutput(execute, []string{"post", "/", "--on-status", "5xx=retry:fatal", "--retries", "1"}, nil)
+	if expected := errors.New("Unexpected status from response: 503 Service Unavailable"); !reflect.DeepEqual(err, expected) {
+		t.Errorf("Expected error %v, got %v", expected, err)
+	}
+	if len(bodies) != 2 {
+		t.Errorf("Expected 2 attempts, got %v", len(bodies))
+	}
+}
+
+func TestRedirectStatus(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/old":
+			http.Redirect(w, req, "/new", http.StatusFound)
+		case "/new":
+			w.Write([]byte(`{"new":true}`))
+		}
+	}))
+	defer s.Close()
+
+	testCases := []struct {
+		desc string
+		args []string
+
+		expectStdout string
+		expectErr    error
+	}{
+		{
+			desc:         "followed without a rule",
+			args:         []string{"get", "/old"},
+			expectStdout: `{"new":true}` + "\n",
+		}, {
+			desc:      "fatal redirect",
+			args:      []string{"get", "/old", "--on-status", "3xx=fatal"},
+			expectErr: errors.New("Unexpected status from response: 302 Found"),
+		}, {
+			desc: "silenced redirect",
+			args: []string{"get", "/old", "--on-status", "302=silence"},
+		}, {
+			desc:         "rule for other codes",
+			args:         []string{"get", "/old", "--on-status", "404=silence"},
+			expectStdout: `{"new":true}` + "\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ResetSettings()
+			viper.Set("scheme", "http")
+			viper.Set("domain", s.Listener.Addr().String())
+
+			stdout, _, err := captureOutput(execute, tc.args, nil)
+			if !reflect.DeepEqual(err, tc.expectErr) {
+				t.Errorf("Expected error %v, got %v", tc.expectErr, err)
+			}
+			if stdout != tc.expectStdout {
+				t.Errorf("Expected stdout %q, got %q", tc.expectStdout, stdout)
+			}
+		})
+	}
+}
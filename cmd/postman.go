@@ -0,0 +1,448 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const postmanSchema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+// postmanVariablePattern matches Postman variables, e.g. {{baseUrl}}.
+var postmanVariablePattern = regexp.MustCompile(`\{\{\s*([a-zA-Z_][a-zA-Z0-9_-]*)\s*\}\}`)
+
+// postmanCollection is the subset of a v2.1 Postman collection which maps to
+// jaq aliases.
+type postmanCollection struct {
+	Info     postmanInfo       `json:"info"`
+	Item     []postmanItem     `json:"item"`
+	Auth     *postmanAuth      `json:"auth,omitempty"`
+	Variable []postmanKeyValue `json:"variable,omitempty"`
+}
+
+type postmanInfo struct {
+	Name   string `json:"name"`
+	Schema string `json:"schema"`
+}
+
+// postmanItem is either a request or a folder of items.
+type postmanItem struct {
+	Name    string          `json:"name"`
+	Item    []postmanItem   `json:"item,omitempty"`
+	Request *postmanRequest `json:"request,omitempty"`
+}
+
+type postmanRequest struct {
+	Method      string            `json:"method"`
+	Header      []postmanKeyValue `json:"header,omitempty"`
+	URL         postmanURL        `json:"url"`
+	Body        *postmanBody      `json:"body,omitempty"`
+	Description postmanText       `json:"description,omitempty"`
+}
+
+type postmanURL struct {
+	Raw      string            `json:"raw"`
+	Host     []string          `json:"host,omitempty"`
+	Path     []string          `json:"path,omitempty"`
+	Query    []postmanKeyValue `json:"query,omitempty"`
+	Variable []postmanKeyValue `json:"variable,omitempty"`
+}
+
+type postmanBody struct {
+	Mode       string            `json:"mode"`
+	Raw        string            `json:"raw,omitempty"`
+	URLEncoded []postmanKeyValue `json:"urlencoded,omitempty"`
+	Options    interface{}       `json:"options,omitempty"`
+}
+
+type postmanAuth struct {
+	Type   string            `json:"type"`
+	Bearer []postmanKeyValue `json:"bearer,omitempty"`
+	Basic  []postmanKeyValue `json:"basic,omitempty"`
+}
+
+type postmanKeyValue struct {
+	Key      string      `json:"key"`
+	Value    postmanText `json:"value"`
+	Disabled bool        `json:"disabled,omitempty"`
+}
+
+// postmanText is a string which Postman may also write as a number, boolean or,
+// for descriptions, an object with the content.
+type postmanText string
+
+func (t *postmanText) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	switch vv := v.(type) {
+	case nil:
+		*t = ""
+	case string:
+		*t = postmanText(vv)
+	case map[string]interface{}:
+		*t = postmanText(fmt.Sprint(vv["content"]))
+	default:
+		*t = postmanText(fmt.Sprint(vv))
+	}
+	return nil
+}
+
+// UnmarshalJSON accepts URLs given as either a string or an object.
+func (u *postmanURL) UnmarshalJSON(b []byte) error {
+	var raw string
+	if err := json.Unmarshal(b, &raw); err == nil {
+		*u = postmanURL{Raw: raw}
+		return nil
+	}
+
+	type plain postmanURL
+	return json.Unmarshal(b, (*plain)(u))
+}
+
+func lookup(kvs []postmanKeyValue, key string) string {
+	for _, kv := range kvs {
+		if kv.Key == key {
+			return string(kv.Value)
+		}
+	}
+	return ""
+}
+
+// importPostman converts the requests of the collection to aliases, named by
+// their folder and name, in a config for the collection. Names which are jaq
+// commands are prefixed with the name of the collection. Postman variables in
+// the requests become alias params which default to the value of the collection
+// variable. The host of the requests, usually a {{baseUrl}} variable, sets the
+// scheme and domain of the config.
+func importPostman(c postmanCollection) (map[string]interface{}, error) {
+	vars := map[string]string{}
+	for _, v := range c.Variable {
+		vars[v.Key] = string(v.Value)
+	}
+
+	config := map[string]interface{}{}
+	aliases := map[string]alias{}
+
+	collectionPrefix := aliasName("", c.Info.Name)
+	if collectionPrefix == "" || builtinCommand(collectionPrefix) {
+		collectionPrefix = "postman"
+	}
+
+	var walk func(prefix string, items []postmanItem) error
+	walk = func(prefix string, items []postmanItem) error {
+		for _, item := range items {
+			name := aliasName(prefix, item.Name)
+			if item.Request == nil {
+				if err := walk(name, item.Item); err != nil {
+					return err
+				}
+				continue
+			}
+
+			a, base, err := postmanAlias(*item.Request, vars)
+			if err != nil {
+				return fmt.Errorf("request %q: %v", item.Name, err)
+			}
+
+			if base != nil {
+				if _, ok := config["domain"]; !ok {
+					config["scheme"] = base.Scheme
+					config["domain"] = base.Host
+				} else if config["domain"] != base.Host {
+					log.Printf("Request %q is to %v rather than %v; it will be sent to %v", item.Name, base.Host, config["domain"], config["domain"])
+				}
+			}
+
+			// Aliases can not replace commands, so a request called e.g.
+			// "Get" is prefixed with the collection name instead.
+			if builtinCommand(name) {
+				renamed := aliasName(collectionPrefix, name)
+				log.Printf("Request %q is imported as %v since %v is a jaq command", item.Name, renamed, name)
+				name = renamed
+			}
+
+			unique := name
+			for i := 2; ; i++ {
+				if _, ok := aliases[unique]; !ok {
+					break
+				}
+				unique = fmt.Sprintf("%v-%d", name, i)
+			}
+			aliases[unique] = a
+		}
+		return nil
+	}
+	if err := walk("", c.Item); err != nil {
+		return nil, err
+	}
+
+	if c.Auth != nil {
+		switch c.Auth.Type {
+		case "bearer":
+			config["auth"] = "token"
+			config["token"] = resolvePostmanVariables(lookup(c.Auth.Bearer, "token"), vars)
+		case "basic":
+			config["auth"] = "basic"
+			config["user"] = resolvePostmanVariables(lookup(c.Auth.Basic, "username"), vars)
+			config["pass"] = resolvePostmanVariables(lookup(c.Auth.Basic, "password"), vars)
+		default:
+			log.Printf("Ignoring unsupported %v auth of the collection", c.Auth.Type)
+		}
+	}
+
+	config["aliases"] = aliases
+	return config, nil
+}
+
+// postmanAlias converts a single request to an alias along with the URL of the
+// host it is sent to, if one could be determined.
+func postmanAlias(r postmanRequest, vars map[string]string) (alias, *url.URL, error) {
+	a := alias{
+		Verb: strings.ToLower(r.Method),
+		Help: string(r.Description),
+	}
+	if a.Verb == "" {
+		a.Verb = "get"
+	}
+
+	params := map[string]aliasParam{}
+	convert := func(s string) string {
+		return postmanVariablePattern.ReplaceAllStringFunc(s, func(m string) string {
+			name := postmanVariablePattern.FindStringSubmatch(m)[1]
+			params[name] = aliasParam{Default: vars[name]}
+			return "${" + name + "}"
+		})
+	}
+
+	raw := r.URL.Raw
+	if raw == "" {
+		raw = strings.Join(r.URL.Host, ".") + "/" + strings.Join(r.URL.Path, "/")
+	}
+	raw = strings.SplitN(raw, "#", 2)[0]
+	parts := strings.SplitN(raw, "?", 2)
+	hostAndPath := parts[0]
+
+	// The host is everything up to the first / after the scheme.
+	start := 0
+	if i := strings.Index(hostAndPath, "://"); i >= 0 {
+		start = i + 3
+	}
+	host, path := hostAndPath, "/"
+	if i := strings.Index(hostAndPath[start:], "/"); i >= 0 {
+		host, path = hostAndPath[:start+i], hostAndPath[start+i:]
+	}
+
+	var base *url.URL
+	if resolved := resolvePostmanVariables(host, vars); !postmanVariablePattern.MatchString(resolved) {
+		if !strings.Contains(resolved, "://") {
+			resolved = "https://" + resolved
+		}
+		u, err := url.Parse(resolved)
+		if err != nil {
+			return a, nil, fmt.Errorf("invalid host %q: %v", host, err)
+		}
+		base = u
+		if p := strings.TrimSuffix(u.Path, "/"); p != "" {
+			path = p + path
+		}
+	} else {
+		log.Printf("Unable to resolve the host %q; set the domain in the config", host)
+	}
+
+	// Path variables (/users/:id) are params the same as {{variables}}.
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if strings.HasPrefix(s, ":") && len(s) > 1 {
+			name := s[1:]
+			params[name] = aliasParam{Default: lookup(r.URL.Variable, name)}
+			segments[i] = "${" + name + "}"
+		}
+	}
+	a.Path = convert(strings.Join(segments, "/"))
+
+	var query []string
+	if len(r.URL.Query) > 0 {
+		for _, q := range r.URL.Query {
+			if !q.Disabled {
+				query = append(query, q.Key+"="+string(q.Value))
+			}
+		}
+	} else if len(parts) == 2 {
+		query = append(query, parts[1])
+	}
+	a.Query = convert(strings.Join(query, "&"))
+
+	for _, h := range r.Header {
+		if !h.Disabled {
+			a.Headers = append(a.Headers, convert(h.Key+"="+string(h.Value)))
+		}
+	}
+
+	if r.Body != nil {
+		switch r.Body.Mode {
+		case "raw":
+			a.Body = convert(r.Body.Raw)
+		case "urlencoded":
+			form := url.Values{}
+			for _, kv := range r.Body.URLEncoded {
+				if !kv.Disabled {
+					form.Add(kv.Key, string(kv.Value))
+				}
+			}
+			a.Body = convert(form.Encode())
+			a.Headers = append(a.Headers, "Content-Type=application/x-www-form-urlencoded")
+		case "":
+		default:
+			log.Printf("Ignoring unsupported %v body of %v %v", r.Body.Mode, r.Method, raw)
+		}
+	}
+
+	if len(params) > 0 {
+		a.Params = params
+	}
+	return a, base, nil
+}
+
+// exportPostman converts the aliases to a collection. Alias params become
+// collection variables and the host is the {{baseUrl}} variable. Credentials
+// are left as empty variables rather than exported.
+func exportPostman(name, baseURL, auth string, aliases map[string]alias) postmanCollection {
+	c := postmanCollection{
+		Info:     postmanInfo{Name: name, Schema: postmanSchema},
+		Item:     []postmanItem{},
+		Variable: []postmanKeyValue{{Key: "baseUrl", Value: postmanText(baseURL)}},
+	}
+
+	switch auth {
+	case "token":
+		c.Auth = &postmanAuth{Type: "bearer", Bearer: []postmanKeyValue{{Key: "token", Value: "{{token}}"}}}
+		c.Variable = append(c.Variable, postmanKeyValue{Key: "token"})
+	case "basic":
+		c.Auth = &postmanAuth{Type: "basic", Basic: []postmanKeyValue{{Key: "username", Value: "{{user}}"}, {Key: "password", Value: "{{pass}}"}}}
+		c.Variable = append(c.Variable, postmanKeyValue{Key: "user"}, postmanKeyValue{Key: "pass"})
+	}
+
+	names := make([]string, 0, len(aliases))
+	for n := range aliases {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	seen := map[string]bool{"baseUrl": true}
+	for _, n := range names {
+		a := aliases[n]
+
+		params := make([]string, 0, len(a.Params))
+		for p := range a.Params {
+			params = append(params, p)
+		}
+		sort.Strings(params)
+		for _, p := range params {
+			if !seen[p] {
+				seen[p] = true
+				c.Variable = append(c.Variable, postmanKeyValue{Key: p, Value: postmanText(a.Params[p].Default)})
+			}
+		}
+
+		convert := func(s string) string {
+			return aliasParamPattern.ReplaceAllString(s, "{{$1}}")
+		}
+
+		r := &postmanRequest{
+			Method:      strings.ToUpper(a.Verb),
+			Description: postmanText(a.Help),
+			URL: postmanURL{
+				Raw:  "{{baseUrl}}" + convert(a.Path),
+				Host: []string{"{{baseUrl}}"},
+				Path: strings.Split(strings.TrimPrefix(convert(a.Path), "/"), "/"),
+			},
+		}
+
+		if a.Query != "" {
+			r.URL.Raw += "?" + convert(a.Query)
+			for _, q := range strings.Split(convert(a.Query), "&") {
+				kv := strings.SplitN(q, "=", 2)
+				if len(kv) == 1 {
+					kv = append(kv, "")
+				}
+				r.URL.Query = append(r.URL.Query, postmanKeyValue{Key: kv[0], Value: postmanText(kv[1])})
+			}
+		}
+
+		for _, h := range a.Headers {
+			kv := strings.SplitN(convert(h), "=", 2)
+			if len(kv) == 1 {
+				kv = append(kv, "")
+			}
+			r.Header = append(r.Header, postmanKeyValue{Key: kv[0], Value: postmanText(kv[1])})
+		}
+
+		if a.Body != "" {
+			r.Body = &postmanBody{Mode: "raw", Raw: convert(a.Body)}
+			if json.Valid([]byte(a.Body)) {
+				r.Body.Options = map[string]interface{}{"raw": map[string]string{"language": "json"}}
+			}
+		}
+
+		c.Item = append(c.Item, postmanItem{Name: n, Request: r})
+	}
+
+	return c
+}
+
+func resolvePostmanVariables(s string, vars map[string]string) string {
+	return postmanVariablePattern.ReplaceAllStringFunc(s, func(m string) string {
+		if v, ok := vars[postmanVariablePattern.FindStringSubmatch(m)[1]]; ok {
+			return v
+		}
+		return m
+	})
+}
+
+// aliasName joins the folder and name of a request into a command name, e.g.
+// "Users" and "List users" becomes users-list-users.
+func aliasName(prefix, name string) string {
+	var b bytes.Buffer
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+
+	switch {
+	case prefix == "":
+		return b.String()
+	case b.Len() == 0:
+		return prefix
+	}
+	return prefix + "-" + b.String()
+}
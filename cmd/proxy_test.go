@@ -0,0 +1,284 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// proxyLog records the requests seen by the test proxies.
+type proxyLog struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (l *proxyLog) add(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, fmt.Sprintf(format, args...))
+}
+
+func (l *proxyLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := l.entries
+	l.entries = nil
+	return entries
+}
+
+// httpProxy is a forward proxy for plain HTTP requests.
+func httpProxy(l *proxyLog) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		l.add("http %v %v", req.URL, req.Header.Get("Proxy-Authorization"))
+
+		out, _ := http.NewRequest(req.Method, req.URL.String(), req.Body)
+		resp, err := http.DefaultTransport.RoundTrip(out)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+}
+
+// socks5Proxy is a SOCKS5 proxy supporting CONNECT with username/password
+// authentication (RFC 1928 and 1929).
+func socks5Proxy(t *testing.T, l *proxyLog) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if err := socks5Serve(conn, l); err != nil {
+					l.add("socks5 error: %v", err)
+				}
+			}()
+		}
+	}()
+	return ln
+}
+
+func socks5Serve(conn net.Conn, l *proxyLog) error {
+	read := func(n int) ([]byte, error) {
+		b := make([]byte, n)
+		_, err := io.ReadFull(conn, b)
+		return b, err
+	}
+
+	// Greeting: version, number of methods, methods.
+	header, err := read(2)
+	if err != nil {
+		return err
+	}
+	if _, err := read(int(header[1])); err != nil {
+		return err
+	}
+	conn.Write([]byte{5, 2})
+
+	// Username/password: version, user, pass.
+	header, err = read(2)
+	if err != nil {
+		return err
+	}
+	user, _ := read(int(header[1]))
+	passLen, _ := read(1)
+	pass, _ := read(int(passLen[0]))
+	conn.Write([]byte{1, 0})
+
+	// Request: version, command, reserved, address type, address, port.
+	header, err = read(4)
+	if err != nil {
+		return err
+	}
+	var host string
+	switch header[3] {
+	case 1:
+		ip, _ := read(4)
+		host = net.IP(ip).String()
+	case 3:
+		n, _ := read(1)
+		name, _ := read(int(n[0]))
+		host = string(name)
+	default:
+		return fmt.Errorf("unsupported address type %v", header[3])
+	}
+	port, _ := read(2)
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	l.add("socks5 %v %v:%v", target, string(user), string(pass))
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return err
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+	return nil
+}
+
+func TestProxy(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"id":1}`)
+	}))
+	defer s.Close()
+	target := s.Listener.Addr().String()
+
+	l := &proxyLog{}
+	hp := httpProxy(l)
+	defer hp.Close()
+	sp := socks5Proxy(t, l)
+	defer sp.Close()
+	hpAddr, spAddr := hp.Listener.Addr().String(), sp.Addr().String()
+
+	testCases := []struct {
+		desc   string
+		args   []string
+		config map[string]interface{}
+
+		expectProxied []string
+		expectStderr  string
+		expectErr     string
+	}{
+		{
+			desc:          "http proxy with credentials",
+			args:          []string{"get", "/posts", "--proxy", "http://me:secret@" + hpAddr},
+			expectProxied: []string{"http http://" + target + "/posts Basic bWU6c2VjcmV0"},
+		}, {
+			desc:          "http proxy without scheme and credentials from config",
+			args:          []string{"get", "/posts", "--proxy", hpAddr},
+			config:        map[string]interface{}{"proxy-user": "me", "proxy-pass": "secret"},
+			expectProxied: []string{"http http://" + target + "/posts Basic bWU6c2VjcmV0"},
+		}, {
+			desc:   "no-proxy",
+			args:   []string{"get", "/posts", "--no-proxy", "example.com,127.0.0.0/8"},
+			config: map[string]interface{}{"proxy": hpAddr},
+		}, {
+			desc:          "no-proxy on another port",
+			args:          []string{"get", "/posts", "--proxy", hpAddr, "--no-proxy", "127.0.0.1:1"},
+			expectProxied: []string{"http http://" + target + "/posts"},
+		}, {
+			desc:          "socks5 from config",
+			args:          []string{"get", "/posts"},
+			config:        map[string]interface{}{"socks5": "me:secret@" + spAddr},
+			expectProxied: []string{"socks5 " + target + " me:secret"},
+		}, {
+			desc:          "trace shows the proxy",
+			args:          []string{"get", "/posts", "--socks5", "socks5://me:secret@" + spAddr, "--trace"},
+			expectProxied: []string{"socks5 " + target + " me:secret"},
+			expectStderr:  "Sending request to " + target + " via proxy socks5://me:xxxxx@" + spAddr,
+		}, {
+			desc:      "both proxies",
+			args:      []string{"get", "/posts", "--proxy", hpAddr, "--socks5", spAddr},
+			expectErr: "only one of proxy and socks5 may be set",
+		}, {
+			desc:      "invalid scheme",
+			args:      []string{"get", "/posts", "--proxy", "ftp://" + hpAddr},
+			expectErr: `invalid proxy "ftp://` + hpAddr + `", expected a scheme of http or https`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ResetSettings()
+			viper.Set("scheme", "http")
+			viper.Set("domain", target)
+			for k, v := range tc.config {
+				viper.Set(k, v)
+			}
+
+			stdout, stderr, err := captureOutput(execute, tc.args, nil)
+			switch {
+			case err == nil && tc.expectErr != "":
+				t.Errorf("Expected error %q but got none", tc.expectErr)
+			case err != nil && err.Error() != tc.expectErr:
+				t.Errorf("Expected error %q, got %q", tc.expectErr, err)
+			}
+			if err == nil && stdout != `{"id":1}`+"\n" {
+				t.Errorf("Expected the response on stdout, got %q", stdout)
+			}
+
+			var proxied []string
+			for _, e := range l.get() {
+				proxied = append(proxied, strings.TrimSpace(e))
+			}
+			if !reflect.DeepEqual(proxied, tc.expectProxied) {
+				t.Errorf("Expected proxied requests %q, got %q", tc.expectProxied, proxied)
+			}
+			if !strings.Contains(stderr, tc.expectStderr) {
+				t.Errorf("Expected stderr to contain %q, got %q", tc.expectStderr, stderr)
+			}
+		})
+	}
+}
+
+func TestBypassProxy(t *testing.T) {
+	noProxy := []string{"internal.example.com", ".corp", "10.0.0.0/8", "localhost:8080"}
+	testCases := []struct {
+		url    string
+		expect bool
+	}{
+		{url: "http://internal.example.com/a", expect: true},
+		{url: "http://api.internal.example.com/a", expect: true},
+		{url: "http://example.com/a", expect: false},
+		{url: "http://db.corp/a", expect: true},
+		{url: "http://10.1.2.3/a", expect: true},
+		{url: "http://11.1.2.3/a", expect: false},
+		{url: "http://localhost:8080/a", expect: true},
+		{url: "http://localhost:9090/a", expect: false},
+	}
+	for _, tc := range testCases {
+		u, _ := url.Parse(tc.url)
+		if got := bypassProxy(u, noProxy); got != tc.expect {
+			t.Errorf("%v: Expected bypass %v, got %v", tc.url, tc.expect, got)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Jeffail/gabs"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	// defaultSessionProfile is the profile of sessions when the default
+	// config file is used.
+	defaultSessionProfile = "default"
+
+	// captureHeaderPrefix marks captures of response headers rather than
+	// fields of the body.
+	captureHeaderPrefix = "header:"
+)
+
+var (
+	sessionNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+	// sessionRefPattern matches references to session values, e.g.
+	// ${session.token}.
+	sessionRefPattern = regexp.MustCompile(`\$\{session\.([^}]*)\}`)
+)
+
+// sessionCapture stores the value at path in the response as the session value
+// name. Paths are fields of the body unless prefixed with header:.
+type sessionCapture struct {
+	name, path string
+}
+
+// parseCaptures parses the --capture values of the form NAME=PATH.
+func parseCaptures(captures []string) ([]sessionCapture, error) {
+	var parsed []sessionCapture
+	for _, c := range captures {
+		parts := strings.SplitN(c, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid capture %q, expected NAME=PATH (e.g. token=data.token or csrf=header:X-CSRF-Token)", c)
+		}
+		if !sessionNamePattern.MatchString(parts[0]) {
+			return nil, fmt.Errorf("invalid capture %q; names may only contain letters, digits, _ and -", c)
+		}
+		parsed = append(parsed, sessionCapture{name: parts[0], path: parts[1]})
+	}
+	return parsed, nil
+}
+
+// stateDir is the directory jaq keeps state between runs in, such as session
+// values and cookies.
+func stateDir() string {
+	if state := os.Getenv("XDG_STATE_HOME"); state != "" {
+		return filepath.Join(state, "jaq")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".local", "state", "jaq")
+}
+
+// sessionPath is the file the session values of all profiles are stored in.
+func sessionPath() string {
+	if p := viper.GetString("session-file"); p != "" {
+		return p
+	}
+	return filepath.Join(stateDir(), "session.json")
+}
+
+// sessionProfile identifies the config file in use so that each profile has
+// its own session values.
+func sessionProfile() string {
+	used := viper.GetString("config")
+	if used == "" {
+		return defaultSessionProfile
+	}
+	if abs, err := filepath.Abs(used); err == nil {
+		return abs
+	}
+	return used
+}
+
+// readSessions reads the session values of all profiles. A missing file is not
+// an error since it just means nothing has been captured yet.
+func readSessions() (map[string]map[string]string, error) {
+	sessions := map[string]map[string]string{}
+
+	b, err := ioutil.ReadFile(sessionPath())
+	if os.IsNotExist(err) {
+		return sessions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &sessions); err != nil {
+		return nil, fmt.Errorf("invalid session file %v: %v", sessionPath(), err)
+	}
+	return sessions, nil
+}
+
+// writeSessions replaces the session file. It is only readable by the user
+// since it tends to hold credentials.
+func writeSessions(sessions map[string]map[string]string) error {
+	path := sessionPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0600)
+}
+
+// loadSession returns the session values of the current profile.
+func loadSession() (map[string]string, error) {
+	sessions, err := readSessions()
+	if err != nil {
+		return nil, err
+	}
+	if values, ok := sessions[sessionProfile()]; ok {
+		return values, nil
+	}
+	return map[string]string{}, nil
+}
+
+// expandSession replaces the references to session values in the string. It
+// is an error to refer to a value which has not been captured.
+func expandSession(s string, values map[string]string) (string, error) {
+	var err error
+	expanded := sessionRefPattern.ReplaceAllStringFunc(s, func(m string) string {
+		name := sessionRefPattern.FindStringSubmatch(m)[1]
+		v, ok := values[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("session value %q is not set; capture it via --capture %v=PATH", name, name)
+		}
+		return v
+	})
+	return expanded, err
+}
+
+// expandSessionConfig replaces the references to session values in the path,
+// query, headers and body. The session is only read if there are any.
+func expandSessionConfig(conf *config, path *string) error {
+	var values map[string]string
+	expand := func(s *string) error {
+		if !sessionRefPattern.MatchString(*s) {
+			return nil
+		}
+		if values == nil {
+			var err error
+			if values, err = loadSession(); err != nil {
+				return err
+			}
+		}
+
+		expanded, err := expandSession(*s, values)
+		*s = expanded
+		return err
+	}
+
+	for _, s := range []*string{path, &conf.query, &conf.body} {
+		if err := expand(s); err != nil {
+			return err
+		}
+	}
+
+	// Copied so that the headers of the caller are not modified.
+	headers := append([]string{}, conf.headers...)
+	for i := range headers {
+		if err := expand(&headers[i]); err != nil {
+			return err
+		}
+	}
+	conf.headers = headers
+	return nil
+}
+
+// captureSession stores the values of the captures from a successful response.
+// The body is replaced so that it can still be output afterwards.
+func captureSession(conf config, resp *http.Response) error {
+	if resp.StatusCode >= 400 {
+		log.Printf("Not capturing session values from response with status %v", resp.Status)
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	var parsed *gabs.Container
+	captured := map[string]string{}
+	for _, c := range conf.captures {
+		if strings.HasPrefix(c.path, captureHeaderPrefix) {
+			header := strings.TrimPrefix(c.path, captureHeaderPrefix)
+			if v := resp.Header.Get(header); v != "" {
+				captured[c.name] = v
+			} else {
+				log.Printf("Header %v not found in response; %v not captured", header, c.name)
+			}
+			continue
+		}
+
+		if parsed == nil {
+			if parsed, err = gabs.ParseJSON(body); err != nil {
+				log.Printf("Unable to parse response as JSON; %v not captured: %v", c.name, err)
+				continue
+			}
+		}
+		switch v := parsed.Path(c.path).Data().(type) {
+		case nil:
+			log.Printf("Field %v not found in response; %v not captured", c.path, c.name)
+		case string:
+			captured[c.name] = v
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			captured[c.name] = string(b)
+		}
+	}
+
+	if len(captured) == 0 {
+		return nil
+	}
+	return updateSession(func(values map[string]string) {
+		for k, v := range captured {
+			values[k] = v
+		}
+	})
+}
+
+// updateSession applies the change to the session values of the current
+// profile and saves them.
+func updateSession(update func(map[string]string)) error {
+	sessions, err := readSessions()
+	if err != nil {
+		return err
+	}
+
+	profile := sessionProfile()
+	if sessions[profile] == nil {
+		sessions[profile] = map[string]string{}
+	}
+	update(sessions[profile])
+	if len(sessions[profile]) == 0 {
+		delete(sessions, profile)
+	}
+	return writeSessions(sessions)
+}
+
+func sessionCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "session",
+		Short: "Manage the values captured from responses via --capture",
+		Long: `Manage the values captured from responses via --capture. Values are stored
+per config file and used in later commands as ${session.NAME}.
+
+Examples:
+
+> jaq post /login -b '{"user":"me"}' --capture token=token
+> jaq get /posts -H 'Authorization=Bearer ${session.token}'
+> jaq session list
+> jaq session clear token`,
+	}
+	c.AddCommand(sessionListCommand(), sessionClearCommand())
+	return c
+}
+
+func sessionListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "Write the session values of the current config to stdout as JSON",
+		Args:  cobra.NoArgs,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			values, err := loadSession()
+			if err != nil {
+				return err
+			}
+			return encodeJSON(os.Stdout, values)
+		},
+	}
+}
+
+func sessionClearCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "clear [NAME...]",
+		Short: "Remove the given session values, or all of them, for the current config",
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return updateSession(func(values map[string]string) {
+				if len(args) == 0 {
+					for k := range values {
+						delete(values, k)
+					}
+				}
+				for _, k := range args {
+					delete(values, k)
+				}
+			})
+		},
+	}
+}
+
+func init() {
+	ResetSettingsSession()
+}
+
+func ResetSettingsSession() {
+	RootCmd.AddCommand(sessionCommand())
+}
@@ -0,0 +1,151 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestSession(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	ioutil.WriteFile(filepath.Join(tmpDir, "other.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+	os.Unsetenv("XDG_STATE_HOME")
+
+	var got []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = append(got, strings.TrimSpace(req.Method+" "+req.URL.String()+" "+req.Header.Get("Authorization")))
+		if req.URL.Path == "/login" {
+			w.Header().Set("X-Csrf-Token", "xyz")
+			fmt.Fprint(w, `{"data":{"token":"abc","roles":["admin"]}}`)
+		}
+	}))
+	defer s.Close()
+
+	// Each step relies on the session values of those before it.
+	steps := []struct {
+		desc  string
+		args  []string
+		input string
+
+		expectRequests []string
+		expectStdout   string
+		expectErr      string
+	}{
+		{
+			desc:           "capture",
+			args:           []string{"post", "/login", "--capture", "token=data.token", "--capture", "csrf=header:X-Csrf-Token", "--capture", "roles=data.roles", "--capture", "missing=data.nope"},
+			expectRequests: []string{"POST /login"},
+			expectStdout:   `{"data":{"token":"abc","roles":["admin"]}}` + "\n",
+		}, {
+			desc:           "reference",
+			args:           []string{"get", "/posts", "-H", "Authorization=Bearer ${session.token}", "-q", "csrf=${session.csrf}"},
+			expectRequests: []string{"GET /posts?csrf=xyz Bearer abc"},
+			expectStdout:   "\n",
+		}, {
+			desc:           "reference with piped input",
+			args:           []string{"get", "/posts/${1.id}", "-H", "Authorization=Bearer ${session.token}"},
+			input:          `{"id":1} {"id":2}`,
+			expectRequests: []string{"GET /posts/1 Bearer abc", "GET /posts/2 Bearer abc"},
+			expectStdout:   "\n\n",
+		}, {
+			desc:      "reference to missing value",
+			args:      []string{"get", "/posts/${session.missing}"},
+			expectErr: `session value "missing" is not set; capture it via --capture missing=PATH`,
+		}, {
+			desc:         "reference in dry-run body",
+			args:         []string{"post", "/posts", "-b", `{"t":"${session.token}"}`, "--dry-run=jaq"},
+			expectStdout: `jaq post /posts --body '{"t":"abc"}'` + "\n",
+		}, {
+			desc:         "reference in plan body",
+			args:         []string{"plan", "post", "/posts", "-b", `{"t":"${session.token}"}`},
+			expectStdout: fmt.Sprintf(`{"method":"POST","url":"http://%v/posts","body":"{\"t\":\"abc\"}"}`, s.Listener.Addr()) + "\n",
+		}, {
+			desc:         "list",
+			args:         []string{"session", "list"},
+			expectStdout: "{\n  \"csrf\": \"xyz\",\n  \"roles\": \"[\\\"admin\\\"]\",\n  \"token\": \"abc\"\n}\n",
+		}, {
+			desc:         "list other profile",
+			args:         []string{"session", "list", "--config", filepath.Join(tmpDir, "other.json")},
+			expectStdout: "{}\n",
+		}, {
+			desc:         "clear one",
+			args:         []string{"session", "clear", "token", "roles"},
+			expectStdout: "",
+		}, {
+			desc:         "list after clear",
+			args:         []string{"session", "list"},
+			expectStdout: "{\n  \"csrf\": \"xyz\"\n}\n",
+		}, {
+			desc: "clear all",
+			args: []string{"session", "clear"},
+		}, {
+			desc:         "list after clear all",
+			args:         []string{"session", "list"},
+			expectStdout: "{}\n",
+		},
+	}
+
+	for _, tc := range steps {
+		ResetSettings()
+		viper.Set("scheme", "http")
+		viper.Set("domain", s.Listener.Addr().String())
+		got = nil
+
+		var input io.Reader
+		if tc.input != "" {
+			input = strings.NewReader(tc.input)
+		}
+
+		stdout, _, err := captureOutput(execute, tc.args, input)
+		switch {
+		case err == nil && tc.expectErr != "":
+			t.Errorf("%v: Expected error %q but got none", tc.desc, tc.expectErr)
+		case err != nil && err.Error() != tc.expectErr:
+			t.Errorf("%v: Expected error %q, got %q", tc.desc, tc.expectErr, err)
+		}
+
+		if !reflect.DeepEqual(got, tc.expectRequests) {
+			t.Errorf("%v: Expected requests %q, got %q", tc.desc, tc.expectRequests, got)
+		}
+		if stdout != tc.expectStdout {
+			t.Errorf("%v: Expected stdout %q, got %q", tc.desc, tc.expectStdout, stdout)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(tmpDir, ".local", "state", "jaq", "session.json"))
+	if err != nil {
+		t.Fatalf("Expected session file to exist: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected session file to only be readable by the user, got %v", info.Mode())
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// eventField and idField are added to server-sent events which have an
+	// event type or ID.
+	eventField = headerPrefix + "event"
+	idField    = headerPrefix + "id"
+
+	// lastEventIDHeader is sent when reconnecting to a server-sent event
+	// stream so that the server can resume after the last event received.
+	lastEventIDHeader = "Last-Event-ID"
+)
+
+// eventStream writes the events of a streamed response as lines of JSON.
+type eventStream struct {
+	w io.Writer
+
+	// lastID and retry are set by the server-sent events and kept across
+	// reconnects.
+	lastID string
+	retry  time.Duration
+
+	// events is the number of events written from the current connection.
+	events int
+}
+
+// streamResponse writes each server-sent event or NDJSON line of the response
+// as a line of JSON as soon as it arrives. NDJSON ends with the body. When the
+// connection to a server-sent event stream is closed or drops, it is
+// reconnected with the ID of the last event, as browsers do. It ends when the
+// server responds to a reconnect with 204 No Content, or once the reconnects
+// run out; that is only an error if the connection was not closed cleanly.
+func streamResponse(conf config, build func() (*http.Request, error), req *http.Request, resp *http.Response) error {
+	s := &eventStream{w: conf.stdout, retry: conf.retryWait}
+
+	var failures int
+	var err error
+	for {
+		if resp != nil {
+			sse := isEventStream(resp)
+			s.events = 0
+			err = s.read(resp.Body, sse)
+			resp.Body.Close()
+			if !sse || runCtx.Err() != nil {
+				return err
+			}
+			if err == nil {
+				err = io.EOF
+			}
+			if s.events > 0 {
+				failures = 0
+			}
+		}
+
+		if failures >= conf.streamReconnects {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("stream from %v %v failed after %d reconnect attempts: %v", req.Method, req.URL, failures, err)
+		}
+		failures++
+		if err == io.EOF {
+			log.Printf("Stream from %v %v closed; reconnecting in %v (attempt %d of %d)", req.Method, req.URL, s.retry, failures, conf.streamReconnects)
+		} else {
+			log.Printf("Stream from %v %v dropped (%v); reconnecting in %v (attempt %d of %d)", req.Method, req.URL, err, s.retry, failures, conf.streamReconnects)
+		}
+
+		select {
+		case <-time.After(s.retry):
+		case <-runCtx.Done():
+			return runCtx.Err()
+		}
+
+		next, buildErr := build()
+		if buildErr != nil {
+			return buildErr
+		}
+		if s.lastID != "" {
+			next.Header.Set(lastEventIDHeader, s.lastID)
+		}
+
+		resp, err = response(conf, next)
+		switch {
+		case err != nil && runCtx.Err() != nil:
+			return err
+		case err != nil:
+			resp = nil
+		case resp.StatusCode == http.StatusNoContent:
+			resp.Body.Close()
+			return nil
+		case resp.StatusCode >= 300:
+			resp.Body.Close()
+			err = errors.New(resp.Status)
+			resp = nil
+		}
+	}
+}
+
+func isEventStream(resp *http.Response) bool {
+	t, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return err == nil && t == "text/event-stream"
+}
+
+// read writes the events of the body until it ends. A final line or event
+// which was cut short is only written for NDJSON since server-sent events are
+// only complete once followed by a blank line.
+func (s *eventStream) read(body io.Reader, sse bool) error {
+	r := bufio.NewReader(body)
+
+	var event string
+	var data []string
+	for first := true; ; first = false {
+		line, err := r.ReadString('\n')
+		switch {
+		case err == io.EOF && sse:
+			return nil
+		case err != nil && err != io.EOF:
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+
+		switch {
+		case !sse:
+			// Records of a JSON text sequence (RFC 7464) start with RS.
+			line = strings.TrimSpace(strings.TrimLeft(line, "\x1e"))
+			if line != "" {
+				if err := s.write([]byte(line), nil); err != nil {
+					return err
+				}
+			}
+		case line == "":
+			// A blank line dispatches the event; those without data are not
+			// dispatched.
+			if len(data) > 0 {
+				fields := map[string]string{}
+				if event != "" {
+					fields[eventField] = event
+				}
+				if s.lastID != "" {
+					fields[idField] = s.lastID
+				}
+				if err := s.write([]byte(strings.Join(data, "\n")), fields); err != nil {
+					return err
+				}
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, ":"):
+			// Comments are typically sent to keep the connection alive.
+		default:
+			name, value := line, ""
+			if i := strings.Index(line, ":"); i >= 0 {
+				name, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+			}
+			switch name {
+			case "data":
+				data = append(data, value)
+			case "event":
+				event = value
+			case "id":
+				if !strings.Contains(value, "\x00") {
+					s.lastID = value
+				}
+			case "retry":
+				if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+					s.retry = time.Duration(ms) * time.Millisecond
+				}
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// write writes the data as a single line of JSON with the fields added. JSON
+// objects without fields to add are written as they are; anything else is
+// put in the body field so that each line can be used as piped input.
+func (s *eventStream) write(data []byte, fields map[string]string) error {
+	data = bytes.TrimSpace(data)
+	b := &bytes.Buffer{}
+	if len(fields) > 0 || !bytes.HasPrefix(data, []byte("{")) || json.Compact(b, data) != nil {
+		tagged, err := tagFields(data, fields)
+		if err != nil {
+			return err
+		}
+		b.Reset()
+		b.Write(tagged)
+	}
+	b.WriteString("\n")
+
+	s.events++
+	_, err := s.w.Write(b.Bytes())
+	return err
+}
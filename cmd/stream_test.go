@@ -0,0 +1,211 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestStream(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	// dropped writes the events then drops the connection without ending the
+	// chunked body.
+	dropped := func(w http.ResponseWriter, events string) {
+		fmt.Fprint(w, events)
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		lastID := req.Header.Get("Last-Event-ID")
+		switch {
+		case req.URL.Path == "/events" && lastID == "":
+			dropped(w, ": ping\n\nretry: 1\n\nid: 1\nevent: update\ndata: {\"n\":1}\n\ndata: plain\n\n")
+		case req.URL.Path == "/events" && lastID == "1":
+			fmt.Fprint(w, "id: 2\r\ndata: {\"n\":\r\ndata: 2}\r\n\r\nid: 3\ndata: cut short")
+		case req.URL.Path == "/events":
+			w.WriteHeader(http.StatusNoContent)
+		case req.URL.Path == "/closes":
+			// Closed cleanly after each event; resumed via Last-Event-ID.
+			n, _ := strconv.Atoi(lastID)
+			if n == 2 {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			fmt.Fprintf(w, "retry: 1\nid: %d\ndata: {\"n\":%d}\n\n", n+1, n+1)
+		case req.URL.Path == "/quiet":
+			fmt.Fprint(w, "retry: 1\n\n")
+		case req.URL.Path == "/ended" && lastID == "":
+			dropped(w, "retry: 1\nid: 1\ndata: {\"n\":1}\n\n")
+		case req.URL.Path == "/ended":
+			w.WriteHeader(http.StatusNoContent)
+		case req.URL.Path == "/drops":
+			dropped(w, "retry: 1\n\n")
+		case req.URL.Path == "/ndjson":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			fmt.Fprint(w, "{\"a\":1}\n\n[1,2]\n\x1e{\"b\": 2}")
+		case strings.HasPrefix(req.URL.Path, "/items/"):
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"path":%q}`, req.URL.Path)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer s.Close()
+	addr := s.Listener.Addr().String()
+
+	testCases := []struct {
+		desc  string
+		args  []string
+		input string
+
+		expectStdout string
+		expectStderr string
+		expectErr    string
+	}{
+		{
+			desc: "server-sent events",
+			args: []string{"get", "/events", "--stream"},
+			expectStdout: `{"jaq-event":"update","jaq-id":"1","n":1}` + "\n" +
+				`{"jaq-body":"plain","jaq-id":"1"}` + "\n" +
+				`{"jaq-id":"2","n":2}` + "\n",
+			expectStderr: "reconnecting in 1ms (attempt 1 of 3)",
+		}, {
+			desc:         "closed streams are resumed",
+			args:         []string{"get", "/closes", "--stream"},
+			expectStdout: `{"jaq-id":"1","n":1}` + "\n" + `{"jaq-id":"2","n":2}` + "\n",
+			expectStderr: "closed; reconnecting in 1ms (attempt 1 of 3)",
+		}, {
+			desc:         "closed streams end once reconnects run out",
+			args:         []string{"get", "/quiet", "--stream", "--stream-reconnects", "2"},
+			expectStderr: "closed; reconnecting in 1ms (attempt 2 of 2)",
+		}, {
+			desc:         "no content ends the stream",
+			args:         []string{"get", "/ended", "--stream"},
+			expectStdout: `{"jaq-id":"1","n":1}` + "\n",
+		}, {
+			desc:      "reconnects exhausted",
+			args:      []string{"get", "/drops", "--stream", "--stream-reconnects", "2"},
+			expectErr: fmt.Sprintf("stream from GET http://%v/drops failed after 2 reconnect attempts: unexpected EOF", addr),
+		}, {
+			desc:         "ndjson",
+			args:         []string{"get", "/ndjson", "--stream"},
+			expectStdout: `{"a":1}` + "\n" + `{"jaq-body":[1,2]}` + "\n" + `{"b":2}` + "\n",
+		}, {
+			desc:         "without stream",
+			args:         []string{"get", "/ndjson"},
+			expectStdout: "{\"a\":1}\n\n[1,2]\n\x1e{\"b\": 2}\n",
+		}, {
+			desc:         "dry run",
+			args:         []string{"get", "/events", "--stream", "--dry-run=curl"},
+			expectStdout: fmt.Sprintf("curl -X GET --no-buffer http://%v/events\n", addr),
+		}, {
+			desc:         "stream input",
+			args:         []string{"get", "/items/${1.id}", "--stream-input"},
+			input:        `{"id":1} {"id":2}`,
+			expectStdout: `{"path":"/items/1"}` + "\n" + `{"path":"/items/2"}` + "\n",
+		}, {
+			desc:         "stream input max requests",
+			args:         []string{"get", "/items/${1.id}", "--stream-input", "--max-requests", "1"},
+			input:        `{"id":1} {"id":2}`,
+			expectStdout: `{"path":"/items/1"}` + "\n",
+			expectErr:    "refusing to send more than 1 requests; exceeds --max-requests",
+		}, {
+			desc:      "stream input checkpoint",
+			args:      []string{"get", "/items/${1.id}", "--stream-input", "--checkpoint", filepath.Join(tmpDir, "checkpoint")},
+			input:     `{"id":1}`,
+			expectErr: "checkpoint can not be used with stream-input since the rows are not known in advance",
+		}, {
+			desc:      "targets",
+			args:      []string{"get", "/events", "--stream", "--targets", "a,b"},
+			expectErr: "stream can not be used with targets",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ResetSettings()
+			viper.Set("scheme", "http")
+			viper.Set("domain", addr)
+
+			var input io.Reader
+			if tc.input != "" {
+				input = strings.NewReader(tc.input)
+			}
+
+			stdout, stderr, err := captureOutput(execute, tc.args, input)
+			switch {
+			case err == nil && tc.expectErr != "":
+				t.Errorf("Expected error %q but got none", tc.expectErr)
+			case err != nil && err.Error() != tc.expectErr:
+				t.Errorf("Expected error %q, got %q", tc.expectErr, err)
+			}
+
+			if stdout != tc.expectStdout {
+				t.Errorf("Expected stdout %q, got %q", tc.expectStdout, stdout)
+			}
+			if !strings.Contains(stderr, tc.expectStderr) {
+				t.Errorf("Expected stderr to contain %q, got %q", tc.expectStderr, stderr)
+			}
+		})
+	}
+}
+
+func TestEventStreamWritesEachEvent(t *testing.T) {
+	r, w := io.Pipe()
+	lines := make(chan string)
+	s := &eventStream{w: writerFunc(func(b []byte) (int, error) {
+		lines <- string(b)
+		return len(b), nil
+	})}
+	done := make(chan error)
+	go func() { done <- s.read(r, true) }()
+
+	// Each event is written before the next one is sent.
+	for i := 1; i <= 2; i++ {
+		go fmt.Fprintf(w, "id: %d\ndata: {\"n\":%d}\n\n", i, i)
+		expected := fmt.Sprintf(`{"jaq-id":"%d","n":%d}`+"\n", i, i)
+		if line := <-lines; line != expected {
+			t.Errorf("Expected %q got %q", expected, line)
+		}
+	}
+
+	w.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Expected the stream to end without error, got %v", err)
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) { return f(b) }
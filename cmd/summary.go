@@ -0,0 +1,269 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exit codes returned by jaq so that scripts can tell how a run went without
+// parsing the output.
+const (
+	exitOK             = 0
+	exitPartialFailure = 1
+	exitAllFailed      = 2
+	exitConfigError    = 3
+	exitInputError     = 4
+	exitDeadline       = 5
+	exitDifferences    = 6
+
+	// exitInterrupted follows the shell convention of 128 plus the signal.
+	exitInterrupted = 130
+)
+
+// exitError is an error which should cause a specific exit code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// stats collects the outcome of every request sent during a run. It is reset at
+// the start of each execute.
+var stats = newRunStats()
+
+// runStats is the data used for the end-of-run summary and exit code.
+type runStats struct {
+	start     time.Time
+	latencies []time.Duration
+	classes   map[string]int
+	failures  map[string]int
+	succeeded int
+	failed    int
+
+	// differences is the number of diffs whose responses differed.
+	differences int
+
+	// comparing is set for diffs, whose exit code depends on whether the
+	// responses differed rather than whether the requests failed.
+	comparing bool
+}
+
+// summary is the JSON form of the end-of-run summary.
+type summary struct {
+	Requests   int                `json:"requests"`
+	Succeeded  int                `json:"succeeded"`
+	Failed     int                `json:"failed"`
+	Classes    map[string]int     `json:"statusClasses"`
+	Failures   map[string]int     `json:"failures"`
+	LatencyMs  map[string]float64 `json:"latencyMs"`
+	WallTimeMs float64            `json:"wallTimeMs"`
+}
+
+func newRunStats() *runStats {
+	return &runStats{
+		start:    time.Now(),
+		classes:  map[string]int{},
+		failures: map[string]int{},
+	}
+}
+
+// recordChecked adds the outcome of a single request which had assertions. The
+// assertions alone decide if the request failed so that, for instance, an
+// expected 404 is not counted as a failure.
+func (s *runStats) recordChecked(resp *http.Response, err error, latency time.Duration, failures []string) {
+	s.add(resp, err, latency, len(failures) > 0)
+}
+
+func (s *runStats) add(resp *http.Response, err error, latency time.Duration, failed bool) {
+	s.latencies = append(s.latencies, latency)
+
+	var class, status string
+	switch {
+	case err != nil || resp == nil:
+		class, status = "error", "error"
+	default:
+		class = fmt.Sprintf("%dxx", resp.StatusCode/100)
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	s.classes[class]++
+
+	if failed {
+		s.failed++
+		s.failures[status]++
+		rowFailed = true
+	} else {
+		s.succeeded++
+	}
+}
+
+// bodyFailed counts a request which was added as succeeded as failed instead,
+// since reading its body failed part way through.
+func (s *runStats) bodyFailed() {
+	s.succeeded--
+	s.failed++
+	s.failures["error"]++
+	rowFailed = true
+}
+
+// rowErrored counts a row which returned an error without any of its requests
+// having been counted as failed, e.g. since its request could not be built.
+func (s *runStats) rowErrored() {
+	s.failed++
+	s.failures["error"]++
+	rowFailed = true
+}
+
+// failureMark is the failure counts at some point during a run.
+type failureMark struct {
+	failed    int
+	failures  map[string]int
+	rowFailed bool
+}
+
+func (s *runStats) mark() failureMark {
+	failures := map[string]int{}
+	for status, n := range s.failures {
+		failures[status] = n
+	}
+	return failureMark{failed: s.failed, failures: failures, rowFailed: rowFailed}
+}
+
+// recovered counts the requests which failed since m as succeeded, since a
+// later request made up for them, e.g. a watch's condition held after a failed
+// poll. Their status classes are kept.
+func (s *runStats) recovered(m failureMark) {
+	s.succeeded += s.failed - m.failed
+	s.failed = m.failed
+	s.failures = m.failures
+	rowFailed = m.rowFailed
+}
+
+// differed counts a diff whose responses differed.
+func (s *runStats) differed() {
+	s.differences++
+	rowFailed = true
+}
+
+// exitCode determines the exit code for a run which returned err.
+func (s *runStats) exitCode(err error) int {
+	if e, ok := err.(*exitError); ok {
+		return e.code
+	}
+	if s.comparing && err == nil {
+		if s.differences > 0 {
+			return exitDifferences
+		}
+		return exitOK
+	}
+
+	switch {
+	case s.failed > 0 && s.succeeded > 0:
+		return exitPartialFailure
+	case s.failed > 0:
+		return exitAllFailed
+	case s.differences > 0:
+		return exitDifferences
+	case err != nil:
+		// Nothing was sent so the command itself must have been invalid.
+		return exitConfigError
+	default:
+		return exitOK
+	}
+}
+
+// percentile returns the nearest-rank percentile p of the recorded latencies.
+func (s *runStats) percentile(p float64) time.Duration {
+	if len(s.latencies) == 0 {
+		return 0
+	}
+
+	sorted := make([]time.Duration, len(s.latencies))
+	copy(sorted, s.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	i := int(float64(len(sorted))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+func (s *runStats) summary() summary {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return summary{
+		Requests:  s.succeeded + s.failed,
+		Succeeded: s.succeeded,
+		Failed:    s.failed,
+		Classes:   s.classes,
+		Failures:  s.failures,
+		LatencyMs: map[string]float64{
+			"p50": ms(s.percentile(0.50)),
+			"p95": ms(s.percentile(0.95)),
+			"p99": ms(s.percentile(0.99)),
+		},
+		WallTimeMs: ms(time.Since(s.start)),
+	}
+}
+
+// printSummary writes the summary to w in the given format; either text or json.
+func (s *runStats) printSummary(w io.Writer, format string) error {
+	sum := s.summary()
+
+	switch format {
+	case "json":
+		return json.NewEncoder(w).Encode(sum)
+	case "text":
+		fmt.Fprintf(w, "Summary: %v requests (%v succeeded, %v failed) in %v\n",
+			sum.Requests, sum.Succeeded, sum.Failed, time.Duration(sum.WallTimeMs*float64(time.Millisecond)).Round(time.Millisecond))
+		fmt.Fprintf(w, "  status: %v\n", formatCounts(sum.Classes))
+		if len(sum.Failures) > 0 {
+			fmt.Fprintf(w, "  failures: %v\n", formatCounts(sum.Failures))
+		}
+		_, err := fmt.Fprintf(w, "  latency: p50=%v p95=%v p99=%v\n",
+			s.percentile(0.50).Round(time.Millisecond), s.percentile(0.95).Round(time.Millisecond), s.percentile(0.99).Round(time.Millisecond))
+		return err
+	default:
+		return fmt.Errorf("invalid summary format %q, expected text or json", format)
+	}
+}
+
+// formatCounts formats the counts as key=value pairs sorted by key.
+func formatCounts(m map[string]int) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%v=%v", k, m[k])
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,163 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestExitCode(t *testing.T) {
+	ok := &http.Response{StatusCode: 200}
+	notFound := &http.Response{StatusCode: 404}
+	someErr := errors.New("some error")
+
+	testCases := []struct {
+		desc   string
+		resps  []*http.Response
+		errors int
+		err    error
+		expect int
+	}{
+		{desc: "nothing sent", expect: exitOK},
+		{desc: "all succeeded", resps: []*http.Response{ok, ok}, expect: exitOK},
+		{desc: "partial failure", resps: []*http.Response{ok, notFound}, expect: exitPartialFailure},
+		{desc: "partial failure with error", resps: []*http.Response{ok, nil}, err: someErr, expect: exitPartialFailure},
+		{desc: "row error after success", resps: []*http.Response{ok}, errors: 1, err: someErr, expect: exitPartialFailure},
+		{desc: "all failed", resps: []*http.Response{notFound, notFound}, expect: exitAllFailed},
+		{desc: "error before sending", err: someErr, expect: exitConfigError},
+		{desc: "input error", err: &exitError{code: exitInputError, err: someErr}, expect: exitInputError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := newRunStats()
+			for _, resp := range tc.resps {
+				var err error
+				if resp == nil {
+					err = someErr
+				}
+				s.add(resp, err, time.Millisecond, resp == nil || resp.StatusCode >= 400)
+			}
+			for i := 0; i < tc.errors; i++ {
+				s.rowErrored()
+			}
+
+			if code := s.exitCode(tc.err); code != tc.expect {
+				t.Errorf("Expected exit code %v, got %v", tc.expect, code)
+			}
+		})
+	}
+}
+
+func TestSummary(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/posts/b", "/posts/c":
+			w.WriteHeader(http.StatusNotFound)
+		case "/posts/d":
+			w.WriteHeader(http.StatusConflict)
+		}
+	}))
+	defer s.Close()
+
+	ResetSettings()
+	viper.Set("scheme", "http")
+	viper.Set("domain", s.Listener.Addr().String())
+	viper.Set("on-error", "silence")
+
+	_, stderr, err := captureOutput(execute, []string{"get", "/posts/$1", "--summary", "json"}, strings.NewReader("a b c d"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var sum summary
+	if err := json.Unmarshal([]byte(stderr), &sum); err != nil {
+		t.Fatalf("Unable to parse summary %q: %v", stderr, err)
+	}
+	if sum.Requests != 4 || sum.Succeeded != 1 || sum.Failed != 3 {
+		t.Errorf("Unexpected counts in summary: %+v", sum)
+	}
+	if expected := map[string]int{"2xx": 1, "4xx": 3}; !reflect.DeepEqual(sum.Classes, expected) {
+		t.Errorf("Expected status classes %v, got %v", expected, sum.Classes)
+	}
+	if expected := map[string]int{"404": 2, "409": 1}; !reflect.DeepEqual(sum.Failures, expected) {
+		t.Errorf("Expected failures %v, got %v", expected, sum.Failures)
+	}
+	if code := stats.exitCode(err); code != exitPartialFailure {
+		t.Errorf("Expected exit code %v, got %v", exitPartialFailure, code)
+	}
+
+	ResetSettings()
+	viper.Set("scheme", "http")
+	viper.Set("domain", s.Listener.Addr().String())
+	_, stderr, _ = captureOutput(execute, []string{"get", "/posts/1", "--summary", "text"}, nil)
+	if !strings.HasPrefix(stderr, "Summary: 1 requests (1 succeeded, 0 failed)") || !strings.Contains(stderr, "status: 2xx=1") {
+		t.Errorf("Unexpected text summary: %q", stderr)
+	}
+
+	// The text summary is printed by default for batches.
+	ResetSettings()
+	viper.Set("scheme", "http")
+	viper.Set("domain", s.Listener.Addr().String())
+	_, stderr, _ = captureOutput(execute, []string{"get", "/posts/$1"}, strings.NewReader("a a"))
+	if !strings.HasPrefix(stderr, "Summary: 2 requests (2 succeeded, 0 failed)") {
+		t.Errorf("Expected text summary by default, got %q", stderr)
+	}
+
+	ResetSettings()
+	viper.Set("scheme", "http")
+	viper.Set("domain", s.Listener.Addr().String())
+	_, stderr, _ = captureOutput(execute, []string{"get", "/posts/$1", "--summary", "none"}, strings.NewReader("a a"))
+	if stderr != "" {
+		t.Errorf("Expected no summary, got %q", stderr)
+	}
+
+	// A row which can not be sent after earlier rows were is a failure of
+	// that row rather than a config error.
+	ResetSettings()
+	viper.Set("scheme", "http")
+	viper.Set("domain", s.Listener.Addr().String())
+	_, stderr, err = captureOutput(execute, []string{"post", "/posts/a", "--file", "${1.f}"}, strings.NewReader(fmt.Sprintf(`{"f":"testdata/testFile.json"} {"f":%q}`, filepath.Join(tmpDir, "missing.json"))))
+	if err == nil {
+		t.Errorf("Expected error for the missing file")
+	}
+	if code := stats.exitCode(err); code != exitPartialFailure {
+		t.Errorf("Expected exit code %v, got %v", exitPartialFailure, code)
+	}
+	if !strings.Contains(stderr, "Summary: 2 requests (1 succeeded, 1 failed)") || !strings.Contains(stderr, "failures: error=1") {
+		t.Errorf("Expected the errored row in the summary, got %q", stderr)
+	}
+}
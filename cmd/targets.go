@@ -0,0 +1,273 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+const (
+	// targetField is added to each response when fanning out to identify the
+	// target it came from.
+	targetField = headerPrefix + "target"
+
+	// bodyField holds responses which are not JSON objects so that they can be
+	// tagged with their target.
+	bodyField = headerPrefix + "body"
+)
+
+// target is one of the hosts every command is sent to when fanning out.
+type target struct {
+	name      string
+	baseURL   string
+	domain    string
+	subdomain string
+}
+
+// parseTargets parses the targets setting. Each entry is a name which is used
+// as the subdomain, NAME=URL which is used as the base-url, or NAME=HOST which
+// is used as the domain.
+func parseTargets(entries []string, baseURL string) ([]target, error) {
+	var targets []target
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		parts := strings.SplitN(entry, "=", 2)
+		t := target{name: strings.TrimSpace(parts[0])}
+
+		switch {
+		case t.name == "" || (len(parts) == 2 && strings.TrimSpace(parts[1]) == ""):
+			return nil, fmt.Errorf("invalid target %q, expected NAME, NAME=URL or NAME=HOST", entry)
+		case len(parts) == 1 && baseURL != "":
+			return nil, fmt.Errorf("invalid target %q, expected NAME=URL or NAME=HOST since base-url is set", entry)
+		case len(parts) == 1:
+			t.subdomain = t.name
+		case strings.Contains(parts[1], "://"):
+			t.baseURL = strings.TrimSpace(parts[1])
+		default:
+			t.domain = strings.TrimSpace(parts[1])
+		}
+
+		if seen[t.name] {
+			return nil, fmt.Errorf("duplicate target %q", t.name)
+		}
+		seen[t.name] = true
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// apply returns the configuration for sending the request to the target.
+func (t target) apply(conf config) config {
+	conf.target = t.name
+	switch {
+	case t.baseURL != "":
+		conf.baseURL = t.baseURL
+	case t.domain != "":
+		conf.baseURL, conf.subdomain, conf.domain = "", "", t.domain
+	default:
+		conf.subdomain = t.subdomain
+	}
+	return conf
+}
+
+// describe is the name of the target along with the host it is sent to.
+func (t target) describe() string {
+	u, err := baseURL(t.apply(baseURLConfig()))
+	if err != nil {
+		return t.name
+	}
+	return fmt.Sprintf("%v (%v)", t.name, u.Host)
+}
+
+// targetResult is the outcome of a request to one target, used to compare
+// the targets.
+type targetResult struct {
+	name   string
+	status int
+	body   interface{}
+}
+
+// sendTargets sends the request to each target in turn. A failure for one
+// target does not stop the request being sent to the others.
+func sendTargets(conf config, path string) error {
+	var results []targetResult
+	var failed []string
+	for _, t := range conf.targets {
+		tconf := t.apply(conf)
+		output := &bytes.Buffer{}
+		if conf.compare {
+			tconf.stdout = io.MultiWriter(conf.stdout, output)
+		}
+
+		status, err := send(tconf, func() (*http.Request, error) {
+			return newRequest(tconf, path)
+		})
+		if err != nil {
+			// Problems with the command or run are the same for every target.
+			if _, ok := err.(*exitError); ok || runCtx.Err() != nil {
+				return err
+			}
+			log.Printf("Request to target %v failed: %v", t.name, err)
+			failed = append(failed, t.name)
+		}
+		results = append(results, targetResult{name: t.name, status: status, body: parseOutput(output.Bytes())})
+	}
+
+	if conf.compare && conf.dryRun == dryRunOff {
+		compareTargets(conf.verb, path, results)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("requests to %d of %d targets failed: %v", len(failed), len(conf.targets), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// tagTarget adds the target field to the response body.
+func tagTarget(body []byte, name string) ([]byte, error) {
+	return tagFields(body, map[string]string{targetField: name})
+}
+
+// tagFields adds the fields to the response body. Bodies which are not JSON
+// objects are put in the body field.
+func tagFields(body []byte, fields map[string]string) ([]byte, error) {
+	obj := map[string]interface{}{}
+	if len(bytes.TrimSpace(body)) > 0 {
+		var v interface{}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil || dec.More() {
+			v = string(body)
+		}
+		if m, ok := v.(map[string]interface{}); ok {
+			obj = m
+		} else {
+			obj[bodyField] = v
+		}
+	}
+	for k, v := range fields {
+		obj[k] = v
+	}
+
+	b := &bytes.Buffer{}
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(obj); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+// parseOutput parses the output written for a response, removing the fields
+// added by jaq so that only the response is compared.
+func parseOutput(output []byte) interface{} {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(output))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return string(output)
+	}
+
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	if body, ok := obj[bodyField]; ok {
+		return body
+	}
+	for k := range obj {
+		if strings.HasPrefix(k, headerPrefix) {
+			delete(obj, k)
+		}
+	}
+	return obj
+}
+
+// compareTargets logs the targets whose responses differ from those of the
+// most targets.
+func compareTargets(verb, path string, results []targetResult) {
+	type group struct {
+		status int
+		body   interface{}
+		names  []string
+	}
+
+	var groups []*group
+	for _, r := range results {
+		var g *group
+		for _, existing := range groups {
+			if existing.status == r.status && reflect.DeepEqual(existing.body, r.body) {
+				g = existing
+				break
+			}
+		}
+		if g == nil {
+			g = &group{status: r.status, body: r.body}
+			groups = append(groups, g)
+		}
+		g.names = append(g.names, r.name)
+	}
+	if len(groups) < 2 {
+		return
+	}
+
+	baseline := groups[0]
+	for _, g := range groups[1:] {
+		if len(g.names) > len(baseline.names) {
+			baseline = g
+		}
+	}
+
+	for _, g := range groups {
+		if g == baseline {
+			continue
+		}
+		names, baselineNames := strings.Join(g.names, ", "), strings.Join(baseline.names, ", ")
+		if g.status != baseline.status {
+			log.Printf("Targets differ for %v %v: %v got %v while %v got %v", verb, path, names, describeTargetStatus(g.status), baselineNames, describeTargetStatus(baseline.status))
+		} else {
+			log.Printf("Targets differ for %v %v: %v got a different body from %v at %v", verb, path, names, baselineNames, changedPaths(diffJSON(baseline.body, g.body, nil)))
+		}
+	}
+}
+
+// changedPaths lists the first few paths of the changes.
+func changedPaths(changes []jsonChange) string {
+	const max = 3
+	var paths []string
+	for i, c := range changes {
+		if i == max {
+			paths = append(paths, fmt.Sprintf("and %d more", len(changes)-max))
+			break
+		}
+		paths = append(paths, c.path)
+	}
+	return strings.Join(paths, ", ")
+}
+
+func describeTargetStatus(status int) string {
+	if status == statusError {
+		return "no response"
+	}
+	return fmt.Sprintf("status %v", status)
+}
@@ -0,0 +1,160 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestTargets(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	// Responds the same to every host except localhost, which fails /fail.
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host, _, _ := net.SplitHostPort(req.Host)
+		switch {
+		case req.URL.Path == "/fail" && host == "localhost":
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"err":"down"}`)
+		case req.URL.Path == "/array":
+			fmt.Fprint(w, `[1,2]`)
+		case strings.HasPrefix(req.URL.Path, "/v2/"):
+			fmt.Fprint(w, `{"id":2}`)
+		default:
+			fmt.Fprint(w, `{"id":1}`)
+		}
+	}))
+	defer s.Close()
+	_, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+	eu1 := "eu1=127.0.0.1:" + port
+	us2 := "us2=localhost:" + port
+
+	testCases := []struct {
+		desc    string
+		args    []string
+		targets []string
+
+		expectStdout string
+		expectStderr []string
+		expectErr    string
+		expectCode   int
+	}{
+		{
+			desc:         "tags each response",
+			args:         []string{"get", "/users", "--targets", eu1 + "," + us2},
+			expectStdout: `{"id":1,"jaq-target":"eu1"}` + "\n" + `{"id":1,"jaq-target":"us2"}` + "\n",
+		}, {
+			desc:         "targets from config",
+			args:         []string{"get", "/array"},
+			targets:      []string{eu1, us2},
+			expectStdout: `{"jaq-body":[1,2],"jaq-target":"eu1"}` + "\n" + `{"jaq-body":[1,2],"jaq-target":"us2"}` + "\n",
+		}, {
+			desc:         "subdomains",
+			args:         []string{"get", "/users", "--targets", "eu1,us2", "--dry-run=curl"},
+			expectStdout: "curl -X GET --max-time 15 http://eu1." + s.Listener.Addr().String() + "/users\ncurl -X GET --max-time 15 http://us2." + s.Listener.Addr().String() + "/users\n",
+		}, {
+			desc:         "failure of one target",
+			args:         []string{"get", "/fail", "--targets", eu1 + "," + us2},
+			expectStdout: `{"id":1,"jaq-target":"eu1"}` + "\n",
+			expectStderr: []string{`{"err":"down","jaq-target":"us2"}`},
+			expectCode:   exitPartialFailure,
+		}, {
+			desc:         "fatal failure of one target",
+			args:         []string{"get", "/fail", "--targets", us2 + "," + eu1, "--on-error", "fatal"},
+			expectStdout: `{"id":1,"jaq-target":"eu1"}` + "\n",
+			expectStderr: []string{"Request to target us2 failed: Unexpected status from response: 500 Internal Server Error"},
+			expectErr:    "requests to 1 of 2 targets failed: us2",
+			expectCode:   exitPartialFailure,
+		}, {
+			desc:         "compare status",
+			args:         []string{"get", "/fail", "--targets", eu1 + ",ap1=127.0.0.1:" + port + "," + us2, "--compare"},
+			expectStdout: `{"id":1,"jaq-target":"eu1"}` + "\n" + `{"id":1,"jaq-target":"ap1"}` + "\n",
+			expectStderr: []string{"Targets differ for GET /fail: us2 got status 500 while eu1, ap1 got status 200"},
+			expectCode:   exitPartialFailure,
+		}, {
+			desc:         "compare body",
+			args:         []string{"get", "/users", "--targets", eu1 + "," + us2 + ",ap1=http://127.0.0.1:" + port + "/v2", "--compare", "--print-headers"},
+			expectStderr: []string{"Targets differ for GET /users: ap1 got a different body from eu1, us2 at id\n"},
+		}, {
+			desc:         "compare without response",
+			args:         []string{"get", "/users", "--targets", eu1 + ",ap1=127.0.0.1:1", "--compare"},
+			expectStdout: `{"id":1,"jaq-target":"eu1"}` + "\n",
+			expectStderr: []string{"Targets differ for GET /users: ap1 got no response while eu1 got status 200"},
+			expectErr:    "requests to 1 of 2 targets failed: ap1",
+			expectCode:   exitPartialFailure,
+		}, {
+			desc:      "invalid target",
+			args:      []string{"get", "/users", "--targets", "eu1="},
+			expectErr: `invalid target "eu1=", expected NAME, NAME=URL or NAME=HOST`,
+		}, {
+			desc:      "name with base url",
+			args:      []string{"get", "/users", "--targets", "eu1", "--base-url", "http://" + s.Listener.Addr().String()},
+			expectErr: `invalid target "eu1", expected NAME=URL or NAME=HOST since base-url is set`,
+		}, {
+			desc:      "duplicate target",
+			args:      []string{"get", "/users", "--targets", eu1 + ",eu1"},
+			expectErr: `duplicate target "eu1"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ResetSettings()
+			viper.Set("scheme", "http")
+			viper.Set("domain", s.Listener.Addr().String())
+			if tc.targets != nil {
+				viper.Set("targets", tc.targets)
+			}
+
+			stdout, stderr, err := captureOutput(execute, tc.args, nil)
+			switch {
+			case err == nil && tc.expectErr != "":
+				t.Errorf("Expected error %q but got none", tc.expectErr)
+			case err != nil && err.Error() != tc.expectErr:
+				t.Errorf("Expected error %q, got %q", tc.expectErr, err)
+			}
+			if tc.expectErr == "" || tc.expectCode != 0 {
+				if code := stats.exitCode(err); code != tc.expectCode {
+					t.Errorf("Expected exit code %v, got %v", tc.expectCode, code)
+				}
+			}
+
+			if tc.expectStdout != "" && stdout != tc.expectStdout {
+				t.Errorf("Expected stdout %q, got %q", tc.expectStdout, stdout)
+			}
+			for _, s := range tc.expectStderr {
+				if !strings.Contains(stderr, s) {
+					t.Errorf("Expected stderr to contain %q, got %q", s, stderr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/Ericsson/jaq/schema"
+)
+
+// loadedSchemas caches schemas by path so that they are not reloaded for every
+// row of input.
+var loadedSchemas = map[string]*schema.Schema{}
+
+// schemaMapping is an entry of the schemas config which applies the request
+// and response schemas to requests matching the method and path. The path may
+// contain wildcards as supported by path.Match (e.g. /posts/*).
+type schemaMapping struct {
+	Method   string `mapstructure:"method"`
+	Path     string `mapstructure:"path"`
+	Request  string `mapstructure:"request"`
+	Response string `mapstructure:"response"`
+}
+
+// resolveSchemas sets the request and response schemas for the request from
+// the flags or, if not given, the first matching schema mapping.
+func resolveSchemas(conf *config, method, urlPath string) error {
+	requestPath, responsePath := conf.requestSchemaPath, conf.responseSchemaPath
+	urlPath = strings.SplitN(urlPath, "?", 2)[0]
+
+	for _, m := range conf.schemaMappings {
+		if m.Method != "" && !strings.EqualFold(m.Method, method) {
+			continue
+		}
+		if ok, err := path.Match(m.Path, urlPath); err != nil {
+			return fmt.Errorf("invalid path %q in schemas config: %v", m.Path, err)
+		} else if !ok {
+			continue
+		}
+
+		if requestPath == "" {
+			requestPath = m.Request
+		}
+		if responsePath == "" {
+			responsePath = m.Response
+		}
+		break
+	}
+
+	var err error
+	if conf.requestSchema, err = loadSchema(requestPath); err != nil {
+		return err
+	}
+	conf.responseSchema, err = loadSchema(responsePath)
+	return err
+}
+
+func loadSchema(path string) (*schema.Schema, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	if s, ok := loadedSchemas[path]; ok {
+		return s, nil
+	}
+
+	s, err := schema.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	loadedSchemas[path] = s
+	return s, nil
+}
+
+// validateRequestBody checks the body which will be sent, from either the file
+// or body, against the request schema. Requests without a body are not checked.
+// A body which does not match is an input error.
+func validateRequestBody(conf config) error {
+	if conf.requestSchema == nil {
+		return nil
+	}
+
+	var body []byte
+	switch {
+	case len(conf.filepath) > 0:
+		var err error
+		if body, err = ioutil.ReadFile(conf.filepath); err != nil {
+			return err
+		}
+	case len(conf.body) > 0:
+		body = []byte(conf.body)
+	default:
+		return nil
+	}
+
+	errs, err := conf.requestSchema.ValidateJSON(body)
+	if err != nil {
+		return &exitError{code: exitInputError, err: fmt.Errorf("request body is not valid JSON: %v", err)}
+	}
+	if len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, e := range errs {
+			msgs[i] = e.Error()
+		}
+		err := fmt.Errorf("request body does not match schema; not sending request:\n  %v", strings.Join(msgs, "\n  "))
+		return &exitError{code: exitInputError, err: err}
+	}
+
+	return nil
+}
+
+// validateResponse checks the body of successful responses against the
+// response schema and returns a description of each error. The body is
+// replaced so that it can still be output afterwards.
+func validateResponse(conf config, resp *http.Response) []string {
+	if conf.responseSchema == nil || resp == nil || resp.StatusCode >= 400 {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	errs, err := conf.responseSchema.ValidateJSON(body)
+	if err != nil {
+		return []string{fmt.Sprintf("response is not valid JSON: %v", err)}
+	}
+
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = "schema: " + e.Error()
+	}
+	return msgs
+}
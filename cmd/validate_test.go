@@ -0,0 +1,136 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestSchemaValidation(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	sent := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sent++
+		switch req.URL.Path {
+		case "/jobs/a":
+			w.Write([]byte(`{"id":1,"status":"done"}`))
+		default:
+			w.Write([]byte(`{"id":"2","status":"failed"}`))
+		}
+	}))
+	defer s.Close()
+
+	testCases := []struct {
+		desc     string
+		args     []string
+		mappings []map[string]string
+
+		expectSent     int
+		expectStdout   string
+		expectStderr   []string
+		expectErr      string
+		expectExitCode int
+	}{
+		{
+			desc:           "valid request and response",
+			args:           []string{"post", "/jobs/a", "--body", `{"id":1,"status":"running"}`, "--request-schema", "testdata/postSchema.json", "--response-schema", "testdata/postSchema.json"},
+			expectSent:     1,
+			expectStdout:   `{"id":1,"status":"done"}` + "\n",
+			expectExitCode: exitOK,
+		}, {
+			desc:           "invalid request is not sent",
+			args:           []string{"post", "/jobs/a", "--body", `{"id":"1"}`, "--request-schema", "testdata/postSchema.json"},
+			expectErr:      "request body does not match schema; not sending request:\n  /: missing required property \"status\"\n  /id: expected type integer but got string",
+			expectExitCode: exitInputError,
+		}, {
+			desc:           "invalid response uses on-error",
+			args:           []string{"get", "/jobs/b", "--response-schema", "testdata/postSchema.json", "--on-error", "fatal"},
+			expectSent:     1,
+			expectStderr:   []string{"schema: /id: expected type integer but got string", `schema: /status: value "failed" is not one of ["done","running"]`},
+			expectErr:      "Response from GET http://" + s.Listener.Addr().String() + "/jobs/b does not match schema",
+			expectExitCode: exitAllFailed,
+		}, {
+			desc:           "invalid response with on-status rule",
+			args:           []string{"get", "/jobs/b", "--response-schema", "testdata/postSchema.json", "--on-status", "invalid=continue"},
+			expectSent:     1,
+			expectStdout:   `{"id":"2","status":"failed"}` + "\n",
+			expectStderr:   []string{"schema: /id: expected type integer but got string"},
+			expectExitCode: exitAllFailed,
+		}, {
+			desc:           "schema mapping from config",
+			args:           []string{"get", "/jobs/b", "--on-error", "silence"},
+			mappings:       []map[string]string{{"method": "post", "path": "/jobs/*", "request": "testdata/postSchema.json"}, {"path": "/jobs/*", "response": "testdata/postSchema.json"}},
+			expectSent:     1,
+			expectExitCode: exitAllFailed,
+		}, {
+			desc:           "schema mapping does not match",
+			args:           []string{"get", "/jobs/b"},
+			mappings:       []map[string]string{{"path": "/posts/*", "response": "testdata/postSchema.json"}},
+			expectSent:     1,
+			expectStdout:   `{"id":"2","status":"failed"}` + "\n",
+			expectExitCode: exitOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ResetSettings()
+			viper.Set("scheme", "http")
+			viper.Set("domain", s.Listener.Addr().String())
+			if tc.mappings != nil {
+				viper.Set("schemas", tc.mappings)
+			}
+			sent = 0
+
+			stdout, stderr, err := captureOutput(execute, tc.args, nil)
+			switch {
+			case err == nil && tc.expectErr != "":
+				t.Errorf("Expected error %q but got none", tc.expectErr)
+			case err != nil && err.Error() != tc.expectErr:
+				t.Errorf("Expected error %q, got %q", tc.expectErr, err)
+			}
+
+			if sent != tc.expectSent {
+				t.Errorf("Expected %v requests to be sent, got %v", tc.expectSent, sent)
+			}
+			if stdout != tc.expectStdout {
+				t.Errorf("Expected stdout %q, got %q", tc.expectStdout, stdout)
+			}
+			for _, e := range tc.expectStderr {
+				if !strings.Contains(stderr, e) {
+					t.Errorf("Expected stderr to contain %q, got %q", e, stderr)
+				}
+			}
+			if code := stats.exitCode(err); code != tc.expectExitCode {
+				t.Errorf("Expected exit code %v, got %v", tc.expectExitCode, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jeffail/gabs"
+	"github.com/spf13/viper"
+)
+
+// resourceLocationField is the field of a completed operation which may hold
+// the location of the resulting resource when there is no Location header.
+const resourceLocationField = "resourceLocation"
+
+// waitConfig is how 202 Accepted responses are followed until the operation
+// they started completes.
+type waitConfig struct {
+	enabled     bool
+	timeout     time.Duration
+	interval    time.Duration
+	maxInterval time.Duration
+	field       string
+	success     []string
+	failure     []string
+	fetch       bool
+}
+
+func newWaitConfig() (waitConfig, error) {
+	w := waitConfig{
+		enabled:     viper.GetBool("wait"),
+		timeout:     viper.GetDuration("wait-timeout"),
+		interval:    viper.GetDuration("wait-interval"),
+		maxInterval: viper.GetDuration("wait-max-interval"),
+		field:       viper.GetString("wait-status-field"),
+		success:     viper.GetStringSlice("wait-success"),
+		failure:     viper.GetStringSlice("wait-failure"),
+		fetch:       viper.GetBool("wait-fetch"),
+	}
+	if w.enabled && (w.timeout <= 0 || w.interval <= 0 || w.maxInterval < w.interval) {
+		return w, fmt.Errorf("invalid wait-timeout %v, wait-interval %v and wait-max-interval %v; expected durations > 0 with wait-max-interval >= wait-interval", w.timeout, w.interval, w.maxInterval)
+	}
+	return w, nil
+}
+
+// waitForCompletion polls the location given by a 202 Accepted response until
+// the operation reaches a terminal state, returning the final response. The
+// interval between polls doubles up to the maximum unless the server gives a
+// Retry-After. Responses without a location to poll are returned as they are.
+func waitForCompletion(conf config, req *http.Request, resp *http.Response) (*http.Response, error) {
+	location := operationLocation(req.URL, resp)
+	if location == nil {
+		log.Printf("No Operation-Location or Location header in the %v response to %v %v; not waiting", resp.Status, req.Method, req.URL)
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	deadline := time.Now().Add(conf.wait.timeout)
+	interval := conf.wait.interval
+	wait := retryAfter(resp, interval)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, fmt.Errorf("operation at %v did not complete within %v", location, conf.wait.timeout)
+		}
+		if wait > remaining {
+			wait = remaining
+		}
+		select {
+		case <-time.After(wait):
+		case <-runCtx.Done():
+			return nil, runCtx.Err()
+		}
+
+		poll, err := response(conf, followRequest(req, location))
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case poll.StatusCode == http.StatusAccepted:
+			if next := operationLocation(location, poll); next != nil {
+				location = next
+			}
+			poll.Body.Close()
+		case poll.StatusCode >= 300:
+			// Handled like any other failed response.
+			return poll, nil
+		default:
+			done, err := operationDone(conf, req, location, poll)
+			if err != nil {
+				poll.Body.Close()
+				return nil, err
+			}
+			if done {
+				return poll, nil
+			}
+		}
+
+		interval *= 2
+		if interval > conf.wait.maxInterval {
+			interval = conf.wait.maxInterval
+		}
+		wait = retryAfter(poll, interval)
+	}
+}
+
+// operationDone checks the status field of a successful poll. A body without
+// the field is taken to be the completed resource. On success, the resource is
+// fetched if required and replaces the body of the response.
+func operationDone(conf config, req *http.Request, location *url.URL, poll *http.Response) (bool, error) {
+	b, err := ioutil.ReadAll(poll.Body)
+	poll.Body.Close()
+	if err != nil {
+		return false, err
+	}
+	poll.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	parsed, err := gabs.ParseJSON(b)
+	if err != nil {
+		return true, nil
+	}
+	state, ok := parsed.Path(conf.wait.field).Data().(string)
+	if !ok {
+		return true, nil
+	}
+
+	switch {
+	case matchesState(state, conf.wait.failure):
+		copyNewline(os.Stderr, bytes.NewReader(b), nil)
+		return true, fmt.Errorf("operation at %v failed with %v %q", location, conf.wait.field, state)
+	case !matchesState(state, conf.wait.success):
+		return false, nil
+	case !conf.wait.fetch:
+		return true, nil
+	}
+
+	resource := operationLocation(location, poll)
+	if s, ok := parsed.Path(resourceLocationField).Data().(string); resource == nil && ok {
+		resource, _ = location.Parse(s)
+	}
+	if resource == nil {
+		log.Printf("No Location header or %v field in the completed operation at %v; not fetching the resource", resourceLocationField, location)
+		return true, nil
+	}
+
+	final, err := response(conf, followRequest(req, resource))
+	if err != nil {
+		return true, err
+	}
+	*poll = *final
+	return true, nil
+}
+
+func matchesState(state string, states []string) bool {
+	for _, s := range states {
+		if strings.EqualFold(strings.TrimSpace(s), state) {
+			return true
+		}
+	}
+	return false
+}
+
+// operationLocation is the location to poll given by the response, resolved
+// against the URL of the request.
+func operationLocation(base *url.URL, resp *http.Response) *url.URL {
+	for _, h := range []string{"Operation-Location", "Location"} {
+		if v := resp.Header.Get(h); v != "" {
+			if u, err := base.Parse(v); err == nil {
+				return u
+			}
+		}
+	}
+	return nil
+}
+
+// followRequest is a GET of the location with the headers of the original
+// request. As with redirects, credentials are only sent to the same host.
+func followRequest(orig *http.Request, location *url.URL) *http.Request {
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    location,
+		Host:   location.Host,
+		Header: http.Header{},
+	}
+	for k, v := range orig.Header {
+		switch {
+		case strings.HasPrefix(k, "Content-"):
+		case k == "Authorization" && location.Host != orig.URL.Host:
+		default:
+			req.Header[k] = v
+		}
+	}
+	return req
+}
+
+// retryAfter is the wait given by the Retry-After header of the response, if
+// any, or d.
+func retryAfter(resp *http.Response, d time.Duration) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if wait := time.Until(t); wait > 0 {
+			return wait
+		}
+		return 0
+	}
+	return d
+}
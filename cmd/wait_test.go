@@ -0,0 +1,171 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestWait(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	var polls int64
+	accepted := func(w http.ResponseWriter, header, location string) {
+		if header != "" {
+			w.Header().Set(header, location)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, `{"status":"accepted"}`)
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/jobs":
+			accepted(w, "Operation-Location", "/operations/1")
+		case "/failing":
+			accepted(w, "Location", "/operations/2")
+		case "/slow":
+			accepted(w, "Location", "/operations/3")
+		case "/redirected":
+			accepted(w, "Location", "/operations/4")
+		case "/custom":
+			accepted(w, "Location", "/operations/5")
+		case "/unknown":
+			accepted(w, "", "")
+		case "/operations/1":
+			if req.Header.Get("Authorization") != "Bearer secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if atomic.AddInt64(&polls, 1) == 1 {
+				w.Header().Set("Retry-After", "0")
+				fmt.Fprint(w, `{"status":"Running"}`)
+				return
+			}
+			fmt.Fprint(w, `{"status":"Succeeded","resourceLocation":"/jobs/1"}`)
+		case "/operations/2":
+			fmt.Fprint(w, `{"status":"failed","error":"boom"}`)
+		case "/operations/3":
+			atomic.AddInt64(&polls, 1)
+			fmt.Fprint(w, `{"status":"running"}`)
+		case "/operations/4":
+			http.Redirect(w, req, "/jobs/1", http.StatusSeeOther)
+		case "/operations/5":
+			fmt.Fprint(w, `{"phase":{"name":"Ready"},"id":5}`)
+		case "/jobs/1":
+			fmt.Fprint(w, `{"id":1,"name":"job"}`)
+		}
+	}))
+	defer s.Close()
+	addr := s.Listener.Addr().String()
+
+	testCases := []struct {
+		desc string
+		args []string
+
+		expectStdout string
+		expectStderr string
+		expectErr    string
+		expectCode   int
+	}{
+		{
+			desc:         "without wait",
+			args:         []string{"post", "/jobs"},
+			expectStdout: `{"status":"accepted"}` + "\n",
+		}, {
+			desc:         "wait",
+			args:         []string{"post", "/jobs", "--wait", "--wait-interval", "1ms"},
+			expectStdout: `{"status":"Succeeded","resourceLocation":"/jobs/1"}` + "\n",
+		}, {
+			desc:         "wait and fetch",
+			args:         []string{"post", "/jobs", "--wait", "--wait-interval", "1ms", "--wait-fetch"},
+			expectStdout: `{"id":1,"name":"job"}` + "\n",
+		}, {
+			desc:         "failed operation",
+			args:         []string{"post", "/failing", "--wait", "--wait-interval", "1ms"},
+			expectStderr: `{"status":"failed","error":"boom"}`,
+			expectErr:    fmt.Sprintf(`operation at http://%v/operations/2 failed with status "failed"`, addr),
+			expectCode:   exitAllFailed,
+		}, {
+			desc:       "timeout",
+			args:       []string{"post", "/slow", "--wait", "--wait-interval", "1ms", "--wait-max-interval", "5ms", "--wait-timeout", "30ms"},
+			expectErr:  fmt.Sprintf("operation at http://%v/operations/3 did not complete within 30ms", addr),
+			expectCode: exitAllFailed,
+		}, {
+			desc:         "see other",
+			args:         []string{"post", "/redirected", "--wait", "--wait-interval", "1ms"},
+			expectStdout: `{"id":1,"name":"job"}` + "\n",
+		}, {
+			desc:         "custom states",
+			args:         []string{"post", "/custom", "--wait", "--wait-interval", "1ms", "--wait-status-field", "phase.name", "--wait-success", "ready"},
+			expectStdout: `{"phase":{"name":"Ready"},"id":5}` + "\n",
+		}, {
+			desc:         "no location",
+			args:         []string{"post", "/unknown", "--wait"},
+			expectStdout: `{"status":"accepted"}` + "\n",
+			expectStderr: "No Operation-Location or Location header in the 202 Accepted response",
+		}, {
+			desc:      "invalid interval",
+			args:      []string{"post", "/jobs", "--wait", "--wait-interval", "0s"},
+			expectErr: "invalid wait-timeout 10m0s, wait-interval 0s and wait-max-interval 30s; expected durations > 0 with wait-max-interval >= wait-interval",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ResetSettings()
+			viper.Set("scheme", "http")
+			viper.Set("domain", addr)
+			viper.Set("auth", "token")
+			viper.Set("token", "secret")
+			atomic.StoreInt64(&polls, 0)
+
+			stdout, stderr, err := captureOutput(execute, tc.args, nil)
+			switch {
+			case err == nil && tc.expectErr != "":
+				t.Errorf("Expected error %q but got none", tc.expectErr)
+			case err != nil && err.Error() != tc.expectErr:
+				t.Errorf("Expected error %q, got %q", tc.expectErr, err)
+			}
+			if tc.expectCode != 0 {
+				if code := stats.exitCode(err); code != tc.expectCode {
+					t.Errorf("Expected exit code %v, got %v", tc.expectCode, code)
+				}
+			}
+
+			if stdout != tc.expectStdout {
+				t.Errorf("Expected stdout %q, got %q", tc.expectStdout, stdout)
+			}
+			if !strings.Contains(stderr, tc.expectStderr) {
+				t.Errorf("Expected stderr to contain %q, got %q", tc.expectStderr, stderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Jeffail/gabs"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	ResetSettingsWatch()
+}
+
+// ResetSettingsWatch adds the watch command.
+func ResetSettingsWatch() {
+	RootCmd.AddCommand(watchCommand())
+}
+
+func watchCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "watch",
+		Short: "Send a request periodically and output the response when it changes.",
+		Long: `Sends the request every --interval and writes the response whenever it
+differs from the previous one, starting with the first. With --as-patch, changes
+after the first response are written as a JSON patch (RFC 6902) instead.
+
+Watching stops once every --until condition holds for a response, or when the
+--timeout elapses. If there are --until conditions which never held, jaq exits
+with code 5. Failed polls are written to stderr; once the --until conditions
+hold they no longer count as failures.`,
+		Example: `  jaq watch get /jobs/123 --interval 5s --until 'status=="done"' --timeout 10m`,
+	}
+
+	fs := c.PersistentFlags()
+	fs.DurationP("interval", "", 5*time.Second, "Time between requests")
+	fs.BoolP("as-patch", "", false, "Write changes as a JSON patch rather than the full response")
+	fs.StringArrayP("until", "", []string{}, "Stop once a response field has a value (e.g. 'status==\"done\"'); may be repeated")
+	fs.DurationP("timeout", "", 0, "Stop watching after this long; 0 for no limit")
+
+	for _, verbCmd := range httpCommands() {
+		c.AddCommand(watchVerbCommand(strings.ToUpper(verbCmd.Name())))
+	}
+	return c
+}
+
+func watchVerbCommand(verb string) *cobra.Command {
+	return &cobra.Command{
+		Use:   strings.ToLower(verb),
+		Short: fmt.Sprintf("Watch the response to a %v request.", verb),
+		Args:  cobra.ExactArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conf, err := newConfig(cmd)
+			if err != nil {
+				return err
+			}
+			// Responses are compared with the previous one from the same host
+			// so each has to be read in full.
+			conf.targets = nil
+			conf.stream = false
+
+			opts, err := newWatchOptions(cmd)
+			if err != nil {
+				return err
+			}
+			return runWatch(conf, verb, args[0], opts)
+		},
+	}
+}
+
+// watchOptions are the settings of a watch.
+type watchOptions struct {
+	interval time.Duration
+	timeout  time.Duration
+	asPatch  bool
+	until    []fieldExpectation
+}
+
+func newWatchOptions(cmd *cobra.Command) (watchOptions, error) {
+	var opts watchOptions
+	var err error
+	if opts.interval, err = cmd.Flags().GetDuration("interval"); err != nil {
+		return opts, err
+	}
+	if opts.interval <= 0 {
+		return opts, fmt.Errorf("invalid interval %v, expected a duration > 0", opts.interval)
+	}
+	if opts.timeout, err = cmd.Flags().GetDuration("timeout"); err != nil {
+		return opts, err
+	}
+	if opts.asPatch, err = cmd.Flags().GetBool("as-patch"); err != nil {
+		return opts, err
+	}
+
+	until, err := cmd.Flags().GetStringArray("until")
+	if err != nil {
+		return opts, err
+	}
+	for _, u := range until {
+		f, err := parseFieldExpectation(u)
+		if err != nil {
+			return opts, err
+		}
+		opts.until = append(opts.until, f)
+	}
+	return opts, nil
+}
+
+// runWatch sends the request until the conditions hold or the timeout elapses,
+// writing each changed response.
+func runWatch(conf config, verb, path string, opts watchOptions) error {
+	if err := expandSessionConfig(&conf, &path); err != nil {
+		return err
+	}
+	if err := resolveSchemas(&conf, verb, path); err != nil {
+		return err
+	}
+	if err := validateRequestBody(conf); err != nil {
+		return err
+	}
+
+	var timeout <-chan time.Time
+	if opts.timeout > 0 {
+		timer := time.NewTimer(opts.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	// Polls which fail before the conditions hold are not counted as failures
+	// once they do.
+	mark := stats.mark()
+
+	var last interface{}
+	for first := true; ; first = false {
+		output := &bytes.Buffer{}
+		wconf := conf
+		// Dry-run output is written as usual rather than compared.
+		if conf.dryRun == dryRunOff {
+			wconf.stdout = output
+		}
+
+		if _, err := send(wconf, func() (*http.Request, error) {
+			return newRequest(wconf, path)
+		}); err != nil {
+			return err
+		}
+
+		// Nothing was sent so there is nothing to watch.
+		if conf.dryRun != dryRunOff {
+			return nil
+		}
+
+		// Failed responses are written to stderr by send so have no output.
+		if output.Len() > 0 {
+			current := parseOutput(output.Bytes())
+			if first || !reflect.DeepEqual(current, last) {
+				if err := writeChange(conf, output.Bytes(), last, current, opts.asPatch && !first); err != nil {
+					return err
+				}
+			}
+			last = current
+
+			if len(opts.until) > 0 && untilHolds(opts.until, output.Bytes()) {
+				stats.recovered(mark)
+				return nil
+			}
+		}
+
+		select {
+		case <-time.After(opts.interval):
+		case <-timeout:
+			if len(opts.until) > 0 {
+				return &exitError{code: exitDeadline, err: fmt.Errorf("timed out after %v waiting for %v", opts.timeout, describeUntil(opts.until))}
+			}
+			return nil
+		case <-runCtx.Done():
+			return runCtx.Err()
+		}
+	}
+}
+
+// writeChange writes the response, or the patch from the previous response.
+func writeChange(conf config, output []byte, last, current interface{}, asPatch bool) error {
+	if !asPatch {
+		_, err := conf.stdout.Write(output)
+		return err
+	}
+
+	_, err := fmt.Fprintln(conf.stdout, formatValue(jsonPatch(diffJSON(last, current, nil))))
+	return err
+}
+
+// untilHolds reports whether every condition holds for the response.
+func untilHolds(until []fieldExpectation, output []byte) bool {
+	parsed, err := gabs.ParseJSON(output)
+	if err != nil {
+		return false
+	}
+	for _, f := range until {
+		if f.check(parsed) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func describeUntil(until []fieldExpectation) string {
+	conditions := make([]string, len(until))
+	for i, f := range until {
+		conditions[i] = f.path + f.op + f.value
+	}
+	return strings.Join(conditions, " and ")
+}
+
+// jsonPatch converts the changes from diffJSON to a JSON patch.
+func jsonPatch(changes []jsonChange) []map[string]interface{} {
+	ops := []map[string]interface{}{}
+	for i := 0; i < len(changes); i++ {
+		c := changes[i]
+		switch c.op {
+		case '+':
+			ops = append(ops, map[string]interface{}{"op": "add", "path": jsonPointer(c.segments), "value": c.right})
+		case '~':
+			ops = append(ops, map[string]interface{}{"op": "replace", "path": jsonPointer(c.segments), "value": c.right})
+		case '-':
+			// Removals from the end of an array are done last to first so that
+			// the indexes of the remaining ones stay valid.
+			j := i
+			for j+1 < len(changes) && changes[j+1].op == '-' && sameParent(changes[j+1].segments, c.segments) {
+				j++
+			}
+			for k := j; k >= i; k-- {
+				ops = append(ops, map[string]interface{}{"op": "remove", "path": jsonPointer(changes[k].segments)})
+			}
+			i = j
+		}
+	}
+	return ops
+}
+
+func sameParent(a, b []string) bool {
+	return len(a) == len(b) && len(a) > 0 && reflect.DeepEqual(a[:len(a)-1], b[:len(b)-1])
+}
+
+// jsonPointer formats the path as a JSON pointer (RFC 6901).
+func jsonPointer(segments []string) string {
+	escape := strings.NewReplacer("~", "~0", "/", "~1")
+	b := &bytes.Buffer{}
+	for _, s := range segments {
+		b.WriteString("/")
+		b.WriteString(escape.Replace(s))
+	}
+	return b.String()
+}
@@ -0,0 +1,163 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestWatch(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	var requests int64
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt64(&requests, 1)
+		switch {
+		case req.URL.Path == "/static":
+			fmt.Fprint(w, `{"status":"running"}`)
+		case req.URL.Path == "/flaky" && n == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case req.URL.Path == "/flaky" && n == 2:
+			fmt.Fprint(w, `{"status":"running"}`)
+		case req.URL.Path == "/flaky":
+			fmt.Fprint(w, `{"status":"done"}`)
+		case n <= 2:
+			fmt.Fprint(w, `{"status":"running","progress":0}`)
+		case n == 3:
+			fmt.Fprint(w, `{"status":"running","progress":50}`)
+		default:
+			fmt.Fprint(w, `{"status":"done","progress":100}`)
+		}
+	}))
+	defer s.Close()
+
+	testCases := []struct {
+		desc string
+		args []string
+
+		expectRequests int64
+		expectStdout   string
+		expectErr      string
+		expectCode     int
+	}{
+		{
+			desc:           "until",
+			args:           []string{"watch", "get", "/jobs/123", "--interval", "10ms", "--until", `status=="done"`},
+			expectRequests: 4,
+			expectStdout: `{"status":"running","progress":0}` + "\n" +
+				`{"status":"running","progress":50}` + "\n" +
+				`{"status":"done","progress":100}` + "\n",
+		}, {
+			desc:           "as patch",
+			args:           []string{"watch", "get", "/jobs/123", "--interval", "10ms", "--until", `status=="done"`, "--until", "progress==100", "--as-patch"},
+			expectRequests: 4,
+			expectStdout: `{"status":"running","progress":0}` + "\n" +
+				`[{"op":"replace","path":"/progress","value":50}]` + "\n" +
+				`[{"op":"replace","path":"/progress","value":100},{"op":"replace","path":"/status","value":"done"}]` + "\n",
+		}, {
+			desc:           "failed polls before until holds",
+			args:           []string{"watch", "get", "/flaky", "--interval", "10ms", "--until", `status=="done"`},
+			expectRequests: 3,
+			expectStdout:   `{"status":"running"}` + "\n" + `{"status":"done"}` + "\n",
+		}, {
+			desc:         "failed polls while timing out",
+			args:         []string{"watch", "get", "/flaky", "--interval", "10ms", "--timeout", "100ms", "--until", `status=="gone"`},
+			expectStdout: `{"status":"running"}` + "\n" + `{"status":"done"}` + "\n",
+			expectErr:    `timed out after 100ms waiting for status=="gone"`,
+			expectCode:   exitDeadline,
+		}, {
+			desc:         "timeout",
+			args:         []string{"watch", "get", "/static", "--interval", "10ms", "--timeout", "100ms"},
+			expectStdout: `{"status":"running"}` + "\n",
+		}, {
+			desc:         "timeout waiting",
+			args:         []string{"watch", "get", "/static", "--interval", "10ms", "--timeout", "100ms", "--until", `status=="done"`},
+			expectStdout: `{"status":"running"}` + "\n",
+			expectErr:    `timed out after 100ms waiting for status=="done"`,
+			expectCode:   exitDeadline,
+		}, {
+			desc:           "dry run",
+			args:           []string{"watch", "get", "/static", "--interval", "10ms", "--dry-run=jaq"},
+			expectRequests: 0,
+			expectStdout:   "jaq watch get /static\n",
+		}, {
+			desc:      "invalid interval",
+			args:      []string{"watch", "get", "/static", "--interval", "0s"},
+			expectErr: "invalid interval 0s, expected a duration > 0",
+		}, {
+			desc:      "invalid until",
+			args:      []string{"watch", "get", "/static", "--until", "done"},
+			expectErr: `invalid expectation "done", expected PATH==VALUE or PATH!=VALUE`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ResetSettings()
+			viper.Set("scheme", "http")
+			viper.Set("domain", s.Listener.Addr().String())
+			atomic.StoreInt64(&requests, 0)
+
+			stdout, _, err := captureOutput(execute, tc.args, nil)
+			switch {
+			case err == nil && tc.expectErr != "":
+				t.Errorf("Expected error %q but got none", tc.expectErr)
+			case err != nil && err.Error() != tc.expectErr:
+				t.Errorf("Expected error %q, got %q", tc.expectErr, err)
+			}
+			if code := stats.exitCode(err); err == nil && code != tc.expectCode {
+				t.Errorf("Expected exit code %v, got %v", tc.expectCode, code)
+			}
+			if err != nil && tc.expectCode != 0 {
+				if code := stats.exitCode(err); code != tc.expectCode {
+					t.Errorf("Expected exit code %v, got %v", tc.expectCode, code)
+				}
+			}
+
+			if stdout != tc.expectStdout {
+				t.Errorf("Expected stdout %q, got %q", tc.expectStdout, stdout)
+			}
+			if got := atomic.LoadInt64(&requests); tc.expectRequests > 0 && got != tc.expectRequests {
+				t.Errorf("Expected %v requests, got %v", tc.expectRequests, got)
+			}
+		})
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	left := map[string]interface{}{"a/b": 1.0, "tags": []interface{}{"x", "y", "z"}, "old": true}
+	right := map[string]interface{}{"a/b": 2.0, "tags": []interface{}{"x"}, "new~": nil}
+
+	got := formatValue(jsonPatch(diffJSON(left, right, nil)))
+	expect := `[{"op":"replace","path":"/a~1b","value":2},{"op":"add","path":"/new~0","value":null},{"op":"remove","path":"/old"},{"op":"remove","path":"/tags/2"},{"op":"remove","path":"/tags/1"}]`
+	if got != expect {
+		t.Errorf("Expected patch\n%v\ngot\n%v", expect, got)
+	}
+}
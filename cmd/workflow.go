@@ -0,0 +1,544 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Jeffail/gabs"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// stepNamePattern restricts step names so that references to them can
+	// be split on dots.
+	stepNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+	// stepRefPattern matches the references to steps, e.g.
+	// ${steps.login.body.token}.
+	stepRefPattern = regexp.MustCompile(`\$\{steps\.([^.}]+)`)
+
+	// singleRefPattern matches values which are a single reference so that
+	// its value can be used as-is rather than as a string.
+	singleRefPattern = regexp.MustCompile(`^\$\{([^}]+)\}$`)
+
+	// refPattern matches the references within a value. Only the ${...}
+	// form is used so that a bare $ in a body, e.g. {"$set":...}, is kept.
+	refPattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+)
+
+// workflow is a series of requests run in one process, where each step may
+// use the responses of the steps before it.
+type workflow struct {
+	Steps   []workflowStep    `yaml:"steps"`
+	Outputs map[string]string `yaml:"outputs"`
+}
+
+// workflowStep is a single request of a workflow. Foreach runs the request for
+// each element of a list and when skips it unless the condition holds. Steps
+// after one which failed are only run if continue is set.
+type workflowStep struct {
+	Name     string   `yaml:"name"`
+	Verb     string   `yaml:"verb"`
+	Path     string   `yaml:"path"`
+	Query    string   `yaml:"query"`
+	Headers  []string `yaml:"headers"`
+	Body     string   `yaml:"body"`
+	File     string   `yaml:"file"`
+	Foreach  string   `yaml:"foreach"`
+	When     string   `yaml:"when"`
+	Continue bool     `yaml:"continue"`
+}
+
+// stepResult is the outcome of a step which later steps may refer to. Steps
+// run via foreach have a list of the bodies of each request.
+type stepResult struct {
+	status int
+	body   interface{}
+	output []byte
+	dryRun bool
+}
+
+// dryRunRef is the value of a reference to a field of a response which was not
+// sent due to dry-run. It is output as the reference itself.
+type dryRunRef string
+
+// workflowScope holds the values which references in a step are resolved
+// against.
+type workflowScope struct {
+	steps   map[string]stepResult
+	vars    map[string]string
+	item    interface{}
+	hasItem bool
+}
+
+func runCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "run <workflow>",
+		Short: "Run the requests of a YAML workflow file in order.",
+		Long: `Run the requests of a YAML workflow file in order. Each step is a request
+which may refer to the status and body of earlier steps, run once for each
+element of a list via foreach, or be skipped via when.
+
+steps:
+  - name: login
+    verb: post
+    path: /login
+    body: '{"user":"${vars.user}"}'
+  - name: posts
+    path: /users/${steps.login.body.id}/posts
+    headers: ["Authorization=Bearer ${steps.login.body.token}"]
+  - name: comments
+    foreach: ${steps.posts.body}
+    when: ${item.published} == true
+    path: /posts/${item.id}/comments
+  - name: audit
+    continue: true
+    verb: post
+    path: /audit
+outputs:
+  comments: ${steps.comments.body}
+
+References to fields which do not exist are an error. Once a step fails, the
+workflow stops before the next step unless it sets continue: true. The outputs
+are written to stdout as a JSON object; without outputs, the response of the
+last step is written instead.
+
+Examples:
+
+> jaq run workflow.yaml --var user=me
+> jaq get /users | jaq run workflow.yaml --var user=${1.name}
+`,
+		Args: cobra.ExactArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conf, err := newConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			wf, err := readWorkflow(args[0])
+			if err != nil {
+				return &exitError{code: exitInputError, err: err}
+			}
+
+			vars, err := cmd.Flags().GetStringArray("var")
+			if err != nil {
+				return err
+			}
+			scope := workflowScope{steps: map[string]stepResult{}, vars: map[string]string{}}
+			for _, v := range vars {
+				parts := strings.SplitN(v, "=", 2)
+				if len(parts) != 2 {
+					return fmt.Errorf("invalid var: %q, expected NAME=VALUE", v)
+				}
+				scope.vars[parts[0]] = parts[1]
+			}
+
+			return runWorkflow(conf, wf, scope)
+		},
+	}
+	c.Flags().StringArrayP("var", "", []string{}, "Set a variable referred to as ${vars.NAME} in the workflow (e.g. user=me); may be repeated")
+	return c
+}
+
+// readWorkflow reads the workflow file and checks that steps only refer to
+// steps which run before them.
+func readWorkflow(path string) (workflow, error) {
+	var wf workflow
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return wf, err
+	}
+	if err := yaml.UnmarshalStrict(b, &wf); err != nil {
+		return wf, fmt.Errorf("invalid workflow %v: %v", path, err)
+	}
+	if len(wf.Steps) == 0 {
+		return wf, fmt.Errorf("invalid workflow %v: no steps", path)
+	}
+
+	seen := map[string]bool{}
+	for i := range wf.Steps {
+		step := &wf.Steps[i]
+		if step.Name == "" {
+			step.Name = strconv.Itoa(i + 1)
+		}
+		if !stepNamePattern.MatchString(step.Name) {
+			return wf, fmt.Errorf("invalid workflow %v: step name %q may only contain letters, digits, _ and -", path, step.Name)
+		}
+		if seen[step.Name] {
+			return wf, fmt.Errorf("invalid workflow %v: duplicate step name %q", path, step.Name)
+		}
+		if step.Verb == "" {
+			step.Verb = http.MethodGet
+		}
+		if !validVerb(step.Verb) {
+			return wf, fmt.Errorf("invalid workflow %v: step %q has invalid verb %q", path, step.Name, step.Verb)
+		}
+		if step.Path == "" {
+			return wf, fmt.Errorf("invalid workflow %v: step %q has no path", path, step.Name)
+		}
+
+		fields := append([]string{step.Path, step.Query, step.Body, step.File, step.Foreach, step.When}, step.Headers...)
+		for _, f := range fields {
+			for _, m := range stepRefPattern.FindAllStringSubmatch(f, -1) {
+				if !seen[m[1]] {
+					return wf, fmt.Errorf("invalid workflow %v: step %q refers to step %q which does not run before it", path, step.Name, m[1])
+				}
+			}
+		}
+		seen[step.Name] = true
+	}
+
+	for name, o := range wf.Outputs {
+		for _, m := range stepRefPattern.FindAllStringSubmatch(o, -1) {
+			if !seen[m[1]] {
+				return wf, fmt.Errorf("invalid workflow %v: output %q refers to unknown step %q", path, name, m[1])
+			}
+		}
+	}
+
+	return wf, nil
+}
+
+// runWorkflow runs each step in order and then writes the outputs. Running
+// stops at the first step which returns an error, e.g. due to the fatal
+// on-error action, and before any step after a failed one which does not set
+// continue.
+func runWorkflow(conf config, wf workflow, scope workflowScope) error {
+	var last []byte
+	var failedStep string
+	for _, step := range wf.Steps {
+		if failedStep != "" && !step.Continue {
+			return fmt.Errorf("step %v: not run since step %v failed; set continue: true to run it anyway", step.Name, failedStep)
+		}
+
+		failed := stats.failed
+		result, err := runStep(conf, step, scope)
+		if e, ok := err.(*exitError); ok {
+			return &exitError{code: e.code, err: fmt.Errorf("step %v: %v", step.Name, e.err)}
+		}
+		if err != nil {
+			return fmt.Errorf("step %v: %v", step.Name, err)
+		}
+		if stats.failed > failed && failedStep == "" {
+			failedStep = step.Name
+		}
+		result.dryRun = conf.dryRun != dryRunOff
+		scope.steps[step.Name] = result
+		last = result.output
+	}
+
+	// Nothing was sent so there is nothing to output.
+	if conf.dryRun != dryRunOff {
+		return nil
+	}
+
+	if len(wf.Outputs) == 0 {
+		_, err := conf.stdout.Write(last)
+		return err
+	}
+
+	outputs := map[string]interface{}{}
+	for name, o := range wf.Outputs {
+		v, err := scope.resolve(o)
+		if err != nil {
+			return fmt.Errorf("output %v: %v", name, err)
+		}
+		outputs[name] = v
+	}
+	return encodeJSON(conf.stdout, outputs)
+}
+
+// runStep sends the request of the step, once for each element of its foreach
+// list if it has one.
+func runStep(conf config, step workflowStep, scope workflowScope) (stepResult, error) {
+	if step.Foreach == "" {
+		run, err := scope.when(step.When)
+		if err != nil || !run {
+			return stepResult{}, err
+		}
+		return sendStep(conf, step, scope)
+	}
+
+	v, err := scope.resolve(step.Foreach)
+	if err != nil {
+		return stepResult{}, err
+	}
+	var items []interface{}
+	switch t := v.(type) {
+	case []interface{}:
+		items = t
+	case nil, dryRunRef:
+		// Earlier steps have no body in dry-run mode.
+	default:
+		return stepResult{}, fmt.Errorf("foreach %v is not a list", step.Foreach)
+	}
+
+	// The items are filtered up front so that the requests of the fan-out
+	// are confirmed as a whole, like the rows of piped input are.
+	var selected []interface{}
+	for _, item := range items {
+		scope.item, scope.hasItem = item, true
+		run, err := scope.when(step.When)
+		if err != nil {
+			return stepResult{}, err
+		}
+		if run {
+			selected = append(selected, item)
+		}
+	}
+	if err := confirmSend(map[string]int{step.Verb: len(selected)}, []string{configHost()}); err != nil {
+		return stepResult{}, err
+	}
+
+	result := stepResult{body: []interface{}{}}
+	for _, item := range selected {
+		scope.item, scope.hasItem = item, true
+		r, err := sendStep(conf, step, scope)
+		if err != nil {
+			return result, err
+		}
+		result.status = r.status
+		result.body = append(result.body.([]interface{}), r.body)
+		result.output = append(result.output, r.output...)
+	}
+	return result, nil
+}
+
+// sendStep sends a single request of the step. The response is captured
+// rather than written to stdout so that later steps can refer to it.
+func sendStep(conf config, step workflowStep, scope workflowScope) (stepResult, error) {
+	var result stepResult
+
+	path, err := scope.expand(step.Path)
+	if err != nil {
+		return result, err
+	}
+	query, err := scope.expand(step.Query)
+	if err != nil {
+		return result, err
+	}
+	conf.body, err = scope.expand(step.Body)
+	if err != nil {
+		return result, err
+	}
+	conf.filepath, err = scope.expand(step.File)
+	if err != nil {
+		return result, err
+	}
+
+	// Flags given on the command line add to, or override, every step.
+	if conf.query != "" && query != "" {
+		query += "&"
+	}
+	conf.query = query + conf.query
+	var headers []string
+	for _, h := range step.Headers {
+		expanded, err := scope.expand(h)
+		if err != nil {
+			return result, err
+		}
+		headers = append(headers, expanded)
+	}
+	conf.headers = append(headers, conf.headers...)
+
+	conf.verb = strings.ToUpper(step.Verb)
+	conf.commandPath = RootCmd.Name() + " " + strings.ToLower(step.Verb)
+
+	if err := expandSessionConfig(&conf, &path); err != nil {
+		return result, err
+	}
+	if err := resolveSchemas(&conf, conf.verb, path); err != nil {
+		return result, err
+	}
+	if err := validateRequestBody(conf); err != nil {
+		return result, err
+	}
+
+	// Dry-run output is written as usual rather than captured.
+	var out bytes.Buffer
+	if conf.dryRun == dryRunOff {
+		conf.stdout = &out
+	}
+	result.status, err = send(conf, func() (*http.Request, error) {
+		return newRequest(conf, path)
+	})
+	if err != nil {
+		return result, err
+	}
+
+	result.output = out.Bytes()
+	if b := bytes.TrimSpace(out.Bytes()); len(b) > 0 {
+		if err := json.Unmarshal(b, &result.body); err != nil {
+			result.body = string(b)
+		}
+	}
+	return result, nil
+}
+
+// when evaluates the condition of a step. Conditions are either a comparison
+// (A == B or A != B) or a single value which holds unless it is empty, false,
+// 0 or null.
+func (s workflowScope) when(cond string) (bool, error) {
+	if cond == "" {
+		return true, nil
+	}
+
+	expanded, err := s.expand(cond)
+	if err != nil {
+		return false, err
+	}
+
+	for _, op := range []string{"!=", "=="} {
+		if parts := strings.SplitN(expanded, op, 2); len(parts) == 2 {
+			equal := strings.TrimSpace(parts[0]) == strings.TrimSpace(parts[1])
+			return equal == (op == "=="), nil
+		}
+	}
+
+	switch strings.TrimSpace(expanded) {
+	case "", "false", "0", "null":
+		return false, nil
+	}
+	return true, nil
+}
+
+// resolve returns the value of a single reference as-is, so that lists and
+// objects keep their type, and expands anything else into a string.
+func (s workflowScope) resolve(v string) (interface{}, error) {
+	if m := singleRefPattern.FindStringSubmatch(strings.TrimSpace(v)); m != nil {
+		return s.lookup(m[1])
+	}
+	return s.expand(v)
+}
+
+// expand replaces the references in the string with their values. Strings are
+// used as-is and lists and objects as JSON.
+func (s workflowScope) expand(v string) (string, error) {
+	var err error
+	expanded := refPattern.ReplaceAllStringFunc(v, func(match string) string {
+		ref := refPattern.FindStringSubmatch(match)[1]
+		value, lookupErr := s.lookup(ref)
+		if lookupErr != nil {
+			if err == nil {
+				err = lookupErr
+			}
+			return ""
+		}
+
+		switch t := value.(type) {
+		case string:
+			return t
+		case nil:
+			return "null"
+		case dryRunRef:
+			return string(t)
+		case float64:
+			return strconv.FormatFloat(t, 'f', -1, 64)
+		case bool:
+			return strconv.FormatBool(t)
+		}
+		b, marshalErr := json.Marshal(value)
+		if marshalErr != nil && err == nil {
+			err = marshalErr
+		}
+		return string(b)
+	})
+	return expanded, err
+}
+
+// lookup returns the value of a reference: steps.NAME.status,
+// steps.NAME.body[.FIELD], item[.FIELD], vars.NAME or session.NAME. Fields
+// which do not exist are an error so that a typo does not send a request.
+func (s workflowScope) lookup(ref string) (interface{}, error) {
+	parts := strings.SplitN(ref, ".", 2)
+	switch parts[0] {
+	case "item":
+		if !s.hasItem {
+			return nil, fmt.Errorf("${%v} used outside of foreach", ref)
+		}
+		if len(parts) == 1 {
+			return s.item, nil
+		}
+		return query(s.item, parts[1], ref)
+	case "vars":
+		if len(parts) == 1 {
+			break
+		}
+		v, ok := s.vars[parts[1]]
+		if !ok {
+			return nil, fmt.Errorf("${%v} is not set; set it via --var %v=VALUE", ref, parts[1])
+		}
+		return v, nil
+	case "session":
+		if len(parts) == 1 {
+			break
+		}
+		values, err := loadSession()
+		if err != nil {
+			return nil, err
+		}
+		return expandSession("${"+ref+"}", values)
+	case "steps":
+		if len(parts) == 1 {
+			break
+		}
+		stepParts := strings.SplitN(parts[1], ".", 3)
+		result, ok := s.steps[stepParts[0]]
+		if !ok || len(stepParts) == 1 {
+			break
+		}
+		switch {
+		case stepParts[1] == "status":
+			return float64(result.status), nil
+		case stepParts[1] == "body" && len(stepParts) == 2:
+			return result.body, nil
+		case stepParts[1] == "body" && result.dryRun:
+			return dryRunRef("${" + ref + "}"), nil
+		case stepParts[1] == "body":
+			return query(result.body, stepParts[2], ref)
+		}
+	}
+	return nil, fmt.Errorf("unknown reference ${%v}, expected steps.NAME.status, steps.NAME.body, item, vars.NAME or session.NAME", ref)
+}
+
+// query returns the field of the JSON value at the given dot separated path,
+// or an error naming the reference if there is no such field.
+func query(v interface{}, path, ref string) (interface{}, error) {
+	c, _ := gabs.Consume(v)
+	field := c.Path(path)
+	if field == nil {
+		return nil, fmt.Errorf("${%v} does not exist", ref)
+	}
+	return field.Data(), nil
+}
+
+func init() {
+	ResetSettingsWorkflow()
+}
+
+func ResetSettingsWorkflow() {
+	RootCmd.AddCommand(runCommand())
+}
@@ -0,0 +1,225 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestRunWorkflow(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	var got []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		got = append(got, strings.TrimSpace(req.Method+" "+req.URL.String()+" "+req.Header.Get("Authorization")+" "+string(b)))
+
+		switch req.URL.Path {
+		case "/login":
+			fmt.Fprint(w, `{"id":7,"token":"abc"}`)
+		case "/users/7/posts":
+			fmt.Fprint(w, `[{"id":1,"published":true},{"id":2,"published":false},{"id":3,"published":true}]`)
+		case "/posts/1/comments", "/posts/3/comments":
+			fmt.Fprintf(w, `[{"body":"on %v"}]`, strings.Split(req.URL.Path, "/")[2])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"not found"}`)
+		}
+	}))
+	defer s.Close()
+
+	chain := `
+steps:
+  - name: login
+    verb: post
+    path: /login
+    body: '{"user":"${vars.user}"}'
+  - name: posts
+    path: /users/${steps.login.body.id}/posts
+    headers: ["Authorization=Bearer ${steps.login.body.token}"]
+  - name: comments
+    foreach: ${steps.posts.body}
+    when: ${item.published} == true
+    path: /posts/${item.id}/comments
+    query: limit=1
+outputs:
+  token: ${steps.login.body.token}
+  comments: ${steps.comments.body}
+  summary: ${steps.posts.status} with ${steps.login.body.id}
+`
+
+	testCases := []struct {
+		desc     string
+		workflow string
+		args     []string
+		input    string
+
+		expectRequests []string
+		expectStdout   string
+		expectErr      string
+	}{
+		{
+			desc:     "chained steps",
+			workflow: chain,
+			args:     []string{"--var", "user=me"},
+			expectRequests: []string{
+				`POST /login  {"user":"me"}`,
+				"GET /users/7/posts Bearer abc",
+				"GET /posts/1/comments?limit=1",
+				"GET /posts/3/comments?limit=1",
+			},
+			expectStdout: `{
+  "comments": [
+    [
+      {
+        "body": "on 1"
+      }
+    ],
+    [
+      {
+        "body": "on 3"
+      }
+    ]
+  ],
+  "summary": "200 with 7",
+  "token": "abc"
+}
+`,
+		}, {
+			desc:           "last response without outputs",
+			workflow:       "steps:\n  - path: /login\n  - path: /users/${steps.1.body.id}/posts\n    when: ${steps.1.status} != 200\n  - path: /login\n    query: id=${steps.1.body.id}",
+			expectRequests: []string{"GET /login", "GET /login?id=7"},
+			expectStdout:   `{"id":7,"token":"abc"}` + "\n",
+		}, {
+			desc:           "vars from piped input",
+			workflow:       "steps:\n  - path: /users/${vars.id}/posts\n    query: limit=1",
+			args:           []string{"--var", "id=${1.id}", "-q", "page=2"},
+			input:          `{"id":7} {"id":8}`,
+			expectRequests: []string{"GET /users/7/posts?limit=1&page=2", "GET /users/8/posts?limit=1&page=2"},
+			expectStdout:   `[{"id":1,"published":true},{"id":2,"published":false},{"id":3,"published":true}]` + "\n",
+		}, {
+			desc:           "fatal step stops the workflow",
+			workflow:       "steps:\n  - name: missing\n    path: /missing\n  - path: /login",
+			args:           []string{"--on-error", "fatal"},
+			expectRequests: []string{"GET /missing"},
+			expectErr:      "step missing: Unexpected status from response: 404 Not Found",
+		}, {
+			desc:         "dry-run",
+			workflow:     chain,
+			args:         []string{"--var", "user=me", "--dry-run=jaq"},
+			expectStdout: "jaq post /login --body '{\"user\":\"me\"}'\njaq get '/users/${steps.login.body.id}/posts' --header 'Authorization=Bearer ${steps.login.body.token}'\n",
+		}, {
+			desc:           "failed step stops the workflow",
+			workflow:       "steps:\n  - name: missing\n    path: /missing\n  - path: /login",
+			expectRequests: []string{"GET /missing"},
+			expectErr:      "step 2: not run since step missing failed; set continue: true to run it anyway",
+		}, {
+			desc:           "continue after a failed step",
+			workflow:       "steps:\n  - name: missing\n    path: /missing\n  - path: /login\n    continue: true",
+			expectRequests: []string{"GET /missing", "GET /login"},
+			expectStdout:   `{"id":7,"token":"abc"}` + "\n",
+		}, {
+			desc:           "missing field",
+			workflow:       "steps:\n  - path: /login\n  - path: /users/${steps.1.body.tokn}",
+			expectRequests: []string{"GET /login"},
+			expectErr:      "step 2: ${steps.1.body.tokn} does not exist",
+		}, {
+			desc:           "missing item field",
+			workflow:       "steps:\n  - path: /users/7/posts\n  - foreach: ${steps.1.body}\n    path: /posts/${item.nope}",
+			expectRequests: []string{"GET /users/7/posts"},
+			expectErr:      "step 2: ${item.nope} does not exist",
+		}, {
+			desc:      "reference to a later step",
+			workflow:  "steps:\n  - path: /users/${steps.b.body.id}\n  - name: b\n    path: /login",
+			expectErr: `invalid workflow WORKFLOW: step "1" refers to step "b" which does not run before it`,
+		}, {
+			desc:      "unknown field",
+			workflow:  "steps:\n  - path: /login\n    method: post",
+			expectErr: "invalid workflow WORKFLOW: yaml: unmarshal errors:\n  line 3: field method not found in type cmd.workflowStep",
+		}, {
+			desc:      "missing var",
+			workflow:  "steps:\n  - path: /users/${vars.id}",
+			expectErr: "step 1: ${vars.id} is not set; set it via --var id=VALUE",
+		}, {
+			desc:           "foreach of a non-list",
+			workflow:       "steps:\n  - path: /login\n  - foreach: ${steps.1.body.id}\n    path: /posts/${item}",
+			expectRequests: []string{"GET /login"},
+			expectErr:      "step 2: foreach ${steps.1.body.id} is not a list",
+		}, {
+			desc:           "foreach over max requests",
+			workflow:       "steps:\n  - path: /users/7/posts\n  - foreach: ${steps.1.body}\n    when: ${item.published} == true\n    verb: delete\n    path: /posts/${item.id}",
+			args:           []string{"--max-requests", "1"},
+			expectRequests: []string{"GET /users/7/posts"},
+			expectErr:      "step 2: refusing to send 2 requests; exceeds --max-requests of 1",
+		}, {
+			desc:           "dollar in a literal body",
+			workflow:       "steps:\n  - path: /login\n  - verb: post\n    path: /login\n    body: '{\"$set\":{\"id\":${steps.1.body.id}},\"price\":\"$5\"}'",
+			expectRequests: []string{"GET /login", `POST /login  {"$set":{"id":7},"price":"$5"}`},
+			expectStdout:   `{"id":7,"token":"abc"}` + "\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ResetSettings()
+			viper.Set("scheme", "http")
+			viper.Set("domain", s.Listener.Addr().String())
+			got = nil
+
+			path := filepath.Join(tmpDir, "workflow.yaml")
+			if err := ioutil.WriteFile(path, []byte(tc.workflow), 0644); err != nil {
+				t.Fatalf("Failed to write workflow: %v", err)
+			}
+
+			var input io.Reader
+			if tc.input != "" {
+				input = strings.NewReader(tc.input)
+			}
+
+			stdout, _, err := captureOutput(execute, append([]string{"run", path}, tc.args...), input)
+			expectErr := strings.Replace(tc.expectErr, "WORKFLOW", path, -1)
+			switch {
+			case err == nil && expectErr != "":
+				t.Errorf("Expected error %q but got none", expectErr)
+			case err != nil && err.Error() != expectErr:
+				t.Errorf("Expected error %q, got %q", expectErr, err)
+			}
+
+			if !reflect.DeepEqual(got, tc.expectRequests) {
+				t.Errorf("Expected requests %q, got %q", tc.expectRequests, got)
+			}
+			if stdout != tc.expectStdout {
+				t.Errorf("Expected stdout %q, got %q", tc.expectStdout, stdout)
+			}
+		})
+	}
+}
@@ -0,0 +1,576 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/gabs"
+	"github.com/spf13/cobra"
+)
+
+// WebSocket opcodes (RFC 6455 section 5.2).
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+
+	// wsAcceptGUID is appended to the key of the handshake to form the
+	// accept value expected from the server.
+	wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC11B65"
+
+	// wsMaxMessage limits the size of received messages so that a broken
+	// frame can not exhaust memory.
+	wsMaxMessage = 32 << 20
+)
+
+// errWSIdle is returned when no message arrives within the idle time.
+var errWSIdle = errors.New("idle")
+
+// webSockets are the connections of the run, keyed by URL, so that each row of
+// piped input is sent over the same connection.
+var webSockets = map[string]*wsConn{}
+
+func init() {
+	ResetSettingsWebSocket()
+}
+
+// ResetSettingsWebSocket adds the ws command.
+func ResetSettingsWebSocket() {
+	RootCmd.AddCommand(wsCommand())
+}
+
+func wsCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "ws",
+		Short: "Send and receive JSON messages over a WebSocket.",
+		Long: `Opens a WebSocket to the path, using the same configuration as the HTTP
+commands, and sends the --body or --file as a text message. With piped input,
+the message of each row is sent over the same connection.
+
+Received messages are written as lines of JSON. After sending a message, jaq
+waits for --replies messages; with --correlate, only messages whose field has
+the same value as in the sent message count as replies. Without either, jaq
+keeps writing messages until the server closes the connection, no message
+arrives for --idle or it is interrupted.`,
+		Example: `  jaq ws /updates --idle 30s
+  jaq ws /rpc -b '{"id":1,"method":"status"}' --correlate id
+  jaq get /users | jaq ws /rpc -b '{"id":"${1.id}","method":"refresh"}' --correlate id`,
+		Args: cobra.ExactArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conf, err := newConfig(cmd)
+			if err != nil {
+				return err
+			}
+			opts, err := newWSOptions(cmd)
+			if err != nil {
+				return err
+			}
+			return runWebSocket(conf, args[0], opts)
+		},
+	}
+
+	fs := c.Flags()
+	fs.IntP("replies", "", 0, "Number of replies to wait for after sending each message")
+	fs.StringP("correlate", "", "", "Field of the messages (e.g. id) which replies must have the same value in as the sent message")
+	fs.DurationP("idle", "", 0, "Close the connection once no message has arrived for this long; 0 for no limit")
+	return c
+}
+
+// wsOptions are the settings of a WebSocket exchange.
+type wsOptions struct {
+	replies   int
+	correlate string
+	idle      time.Duration
+}
+
+func newWSOptions(cmd *cobra.Command) (wsOptions, error) {
+	var opts wsOptions
+	var err error
+	if opts.replies, err = cmd.Flags().GetInt("replies"); err != nil {
+		return opts, err
+	}
+	if opts.correlate, err = cmd.Flags().GetString("correlate"); err != nil {
+		return opts, err
+	}
+	if opts.idle, err = cmd.Flags().GetDuration("idle"); err != nil {
+		return opts, err
+	}
+	if opts.replies < 0 || opts.idle < 0 {
+		return opts, fmt.Errorf("invalid replies %v and idle %v; expected values >= 0", opts.replies, opts.idle)
+	}
+
+	// Correlating is waiting for a reply.
+	if opts.correlate != "" && opts.replies == 0 {
+		opts.replies = 1
+	}
+	return opts, nil
+}
+
+// runWebSocket sends the message of the row, opening the connection if this is
+// the first row, and waits for the replies.
+func runWebSocket(conf config, path string, opts wsOptions) error {
+	for ws, h := range map[string]string{"ws://": "http://", "wss://": "https://"} {
+		if strings.HasPrefix(path, ws) {
+			path = h + strings.TrimPrefix(path, ws)
+		}
+	}
+
+	if err := expandSessionConfig(&conf, &path); err != nil {
+		return err
+	}
+
+	// The handshake is a GET without a body; the body is the message.
+	hconf := conf
+	hconf.verb = http.MethodGet
+	hconf.body, hconf.filepath = "", ""
+	hconf.spec = nil
+
+	if conf.dryRun != dryRunOff {
+		req, err := newRequest(hconf, path)
+		if err != nil {
+			return err
+		}
+		return printDryRun(conf.stdout, conf, req)
+	}
+
+	message := []byte(conf.body)
+	if conf.filepath != "" {
+		var err error
+		if message, err = ioutil.ReadFile(conf.filepath); err != nil {
+			return err
+		}
+	}
+
+	c, err := openWebSocket(hconf, path)
+	if err != nil {
+		return err
+	}
+	c.stdout = conf.stdout
+	c.idle = opts.idle
+	c.listen = opts.replies == 0
+
+	start := time.Now()
+	err = c.exchange(message, opts)
+	stats.add(c.resp, err, time.Since(start), err != nil)
+	return err
+}
+
+// openWebSocket returns the connection to the URL of the request, doing the
+// handshake if it is not open yet.
+func openWebSocket(conf config, path string) (*wsConn, error) {
+	req, err := newRequest(conf, path)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := webSockets[req.URL.String()]; ok {
+		return c, nil
+	}
+
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+
+	if conf.trace || conf.debug {
+		dump, err := httputil.DumpRequestOut(req, false)
+		if err != nil {
+			log.Println("Unable to dump request out:", err)
+		}
+		log.Printf("Sending request: %v", string(dump))
+	}
+
+	// The connection outlives the request so the request-timeout does not
+	// apply once it is open.
+	client := newClient(conf)
+	client.Timeout = 0
+	start := time.Now()
+	resp, err := client.Do(req.WithContext(runCtx))
+	if err != nil {
+		stats.add(nil, err, time.Since(start), true)
+		return nil, err
+	}
+
+	if conf.trace || conf.debug {
+		dump, err := httputil.DumpResponse(resp, false)
+		if err != nil {
+			log.Println("Unable to dump request out:", err)
+		}
+		log.Printf("Got response: %v", string(dump))
+	}
+
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if resp.StatusCode != http.StatusSwitchingProtocols || !ok {
+		stats.add(resp, nil, time.Since(start), true)
+		if err := processResponse(conf, resp, actionReport); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("WebSocket handshake with %v failed: %v", req.URL, resp.Status)
+	}
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != wsAccept(req.Header.Get("Sec-WebSocket-Key")) {
+		conn.Close()
+		stats.add(resp, nil, time.Since(start), true)
+		return nil, fmt.Errorf("WebSocket handshake with %v failed: invalid Sec-WebSocket-Accept %q", req.URL, accept)
+	}
+
+	c := &wsConn{
+		conn:     conn,
+		r:        bufio.NewReader(conn),
+		resp:     resp,
+		messages: make(chan []byte, 64),
+		done:     make(chan struct{}),
+	}
+	go c.readLoop()
+	webSockets[req.URL.String()] = c
+	return c, nil
+}
+
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// closeWebSockets closes the connections of the run. If drain is set,
+// connections which were not waiting for replies first write messages until
+// the server closes them or they are idle.
+func closeWebSockets(drain bool) error {
+	var first error
+	for u, c := range webSockets {
+		if err := c.close(drain); err != nil && first == nil {
+			first = err
+		}
+		delete(webSockets, u)
+	}
+	return first
+}
+
+// wsConn is an open WebSocket. Messages are read in the background so that
+// pings are answered while waiting between rows.
+type wsConn struct {
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+	resp *http.Response
+
+	// messages is closed once reading stops, after which err holds the
+	// reason; io.EOF if the server closed the connection normally.
+	messages chan []byte
+	err      error
+	done     chan struct{}
+
+	// writeMu guards writes since pings are answered by the read loop.
+	// Nothing may be sent after a close frame.
+	writeMu   sync.Mutex
+	closeSent bool
+
+	// The settings of the latest row.
+	stdout io.Writer
+	idle   time.Duration
+	listen bool
+}
+
+// exchange sends the message, if any, and waits for the replies to it.
+func (c *wsConn) exchange(message []byte, opts wsOptions) error {
+	var want string
+	if len(message) > 0 {
+		if opts.correlate != "" {
+			parsed, err := gabs.ParseJSON(message)
+			if err != nil || !parsed.ExistsP(opts.correlate) {
+				return fmt.Errorf("message has no %v field to correlate replies on", opts.correlate)
+			}
+			want = formatValue(parsed.Path(opts.correlate).Data())
+		}
+		if err := c.writeFrame(wsText, message); err != nil {
+			return err
+		}
+	}
+
+	for got := 0; got < opts.replies; {
+		msg, err := c.next(c.idle)
+		switch {
+		case err == errWSIdle:
+			return fmt.Errorf("no message for %v while waiting for %d more replies", c.idle, opts.replies-got)
+		case err == io.EOF:
+			return fmt.Errorf("WebSocket closed while waiting for %d more replies", opts.replies-got)
+		case err != nil:
+			return err
+		}
+		if err := c.write(msg); err != nil {
+			return err
+		}
+		if want == "" || correlated(msg, opts.correlate, want) {
+			got++
+		}
+	}
+
+	// Messages which arrived meanwhile are written rather than left until
+	// the next row.
+	return c.flush()
+}
+
+func correlated(msg []byte, field, want string) bool {
+	parsed, err := gabs.ParseJSON(msg)
+	return err == nil && parsed.ExistsP(field) && formatValue(parsed.Path(field).Data()) == want
+}
+
+// next returns the next message, waiting at most idle if it is set.
+func (c *wsConn) next(idle time.Duration) ([]byte, error) {
+	var timeout <-chan time.Time
+	if idle > 0 {
+		timer := time.NewTimer(idle)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			return nil, c.err
+		}
+		return msg, nil
+	case <-timeout:
+		return nil, errWSIdle
+	case <-runCtx.Done():
+		return nil, runCtx.Err()
+	}
+}
+
+// flush writes the messages which have already arrived.
+func (c *wsConn) flush() error {
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				return nil
+			}
+			if err := c.write(msg); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// write writes the message as a line of JSON.
+func (c *wsConn) write(msg []byte) error {
+	return (&eventStream{w: c.stdout}).write(msg, nil)
+}
+
+// close writes the remaining messages and does the closing handshake.
+func (c *wsConn) close(drain bool) error {
+	var err error
+	if drain && c.listen {
+		for err == nil {
+			var msg []byte
+			if msg, err = c.next(c.idle); err == nil {
+				err = c.write(msg)
+			}
+		}
+		if err == io.EOF || err == errWSIdle {
+			err = nil
+		}
+	} else if drain {
+		err = c.flush()
+	}
+
+	// The server answers the close frame with its own and then closes the
+	// connection, which ends the read loop.
+	c.writeFrame(wsClose, []byte{0x03, 0xe8})
+	close(c.done)
+	select {
+	case <-c.finished():
+	case <-time.After(time.Second):
+	}
+	c.conn.Close()
+	return err
+}
+
+// finished is closed once the read loop has stopped.
+func (c *wsConn) finished() <-chan struct{} {
+	ch := make(chan struct{})
+	go func() {
+		for range c.messages {
+		}
+		close(ch)
+	}()
+	return ch
+}
+
+func (c *wsConn) readLoop() {
+	defer close(c.messages)
+	for {
+		msg, err := c.readMessage()
+		if err != nil {
+			c.err = err
+			return
+		}
+		select {
+		case c.messages <- msg:
+		case <-c.done:
+		}
+	}
+}
+
+// readMessage reads the frames of the next data message, answering any control
+// frames in between.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+		case wsPong:
+		case wsClose:
+			// The close frame is answered with the same status code.
+			echo := payload
+			if len(echo) > 2 {
+				echo = echo[:2]
+			}
+			c.writeFrame(wsClose, echo)
+			if len(payload) < 2 {
+				return nil, io.EOF
+			}
+			switch code := binary.BigEndian.Uint16(payload); code {
+			case 1000, 1001:
+				return nil, io.EOF
+			default:
+				return nil, fmt.Errorf("WebSocket closed by the server with status %d %q", code, payload[2:])
+			}
+		case wsText, wsBinary, wsContinuation:
+			msg = append(msg, payload...)
+			if len(msg) > wsMaxMessage {
+				return nil, fmt.Errorf("WebSocket message exceeds %d bytes", wsMaxMessage)
+			}
+			if fin {
+				return msg, nil
+			}
+		default:
+			return nil, fmt.Errorf("unexpected WebSocket opcode %#x", op)
+		}
+	}
+}
+
+// readFrame reads a single frame, unmasking its payload if needed.
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var h [2]byte
+	if _, err = io.ReadFull(c.r, h[:]); err != nil {
+		return
+	}
+	fin, op = h[0]&0x80 != 0, h[0]&0x0f
+	masked := h[1]&0x80 != 0
+
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxMessage {
+		err = fmt.Errorf("WebSocket message exceeds %d bytes", wsMaxMessage)
+		return
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.r, key[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	return
+}
+
+// writeFrame writes the payload as a single, masked frame as required of
+// clients.
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = op == wsClose
+
+	b := &bytes.Buffer{}
+	b.WriteByte(0x80 | op)
+	switch n := len(payload); {
+	case n < 126:
+		b.WriteByte(0x80 | byte(n))
+	case n <= 0xffff:
+		b.WriteByte(0x80 | 126)
+		binary.Write(b, binary.BigEndian, uint16(n))
+	default:
+		b.WriteByte(0x80 | 127)
+		binary.Write(b, binary.BigEndian, uint64(n))
+	}
+
+	var key [4]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return err
+	}
+	b.Write(key[:])
+	for i, v := range payload {
+		b.WriteByte(v ^ key[i%4])
+	}
+
+	_, err := c.conn.Write(b.Bytes())
+	return err
+}
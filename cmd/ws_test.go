@@ -0,0 +1,241 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/gabs"
+	"github.com/spf13/viper"
+)
+
+// wsServerFrame writes an unmasked frame as a server would.
+func wsServerFrame(conn net.Conn, op byte, payload []byte) {
+	b := &bytes.Buffer{}
+	b.WriteByte(0x80 | op)
+	if len(payload) < 126 {
+		b.WriteByte(byte(len(payload)))
+	} else {
+		b.WriteByte(126)
+		binary.Write(b, binary.BigEndian, uint16(len(payload)))
+	}
+	b.Write(payload)
+	conn.Write(b.Bytes())
+}
+
+func TestWebSocket(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "testTmp")
+	if err != nil {
+		t.Fatalf("Failed to setup temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ioutil.WriteFile(filepath.Join(tmpDir, ".jaq.json"), []byte(`{}`), 0777)
+	os.Setenv("HOME", tmpDir)
+
+	var handshakes int64
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Header.Get("Authorization") != "Bearer secret":
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		case req.URL.Path == "/reject":
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"err":"forbidden"}`)
+			return
+		}
+
+		atomic.AddInt64(&handshakes, 1)
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Unable to hijack the connection: %v", err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %v\r\n\r\n", wsAccept(req.Header.Get("Sec-WebSocket-Key")))
+		rw.Flush()
+
+		peer := &wsConn{conn: conn, r: rw.Reader}
+		send := func(msg string) { wsServerFrame(conn, wsText, []byte(msg)) }
+		closeWith := func(code uint16, reason string) {
+			payload := append([]byte{byte(code >> 8), byte(code)}, reason...)
+			wsServerFrame(conn, wsClose, payload)
+			peer.readMessage()
+		}
+
+		switch req.URL.Path {
+		case "/rpc":
+			for {
+				msg, err := peer.readMessage()
+				if err != nil {
+					return
+				}
+				parsed, _ := gabs.ParseJSON(msg)
+				send(`{"event":"tick"}`)
+				send(fmt.Sprintf(`{"id":%v,"ok":true}`, parsed.Path("id").String()))
+			}
+		case "/echo":
+			for {
+				msg, err := peer.readMessage()
+				if err != nil {
+					return
+				}
+				send(string(msg))
+			}
+		case "/updates":
+			send(`{"n":1}`)
+			send(`{"n":2}`)
+			closeWith(1000, "")
+		case "/quiet":
+			send(`{"n":1}`)
+			for {
+				if _, err := peer.readMessage(); err != nil {
+					return
+				}
+			}
+		case "/ping":
+			wsServerFrame(conn, wsPing, []byte("hi"))
+			_, op, payload, err := peer.readFrame()
+			send(fmt.Sprintf(`{"pong":%v}`, err == nil && op == wsPong && string(payload) == "hi"))
+			closeWith(1001, "")
+		case "/broken":
+			closeWith(1011, "boom")
+		}
+	}))
+	defer s.Close()
+	addr := s.Listener.Addr().String()
+
+	large := fmt.Sprintf(`{"data":%q}`, strings.Repeat("x", 300))
+
+	testCases := []struct {
+		desc  string
+		args  []string
+		input string
+
+		expectStdout     string
+		expectStderr     string
+		expectErr        string
+		expectHandshakes int64
+	}{
+		{
+			desc:  "correlate piped rows",
+			args:  []string{"ws", "/rpc", "-b", `{"id":${1.id}}`, "--correlate", "id"},
+			input: `{"id":1} {"id":2}`,
+			expectStdout: `{"event":"tick"}` + "\n" + `{"id":1,"ok":true}` + "\n" +
+				`{"event":"tick"}` + "\n" + `{"id":2,"ok":true}` + "\n",
+			expectHandshakes: 1,
+		}, {
+			desc:             "replies",
+			args:             []string{"ws", "/rpc", "-b", `{"id":"a"}`, "--replies", "2"},
+			expectStdout:     `{"event":"tick"}` + "\n" + `{"id":"a","ok":true}` + "\n",
+			expectHandshakes: 1,
+		}, {
+			desc:         "large message",
+			args:         []string{"ws", "/echo", "-b", large, "--replies", "1"},
+			expectStdout: large + "\n",
+		}, {
+			desc:         "listen until closed",
+			args:         []string{"ws", "/updates"},
+			expectStdout: `{"n":1}` + "\n" + `{"n":2}` + "\n",
+		}, {
+			desc:         "listen until idle",
+			args:         []string{"ws", "/quiet", "--idle", "50ms"},
+			expectStdout: `{"n":1}` + "\n",
+		}, {
+			desc:         "idle waiting for reply",
+			args:         []string{"ws", "/quiet", "-b", `{"id":1}`, "--correlate", "id", "--idle", "50ms"},
+			expectStdout: `{"n":1}` + "\n",
+			expectErr:    "no message for 50ms while waiting for 1 more replies",
+		}, {
+			desc:         "ping",
+			args:         []string{"ws", "/ping"},
+			expectStdout: `{"pong":true}` + "\n",
+		}, {
+			desc:      "closed with error",
+			args:      []string{"ws", "/broken", "--replies", "1"},
+			expectErr: `WebSocket closed by the server with status 1011 "boom"`,
+		}, {
+			desc:         "handshake rejected",
+			args:         []string{"ws", "/reject"},
+			expectStderr: `{"err":"forbidden"}`,
+			expectErr:    fmt.Sprintf("WebSocket handshake with http://%v/reject failed: 403 Forbidden", addr),
+		}, {
+			desc:      "nothing to correlate",
+			args:      []string{"ws", "/rpc", "-b", `{"method":"status"}`, "--correlate", "id"},
+			expectErr: "message has no id field to correlate replies on",
+		}, {
+			desc:         "dry run",
+			args:         []string{"ws", "/rpc", "-b", `{"id":1}`, "--dry-run=jaq"},
+			expectStdout: `jaq ws /rpc --body '{"id":1}'` + "\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ResetSettings()
+			viper.Set("scheme", "http")
+			viper.Set("domain", addr)
+			viper.Set("auth", "token")
+			viper.Set("token", "secret")
+			atomic.StoreInt64(&handshakes, 0)
+
+			var input io.Reader
+			if tc.input != "" {
+				input = strings.NewReader(tc.input)
+			}
+
+			done := make(chan struct{})
+			var stdout, stderr string
+			go func() {
+				stdout, stderr, err = captureOutput(execute, tc.args, input)
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Timed out")
+			}
+
+			switch {
+			case err == nil && tc.expectErr != "":
+				t.Errorf("Expected error %q but got none", tc.expectErr)
+			case err != nil && err.Error() != tc.expectErr:
+				t.Errorf("Expected error %q, got %q", tc.expectErr, err)
+			}
+
+			if stdout != tc.expectStdout {
+				t.Errorf("Expected stdout %q, got %q", tc.expectStdout, stdout)
+			}
+			if !strings.Contains(stderr, tc.expectStderr) {
+				t.Errorf("Expected stderr to contain %q, got %q", tc.expectStderr, stderr)
+			}
+			if got := atomic.LoadInt64(&handshakes); tc.expectHandshakes > 0 && got != tc.expectHandshakes {
+				t.Errorf("Expected %v handshakes, got %v", tc.expectHandshakes, got)
+			}
+		})
+	}
+}
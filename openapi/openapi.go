@@ -0,0 +1,668 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The openapi package reads the paths and operations of OpenAPI 3 specs so
+// that requests can be completed, described and validated before being sent.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Ericsson/jaq/schema"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// methods are the operations of a path item, in the order they are listed.
+var methods = []string{"get", "put", "post", "patch", "delete", "head", "options", "trace"}
+
+// Spec is a parsed OpenAPI 3 document.
+type Spec struct {
+	Title, Version string
+
+	// BasePath is the path of the first server URL (e.g. /v1), which may
+	// prefix the paths requested.
+	BasePath string
+
+	// Paths are sorted by their template.
+	Paths []*PathItem
+
+	root interface{}
+	path string
+}
+
+// PathItem is a templated path (e.g. /pets/{id}) and its operations.
+type PathItem struct {
+	Template   string
+	Operations []*Operation
+}
+
+// Operation is a single method of a path.
+type Operation struct {
+	Method      string
+	Summary     string
+	Description string
+	Parameters  []Parameter
+	RequestBody *RequestBody
+	Responses   []Response
+}
+
+// Parameter is a path, query, header or cookie parameter of an operation.
+type Parameter struct {
+	Name        string
+	In          string
+	Description string
+	Required    bool
+	Schema      interface{}
+
+	schema *schema.Schema
+}
+
+// RequestBody describes the body accepted by an operation.
+type RequestBody struct {
+	Required bool
+	Content  []MediaType
+}
+
+// Response describes a response of an operation by its status (e.g. 200,
+// 4XX or default).
+type Response struct {
+	Status      string
+	Description string
+	Content     []MediaType
+}
+
+// MediaType is the schema of a body with the given content type.
+type MediaType struct {
+	Type   string
+	Schema interface{}
+
+	schema *schema.Schema
+}
+
+// Load reads and parses the spec at the given path, either JSON or YAML.
+func Load(path string) (*Spec, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(abs)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := parse(b, abs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI spec %v: %v", path, err)
+	}
+	return s, nil
+}
+
+// Parse parses the given spec, either JSON or YAML. References to other files
+// are not supported.
+func Parse(b []byte) (*Spec, error) {
+	return parse(b, "")
+}
+
+func parse(b []byte, path string) (*Spec, error) {
+	var root interface{}
+	if err := json.Unmarshal(b, &root); err != nil {
+		var y interface{}
+		if yamlErr := yaml.Unmarshal(b, &y); yamlErr != nil {
+			return nil, yamlErr
+		}
+		root = fromYAML(y)
+	}
+
+	m, ok := root.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an object, got %T", root)
+	}
+	if v, _ := m["openapi"].(string); !strings.HasPrefix(v, "3.") {
+		return nil, fmt.Errorf("unsupported OpenAPI version %q, expected 3.x", v)
+	}
+
+	s := &Spec{root: root, path: path}
+	info, _ := m["info"].(map[string]interface{})
+	s.Title, _ = info["title"].(string)
+	s.Version, _ = info["version"].(string)
+
+	if servers, ok := m["servers"].([]interface{}); ok && len(servers) > 0 {
+		server, _ := servers[0].(map[string]interface{})
+		if u, ok := server["url"].(string); ok {
+			if parsed, err := url.Parse(u); err == nil {
+				s.BasePath = strings.TrimSuffix(parsed.Path, "/")
+			}
+		}
+	}
+
+	paths, _ := m["paths"].(map[string]interface{})
+	for _, template := range sortedKeys(paths) {
+		item, err := s.parsePathItem(template, paths[template])
+		if err != nil {
+			return nil, err
+		}
+		s.Paths = append(s.Paths, item)
+	}
+
+	return s, nil
+}
+
+func (s *Spec) parsePathItem(template string, node interface{}) (*PathItem, error) {
+	m, err := s.object(node)
+	if err != nil {
+		return nil, fmt.Errorf("path %v: %v", template, err)
+	}
+
+	shared, err := s.parseParameters(m["parameters"])
+	if err != nil {
+		return nil, fmt.Errorf("path %v: %v", template, err)
+	}
+
+	item := &PathItem{Template: template}
+	for _, method := range methods {
+		opNode, ok := m[method]
+		if !ok {
+			continue
+		}
+		op, err := s.parseOperation(method, opNode, shared)
+		if err != nil {
+			return nil, fmt.Errorf("%v %v: %v", strings.ToUpper(method), template, err)
+		}
+		item.Operations = append(item.Operations, op)
+	}
+	return item, nil
+}
+
+func (s *Spec) parseOperation(method string, node interface{}, shared []Parameter) (*Operation, error) {
+	m, err := s.object(node)
+	if err != nil {
+		return nil, err
+	}
+
+	op := &Operation{Method: strings.ToUpper(method)}
+	op.Summary, _ = m["summary"].(string)
+	op.Description, _ = m["description"].(string)
+
+	params, err := s.parseParameters(m["parameters"])
+	if err != nil {
+		return nil, err
+	}
+
+	// Parameters of the operation override those of the path with the same
+	// name and location.
+	op.Parameters = params
+	for _, p := range shared {
+		overridden := false
+		for _, o := range params {
+			if o.Name == p.Name && o.In == p.In {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			op.Parameters = append(op.Parameters, p)
+		}
+	}
+
+	if rb, ok := m["requestBody"]; ok {
+		rbm, err := s.object(rb)
+		if err != nil {
+			return nil, fmt.Errorf("requestBody: %v", err)
+		}
+		op.RequestBody = &RequestBody{}
+		op.RequestBody.Required, _ = rbm["required"].(bool)
+		if op.RequestBody.Content, err = s.parseContent(rbm["content"]); err != nil {
+			return nil, fmt.Errorf("requestBody: %v", err)
+		}
+	}
+
+	responses, _ := m["responses"].(map[string]interface{})
+	for _, status := range sortedKeys(responses) {
+		rm, err := s.object(responses[status])
+		if err != nil {
+			return nil, fmt.Errorf("response %v: %v", status, err)
+		}
+		r := Response{Status: status}
+		r.Description, _ = rm["description"].(string)
+		if r.Content, err = s.parseContent(rm["content"]); err != nil {
+			return nil, fmt.Errorf("response %v: %v", status, err)
+		}
+		op.Responses = append(op.Responses, r)
+	}
+
+	return op, nil
+}
+
+func (s *Spec) parseParameters(node interface{}) ([]Parameter, error) {
+	list, _ := node.([]interface{})
+
+	var params []Parameter
+	for _, n := range list {
+		m, err := s.object(n)
+		if err != nil {
+			return nil, fmt.Errorf("parameter: %v", err)
+		}
+
+		p := Parameter{Schema: m["schema"]}
+		p.Name, _ = m["name"].(string)
+		p.In, _ = m["in"].(string)
+		p.Description, _ = m["description"].(string)
+		p.Required, _ = m["required"].(bool)
+		if p.Name == "" || p.In == "" {
+			return nil, fmt.Errorf("parameter must have a name and in")
+		}
+		if p.Schema != nil {
+			p.schema = schema.FromDocument(s.root, p.Schema, s.path)
+		}
+		params = append(params, p)
+	}
+	return params, nil
+}
+
+func (s *Spec) parseContent(node interface{}) ([]MediaType, error) {
+	m, _ := node.(map[string]interface{})
+
+	var content []MediaType
+	for _, t := range sortedKeys(m) {
+		mm, err := s.object(m[t])
+		if err != nil {
+			return nil, fmt.Errorf("content %v: %v", t, err)
+		}
+		mt := MediaType{Type: t, Schema: mm["schema"]}
+		if mt.Schema != nil {
+			mt.schema = schema.FromDocument(s.root, mt.Schema, s.path)
+		}
+		content = append(content, mt)
+	}
+	return content, nil
+}
+
+// object returns node as an object, following a $ref within the spec if it is
+// one.
+func (s *Spec) object(node interface{}) (map[string]interface{}, error) {
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an object, got %T", node)
+	}
+
+	ref, ok := m["$ref"].(string)
+	if !ok {
+		return m, nil
+	}
+
+	target, err := s.Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	return s.object(target)
+}
+
+// Resolve returns the part of the spec referenced by a $ref such as
+// #/components/schemas/Pet. Only references within the spec are supported.
+func (s *Spec) Resolve(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %q; only references within the spec are supported", ref)
+	}
+
+	target := s.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		part = strings.Replace(strings.Replace(part, "~1", "/", -1), "~0", "~", -1)
+		tm, ok := target.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unable to resolve $ref %q", ref)
+		}
+		if target, ok = tm[part]; !ok {
+			return nil, fmt.Errorf("unable to resolve $ref %q", ref)
+		}
+	}
+	return target, nil
+}
+
+// Operation returns the operation for the method or nil if there is none.
+func (p *PathItem) Operation(method string) *Operation {
+	for _, op := range p.Operations {
+		if strings.EqualFold(op.Method, method) {
+			return op
+		}
+	}
+	return nil
+}
+
+// Methods returns the methods of the operations of the path.
+func (p *PathItem) Methods() []string {
+	var ms []string
+	for _, op := range p.Operations {
+		ms = append(ms, op.Method)
+	}
+	return ms
+}
+
+// Find returns the path item matching the requested path along with the values
+// of its path parameters. Paths may be given with or without the base path.
+// Literal segments are preferred over parameters so that /pets/mine matches
+// before /pets/{id}. It returns nil if no path matches.
+func (s *Spec) Find(path string) (*PathItem, map[string]string) {
+	path = strings.SplitN(path, "?", 2)[0]
+	if s.BasePath != "" && strings.HasPrefix(path, s.BasePath+"/") {
+		if item, params := s.find(strings.TrimPrefix(path, s.BasePath)); item != nil {
+			return item, params
+		}
+	}
+	return s.find(path)
+}
+
+func (s *Spec) find(path string) (*PathItem, map[string]string) {
+	segments := splitPath(path)
+
+	var best *PathItem
+	var bestParams map[string]string
+	bestLiterals := -1
+	for _, item := range s.Paths {
+		template := splitPath(item.Template)
+		if len(template) != len(segments) {
+			continue
+		}
+
+		params := map[string]string{}
+		literals := 0
+		matched := true
+		for i, t := range template {
+			if name, ok := paramName(t); ok {
+				params[name] = segments[i]
+				continue
+			}
+			if t != segments[i] {
+				matched = false
+				break
+			}
+			literals++
+		}
+
+		if matched && literals > bestLiterals {
+			best, bestParams, bestLiterals = item, params, literals
+		}
+	}
+	return best, bestParams
+}
+
+// Suggest returns up to n templates which are similar to the path, most
+// similar first, for when the path is not found.
+func (s *Spec) Suggest(path string, n int) []string {
+	path = strings.SplitN(path, "?", 2)[0]
+	if s.BasePath != "" && strings.HasPrefix(path, s.BasePath+"/") {
+		path = strings.TrimPrefix(path, s.BasePath)
+	}
+	segments := splitPath(path)
+
+	type suggestion struct {
+		template string
+		distance int
+	}
+	var suggestions []suggestion
+	for _, item := range s.Paths {
+		// Fill in the parameters with the given segments so that only the
+		// literal parts of the template count towards the distance.
+		template := splitPath(item.Template)
+		for i, t := range template {
+			if _, ok := paramName(t); ok && i < len(segments) {
+				template[i] = segments[i]
+			}
+		}
+
+		d := distance(path, "/"+strings.Join(template, "/"))
+		if d <= len(item.Template)/2 {
+			suggestions = append(suggestions, suggestion{item.Template, d})
+		}
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].distance < suggestions[j].distance
+	})
+
+	var templates []string
+	for i := 0; i < len(suggestions) && i < n; i++ {
+		templates = append(templates, suggestions[i].template)
+	}
+	return templates
+}
+
+// Complete returns the paths which start with the prefix. Segments which have
+// already been typed fill in the parameters of the templates so that, for
+// instance, /pets/1/ completes to /pets/1/toys.
+func (s *Spec) Complete(prefix string) []string {
+	base := ""
+	if s.BasePath != "" && strings.HasPrefix(prefix, s.BasePath+"/") {
+		base = s.BasePath
+		prefix = strings.TrimPrefix(prefix, s.BasePath)
+	}
+
+	typed := strings.Split(strings.TrimPrefix(prefix, "/"), "/")
+	typed = typed[:len(typed)-1]
+
+	seen := map[string]bool{}
+	var completions []string
+	for _, item := range s.Paths {
+		template := splitPath(item.Template)
+		if len(template) < len(typed) {
+			continue
+		}
+		for i := range typed {
+			if _, ok := paramName(template[i]); ok {
+				template[i] = typed[i]
+			}
+		}
+
+		c := "/" + strings.Join(template, "/")
+		if strings.HasPrefix(c, prefix) && !seen[c] {
+			seen[c] = true
+			completions = append(completions, base+c)
+		}
+	}
+	return completions
+}
+
+// ValidateRequest checks the parameters and body of a request against the
+// operation and returns a description of each problem found.
+func (op *Operation) ValidateRequest(pathParams map[string]string, query url.Values, header http.Header, body []byte) []string {
+	var problems []string
+
+	for _, p := range op.Parameters {
+		var value string
+		var present bool
+		switch p.In {
+		case "path":
+			value, present = pathParams[p.Name]
+		case "query":
+			_, present = query[p.Name]
+			value = query.Get(p.Name)
+		case "header":
+			_, present = header[http.CanonicalHeaderKey(p.Name)]
+			value = header.Get(p.Name)
+		default:
+			continue
+		}
+
+		if !present {
+			if p.Required {
+				problems = append(problems, fmt.Sprintf("missing required %v parameter %q", p.In, p.Name))
+			}
+			continue
+		}
+		if p.schema == nil {
+			continue
+		}
+
+		v, err := convertParameter(p.Schema, value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%v parameter %q: %v", p.In, p.Name, err))
+			continue
+		}
+		for _, e := range p.schema.Validate(v) {
+			problems = append(problems, fmt.Sprintf("%v parameter %q: %v", p.In, p.Name, e.Message))
+		}
+	}
+
+	if op.RequestBody == nil {
+		return problems
+	}
+
+	if len(body) == 0 {
+		if op.RequestBody.Required {
+			problems = append(problems, "missing required request body")
+		}
+		return problems
+	}
+
+	for _, mt := range op.RequestBody.Content {
+		if mt.schema == nil || !isJSON(mt.Type) {
+			continue
+		}
+
+		errs, err := mt.schema.ValidateJSON(body)
+		if err != nil {
+			return append(problems, fmt.Sprintf("request body is not valid JSON: %v", err))
+		}
+		for _, e := range errs {
+			problems = append(problems, "request body: "+e.Error())
+		}
+		break
+	}
+
+	return problems
+}
+
+// convertParameter converts the string value of a parameter to the type of its
+// schema so that it can be validated. Arrays are comma-separated.
+func convertParameter(s interface{}, value string) (interface{}, error) {
+	m, _ := s.(map[string]interface{})
+	t, _ := m["type"].(string)
+
+	switch t {
+	case "integer", "number":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected %v but got %q", t, value)
+		}
+		return f, nil
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("expected boolean but got %q", value)
+		}
+		return b, nil
+	case "array":
+		var items []interface{}
+		for _, part := range strings.Split(value, ",") {
+			item, err := convertParameter(m["items"], part)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return value, nil
+}
+
+func isJSON(mediaType string) bool {
+	mediaType = strings.TrimSpace(strings.SplitN(mediaType, ";", 2)[0])
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// paramName returns the name of the parameter if the segment is one, e.g. {id}.
+func paramName(segment string) (string, bool) {
+	if len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+// distance is the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// fromYAML converts the maps decoded by yaml to those decoded by encoding/json
+// so that specs are handled the same regardless of format.
+func fromYAML(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[fmt.Sprint(k)] = fromYAML(v)
+		}
+		return m
+	case []interface{}:
+		for i := range t {
+			t[i] = fromYAML(t[i])
+		}
+		return t
+	case int:
+		return float64(t)
+	case int64:
+		return float64(t)
+	case uint64:
+		return float64(t)
+	}
+	return v
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
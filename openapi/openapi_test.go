@@ -0,0 +1,148 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openapi
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func loadPetstore(t *testing.T) *Spec {
+	s, err := Load("testdata/petstore.yaml")
+	if err != nil {
+		t.Fatalf("Unable to load spec: %v", err)
+	}
+	return s
+}
+
+func TestLoad(t *testing.T) {
+	s := loadPetstore(t)
+
+	if s.Title != "Petstore" || s.BasePath != "/v1" {
+		t.Errorf("Expected title Petstore and base path /v1, got %q and %q", s.Title, s.BasePath)
+	}
+
+	var templates []string
+	for _, p := range s.Paths {
+		templates = append(templates, p.Template)
+	}
+	expected := []string{"/pets", "/pets/mine", "/pets/{id}", "/pets/{id}/toys"}
+	if !reflect.DeepEqual(templates, expected) {
+		t.Errorf("Expected paths %q, got %q", expected, templates)
+	}
+
+	item, _ := s.Find("/pets/1")
+	if methods := item.Methods(); !reflect.DeepEqual(methods, []string{"GET", "DELETE"}) {
+		t.Errorf("Expected methods GET and DELETE, got %q", methods)
+	}
+	if params := item.Operation("delete").Parameters; len(params) != 2 || params[0].Name != "X-Reason" || params[1].Name != "id" {
+		t.Errorf("Expected operation and path parameters, got %+v", params)
+	}
+}
+
+func TestFind(t *testing.T) {
+	s := loadPetstore(t)
+
+	testCases := []struct {
+		path           string
+		expectTemplate string
+		expectParams   map[string]string
+	}{
+		{path: "/pets", expectTemplate: "/pets", expectParams: map[string]string{}},
+		{path: "/pets/mine", expectTemplate: "/pets/mine", expectParams: map[string]string{}},
+		{path: "/pets/3?x=1", expectTemplate: "/pets/{id}", expectParams: map[string]string{"id": "3"}},
+		{path: "/v1/pets/3/toys", expectTemplate: "/pets/{id}/toys", expectParams: map[string]string{"id": "3"}},
+		{path: "/pet/3"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			item, params := s.Find(tc.path)
+			template := ""
+			if item != nil {
+				template = item.Template
+			}
+			if template != tc.expectTemplate {
+				t.Errorf("Expected template %q, got %q", tc.expectTemplate, template)
+			}
+			if !reflect.DeepEqual(params, tc.expectParams) {
+				t.Errorf("Expected params %v, got %v", tc.expectParams, params)
+			}
+		})
+	}
+}
+
+func TestSuggestAndComplete(t *testing.T) {
+	s := loadPetstore(t)
+
+	if got := s.Suggest("/pet/3", 3); !reflect.DeepEqual(got, []string{"/pets/{id}", "/pets", "/pets/mine"}) {
+		t.Errorf("Unexpected suggestions %q", got)
+	}
+	if got := s.Suggest("/users", 3); got != nil {
+		t.Errorf("Expected no suggestions, got %q", got)
+	}
+
+	testCases := []struct {
+		prefix string
+		expect []string
+	}{
+		{prefix: "/p", expect: []string{"/pets", "/pets/mine", "/pets/{id}", "/pets/{id}/toys"}},
+		{prefix: "/pets/4/", expect: []string{"/pets/4/toys"}},
+		{prefix: "/v1/pets/m", expect: []string{"/v1/pets/mine"}},
+		{prefix: "/u"},
+	}
+	for _, tc := range testCases {
+		if got := s.Complete(tc.prefix); !reflect.DeepEqual(got, tc.expect) {
+			t.Errorf("Expected completions of %q to be %q, got %q", tc.prefix, tc.expect, got)
+		}
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	s := loadPetstore(t)
+
+	testCases := []struct {
+		desc   string
+		method string
+		path   string
+		query  string
+		header http.Header
+		body   string
+		expect []string
+	}{
+		{desc: "valid query", method: "get", path: "/pets", query: "limit=10&tags=a,b"},
+		{desc: "invalid query", method: "get", path: "/pets", query: "limit=101", expect: []string{`query parameter "limit": 101 is greater than maximum 100`}},
+		{desc: "wrong query type", method: "get", path: "/pets", query: "limit=ten", expect: []string{`query parameter "limit": expected integer but got "ten"`}},
+		{desc: "invalid path parameter", method: "get", path: "/pets/0", expect: []string{`path parameter "id": 0 is less than minimum 1`}},
+		{desc: "missing header", method: "delete", path: "/pets/1", expect: []string{`missing required header parameter "X-Reason"`}},
+		{desc: "header", method: "delete", path: "/pets/1", header: http.Header{"X-Reason": {"gone"}}},
+		{desc: "missing body", method: "post", path: "/pets", expect: []string{"missing required request body"}},
+		{desc: "invalid body", method: "post", path: "/pets", body: `{"id":"1","name":""}`, expect: []string{"request body: /id: expected type integer but got string", "request body: /name: length 0 is less than minLength 1"}},
+		{desc: "valid body", method: "post", path: "/pets", body: `{"name":"rex"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			item, params := s.Find(tc.path)
+			query, _ := url.ParseQuery(tc.query)
+			got := item.Operation(tc.method).ValidateRequest(params, query, tc.header, []byte(tc.body))
+			if !reflect.DeepEqual(got, tc.expect) {
+				t.Errorf("Expected problems %q, got %q", tc.expect, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,598 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The schema package implements validation of JSON values against JSON Schema
+// documents loaded from local files. Nothing is ever fetched over the network.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidationError describes a single way in which a value does not match the
+// schema. Pointer is the JSON pointer to the offending part of the value.
+type ValidationError struct {
+	Pointer string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	pointer := e.Pointer
+	if pointer == "" {
+		pointer = "/"
+	}
+	return fmt.Sprintf("%v: %v", pointer, e.Message)
+}
+
+// Draft versions of JSON Schema which are supported. They only differ in how
+// arrays of items are handled.
+const (
+	Draft7      = "draft-07"
+	Draft202012 = "2020-12"
+)
+
+// Schema is a parsed JSON Schema document.
+type Schema struct {
+	doc   *document
+	draft string
+
+	// node is the schema within doc which values are validated against; the
+	// root of doc unless the schema is embedded in another document.
+	node interface{}
+
+	// docs caches the documents loaded via $ref, by absolute path.
+	docs map[string]*document
+}
+
+// document is a single schema file. References without a file are resolved
+// within the document they are found in.
+type document struct {
+	root interface{}
+	path string
+}
+
+// Load reads and parses the schema at the given path. References to other files
+// are resolved relative to it.
+func Load(path string) (*Schema, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := loadDocument(abs)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newSchema(doc)
+	s.docs[abs] = doc
+	return s, nil
+}
+
+// Parse parses the given JSON Schema document. Only references within the
+// document are supported.
+func Parse(b []byte) (*Schema, error) {
+	root, err := parseDocument(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSchema(&document{root: root}), nil
+}
+
+// FromDocument returns the schema at node within root, an already decoded
+// document which embeds schemas such as an OpenAPI spec. References are resolved
+// within root and, for other files, relative to path if it is given.
+func FromDocument(root, node interface{}, path string) *Schema {
+	s := newSchema(&document{root: root, path: path})
+	s.node = node
+	if path != "" {
+		s.docs[path] = s.doc
+	}
+	return s
+}
+
+func newSchema(doc *document) *Schema {
+	s := &Schema{doc: doc, draft: Draft202012, node: doc.root, docs: map[string]*document{}}
+	if m, ok := doc.root.(map[string]interface{}); ok {
+		if uri, ok := m["$schema"].(string); ok && strings.Contains(uri, "draft-07") {
+			s.draft = Draft7
+		}
+	}
+	return s
+}
+
+func loadDocument(path string) (*document, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := parseDocument(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema %v: %v", path, err)
+	}
+	return &document{root: root, path: path}, nil
+}
+
+func parseDocument(b []byte) (interface{}, error) {
+	var root interface{}
+	if err := json.Unmarshal(b, &root); err != nil {
+		return nil, err
+	}
+
+	switch root.(type) {
+	case map[string]interface{}, bool:
+	default:
+		return nil, fmt.Errorf("schema must be an object or boolean, got %T", root)
+	}
+
+	return root, nil
+}
+
+// Draft returns the version of JSON Schema the document is interpreted as. It
+// is taken from $schema and defaults to 2020-12.
+func (s *Schema) Draft() string {
+	return s.draft
+}
+
+// Validate checks the value, as decoded by encoding/json, against the schema
+// and returns all of the errors found.
+func (s *Schema) Validate(v interface{}) []ValidationError {
+	var errs []ValidationError
+	s.validate(s.doc, s.node, v, "", &errs)
+	return errs
+}
+
+// ValidateJSON is like Validate but first decodes the given JSON.
+func (s *Schema) ValidateJSON(b []byte) ([]ValidationError, error) {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return s.Validate(v), nil
+}
+
+func (s *Schema) validate(doc *document, node, v interface{}, pointer string, errs *[]ValidationError) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, ValidationError{Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch n := node.(type) {
+	case bool:
+		if !n {
+			fail("no value is allowed here")
+		}
+		return
+	case map[string]interface{}:
+		s.validateObject(doc, n, v, pointer, fail, errs)
+	}
+}
+
+func (s *Schema) validateObject(doc *document, n map[string]interface{}, v interface{}, pointer string, fail func(string, ...interface{}), errs *[]ValidationError) {
+	if ref, ok := n["$ref"].(string); ok {
+		targetDoc, target, err := s.resolve(doc, ref)
+		if err != nil {
+			fail("%v", err)
+			return
+		}
+		s.validate(targetDoc, target, v, pointer, errs)
+	}
+
+	if t, ok := n["type"]; ok && !matchesType(t, v) {
+		fail("expected type %v but got %v", formatType(t), typeOf(v))
+		return
+	}
+
+	if enum, ok := n["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("value %v is not one of %v", jsonString(v), jsonString(enum))
+		}
+	}
+
+	if c, ok := n["const"]; ok && !reflect.DeepEqual(c, v) {
+		fail("value %v does not equal %v", jsonString(v), jsonString(c))
+	}
+
+	switch val := v.(type) {
+	case string:
+		validateString(n, val, fail)
+	case float64:
+		validateNumber(n, val, fail)
+	case []interface{}:
+		s.validateArray(doc, n, val, pointer, fail, errs)
+	case map[string]interface{}:
+		s.validateProperties(doc, n, val, pointer, fail, errs)
+	}
+
+	s.validateCombinators(doc, n, v, pointer, fail, errs)
+}
+
+func validateString(n map[string]interface{}, v string, fail func(string, ...interface{})) {
+	length := float64(utf8.RuneCountInString(v))
+	if min, ok := n["minLength"].(float64); ok && length < min {
+		fail("length %v is less than minLength %v", length, min)
+	}
+	if max, ok := n["maxLength"].(float64); ok && length > max {
+		fail("length %v is greater than maxLength %v", length, max)
+	}
+	if pattern, ok := n["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		switch {
+		case err != nil:
+			fail("invalid pattern %q: %v", pattern, err)
+		case !re.MatchString(v):
+			fail("%q does not match pattern %q", v, pattern)
+		}
+	}
+}
+
+func validateNumber(n map[string]interface{}, v float64, fail func(string, ...interface{})) {
+	if min, ok := n["minimum"].(float64); ok && v < min {
+		fail("%v is less than minimum %v", v, min)
+	}
+	if max, ok := n["maximum"].(float64); ok && v > max {
+		fail("%v is greater than maximum %v", v, max)
+	}
+	if min, ok := n["exclusiveMinimum"].(float64); ok && v <= min {
+		fail("%v is not greater than exclusiveMinimum %v", v, min)
+	}
+	if max, ok := n["exclusiveMaximum"].(float64); ok && v >= max {
+		fail("%v is not less than exclusiveMaximum %v", v, max)
+	}
+	if m, ok := n["multipleOf"].(float64); ok && m > 0 {
+		if q := v / m; math.Abs(q-math.Round(q)) > 1e-9 {
+			fail("%v is not a multiple of %v", v, m)
+		}
+	}
+}
+
+func (s *Schema) validateArray(doc *document, n map[string]interface{}, v []interface{}, pointer string, fail func(string, ...interface{}), errs *[]ValidationError) {
+	if min, ok := n["minItems"].(float64); ok && float64(len(v)) < min {
+		fail("array has %v items, fewer than minItems %v", len(v), min)
+	}
+	if max, ok := n["maxItems"].(float64); ok && float64(len(v)) > max {
+		fail("array has %v items, more than maxItems %v", len(v), max)
+	}
+	if unique, ok := n["uniqueItems"].(bool); ok && unique {
+		for i := range v {
+			for j := i + 1; j < len(v); j++ {
+				if reflect.DeepEqual(v[i], v[j]) {
+					fail("items %v and %v are equal but uniqueItems is set", i, j)
+				}
+			}
+		}
+	}
+
+	// Tuples are given via prefixItems in 2020-12 and by an array of schemas
+	// for items in draft 7. Any remaining items are checked against items in
+	// 2020-12 or additionalItems in draft 7.
+	var tuple []interface{}
+	rest, hasRest := n["items"]
+	if prefix, ok := n["prefixItems"].([]interface{}); ok && s.draft == Draft202012 {
+		tuple = prefix
+	} else if items, ok := n["items"].([]interface{}); ok {
+		tuple = items
+		rest, hasRest = n["additionalItems"]
+	}
+
+	for i := 0; i < len(tuple) && i < len(v); i++ {
+		s.validate(doc, tuple[i], v[i], pointer+"/"+strconv.Itoa(i), errs)
+	}
+	if hasRest {
+		for i := len(tuple); i < len(v); i++ {
+			s.validate(doc, rest, v[i], pointer+"/"+strconv.Itoa(i), errs)
+		}
+	}
+
+	if contains, ok := n["contains"]; ok {
+		found := false
+		for _, item := range v {
+			var itemErrs []ValidationError
+			s.validate(doc, contains, item, pointer, &itemErrs)
+			if len(itemErrs) == 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("no items match the contains schema")
+		}
+	}
+}
+
+func (s *Schema) validateProperties(doc *document, n map[string]interface{}, v map[string]interface{}, pointer string, fail func(string, ...interface{}), errs *[]ValidationError) {
+	if min, ok := n["minProperties"].(float64); ok && float64(len(v)) < min {
+		fail("object has %v properties, fewer than minProperties %v", len(v), min)
+	}
+	if max, ok := n["maxProperties"].(float64); ok && float64(len(v)) > max {
+		fail("object has %v properties, more than maxProperties %v", len(v), max)
+	}
+
+	if required, ok := n["required"].([]interface{}); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				if _, ok := v[name]; !ok {
+					fail("missing required property %q", name)
+				}
+			}
+		}
+	}
+
+	// dependencies is the draft 7 form of dependentRequired and
+	// dependentSchemas.
+	for _, keyword := range []string{"dependentRequired", "dependentSchemas", "dependencies"} {
+		deps, _ := n[keyword].(map[string]interface{})
+		for _, name := range sortedKeys(deps) {
+			if _, ok := v[name]; !ok {
+				continue
+			}
+			switch dep := deps[name].(type) {
+			case []interface{}:
+				for _, r := range dep {
+					if required, ok := r.(string); ok {
+						if _, ok := v[required]; !ok {
+							fail("property %q is required when %q is present", required, name)
+						}
+					}
+				}
+			default:
+				s.validate(doc, dep, v, pointer, errs)
+			}
+		}
+	}
+
+	props, _ := n["properties"].(map[string]interface{})
+	patterns, _ := n["patternProperties"].(map[string]interface{})
+	additional, hasAdditional := n["additionalProperties"]
+
+	// Sort so that errors are reported in a consistent order.
+	for _, k := range sortedKeys(v) {
+		childPointer := pointer + "/" + escapePointer(k)
+		matched := false
+
+		if prop, ok := props[k]; ok {
+			matched = true
+			s.validate(doc, prop, v[k], childPointer, errs)
+		}
+
+		for _, pattern := range sortedKeys(patterns) {
+			prop := patterns[pattern]
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				fail("invalid pattern %q: %v", pattern, err)
+				continue
+			}
+			if re.MatchString(k) {
+				matched = true
+				s.validate(doc, prop, v[k], childPointer, errs)
+			}
+		}
+
+		if !matched && hasAdditional {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				*errs = append(*errs, ValidationError{Pointer: childPointer, Message: "additional property is not allowed"})
+				continue
+			}
+			s.validate(doc, additional, v[k], childPointer, errs)
+		}
+	}
+}
+
+func (s *Schema) validateCombinators(doc *document, n map[string]interface{}, v interface{}, pointer string, fail func(string, ...interface{}), errs *[]ValidationError) {
+	if all, ok := n["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			s.validate(doc, sub, v, pointer, errs)
+		}
+	}
+
+	if any, ok := n["anyOf"].([]interface{}); ok {
+		if s.countMatches(doc, any, v, pointer) == 0 {
+			fail("value does not match any of the anyOf schemas")
+		}
+	}
+
+	if one, ok := n["oneOf"].([]interface{}); ok {
+		if matches := s.countMatches(doc, one, v, pointer); matches != 1 {
+			fail("value matches %v of the oneOf schemas, expected exactly 1", matches)
+		}
+	}
+
+	if not, ok := n["not"]; ok {
+		if s.countMatches(doc, []interface{}{not}, v, pointer) == 1 {
+			fail("value must not match the not schema")
+		}
+	}
+
+	if cond, ok := n["if"]; ok {
+		if s.countMatches(doc, []interface{}{cond}, v, pointer) == 1 {
+			if then, ok := n["then"]; ok {
+				s.validate(doc, then, v, pointer, errs)
+			}
+		} else if els, ok := n["else"]; ok {
+			s.validate(doc, els, v, pointer, errs)
+		}
+	}
+}
+
+// countMatches returns how many of the schemas the value is valid against.
+func (s *Schema) countMatches(doc *document, schemas []interface{}, v interface{}, pointer string) int {
+	matches := 0
+	for _, sub := range schemas {
+		var subErrs []ValidationError
+		s.validate(doc, sub, v, pointer, &subErrs)
+		if len(subErrs) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+// resolve finds the schema referenced by ref along with the document it is in.
+// References may be to a fragment of the current document (#/$defs/id) or to
+// another local file, relative to the current one (common.json#/$defs/id).
+func (s *Schema) resolve(doc *document, ref string) (*document, interface{}, error) {
+	parts := strings.SplitN(ref, "#", 2)
+	file, fragment := parts[0], ""
+	if len(parts) == 2 {
+		fragment = parts[1]
+	}
+
+	if file != "" {
+		if strings.Contains(file, "://") {
+			return nil, nil, fmt.Errorf("unsupported $ref %q; only local files are supported", ref)
+		}
+		if doc.path == "" {
+			return nil, nil, fmt.Errorf("unsupported $ref %q; schema was not loaded from a file", ref)
+		}
+
+		path := file
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(doc.path), file)
+		}
+
+		target, ok := s.docs[path]
+		if !ok {
+			var err error
+			target, err = loadDocument(path)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to resolve $ref %q: %v", ref, err)
+			}
+			s.docs[path] = target
+		}
+		doc = target
+	}
+
+	node := doc.root
+	if fragment == "" {
+		return doc, node, nil
+	}
+
+	for _, part := range strings.Split(strings.TrimPrefix(fragment, "/"), "/") {
+		part = unescapePointer(part)
+		switch n := node.(type) {
+		case map[string]interface{}:
+			next, ok := n[part]
+			if !ok {
+				return nil, nil, fmt.Errorf("unable to resolve $ref %q", ref)
+			}
+			node = next
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(n) {
+				return nil, nil, fmt.Errorf("unable to resolve $ref %q", ref)
+			}
+			node = n[i]
+		default:
+			return nil, nil, fmt.Errorf("unable to resolve $ref %q", ref)
+		}
+	}
+
+	return doc, node, nil
+}
+
+func matchesType(t interface{}, v interface{}) bool {
+	switch tt := t.(type) {
+	case string:
+		return matchesSingleType(tt, v)
+	case []interface{}:
+		for _, single := range tt {
+			if s, ok := single.(string); ok && matchesSingleType(s, v) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesSingleType(t string, v interface{}) bool {
+	switch t {
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	default:
+		return typeOf(v) == t
+	}
+}
+
+func typeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func formatType(t interface{}) string {
+	if s, ok := t.(string); ok {
+		return s
+	}
+	return jsonString(t)
+}
+
+func jsonString(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func escapePointer(s string) string {
+	return strings.Replace(strings.Replace(s, "~", "~0", -1), "/", "~1", -1)
+}
+
+func unescapePointer(s string) string {
+	return strings.Replace(strings.Replace(s, "~1", "/", -1), "~0", "~", -1)
+}
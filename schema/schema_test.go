@@ -0,0 +1,140 @@
+// Copyright © 2017 John Schnake <schnake.john@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		schema    string
+		value     string
+		expectErr []string
+	}{
+		{
+			desc:   "valid object",
+			schema: `{"type":"object","required":["id"],"properties":{"id":{"type":"integer"},"name":{"type":"string","minLength":1}}}`,
+			value:  `{"id":1,"name":"a"}`,
+		}, {
+			desc:      "wrong type",
+			schema:    `{"type":"object"}`,
+			value:     `[]`,
+			expectErr: []string{"/: expected type object but got array"},
+		}, {
+			desc:      "nested errors have pointers",
+			schema:    `{"properties":{"items":{"type":"array","items":{"properties":{"id":{"type":"integer"}}}}}}`,
+			value:     `{"items":[{"id":1},{"id":"x"},{"id":1.5}]}`,
+			expectErr: []string{"/items/1/id: expected type integer but got string", "/items/2/id: expected type integer but got number"},
+		}, {
+			desc:      "required and additional properties",
+			schema:    `{"required":["id"],"properties":{"a":{}},"additionalProperties":false}`,
+			value:     `{"a":1,"b/c":2}`,
+			expectErr: []string{`/: missing required property "id"`, "/b~1c: additional property is not allowed"},
+		}, {
+			desc:      "enum and const",
+			schema:    `{"properties":{"status":{"enum":["done","running"]},"v":{"const":2}}}`,
+			value:     `{"status":"failed","v":3}`,
+			expectErr: []string{`/status: value "failed" is not one of ["done","running"]`, "/v: value 3 does not equal 2"},
+		}, {
+			desc:      "numbers and strings",
+			schema:    `{"properties":{"n":{"minimum":1,"exclusiveMaximum":10,"multipleOf":2},"s":{"pattern":"^a","maxLength":2}}}`,
+			value:     `{"n":10,"s":"bcd"}`,
+			expectErr: []string{"/n: 10 is not less than exclusiveMaximum 10", `/s: length 3 is greater than maxLength 2`, `/s: "bcd" does not match pattern "^a"`},
+		}, {
+			desc:      "refs and combinators",
+			schema:    `{"definitions":{"id":{"type":"string"}},"properties":{"a":{"$ref":"#/definitions/id"},"b":{"oneOf":[{"type":"string"},{"minLength":1}]},"c":{"not":{"type":"null"}}}}`,
+			value:     `{"a":1,"b":"x","c":null}`,
+			expectErr: []string{"/a: expected type string but got number", "/b: value matches 2 of the oneOf schemas, expected exactly 1", "/c: value must not match the not schema"},
+		}, {
+			desc:      "tuple items",
+			schema:    `{"items":[{"type":"string"},{"type":"number"}],"additionalItems":false}`,
+			value:     `["a",1,true]`,
+			expectErr: []string{"/2: no value is allowed here"},
+		}, {
+			desc:      "prefixItems in 2020-12",
+			schema:    `{"$schema":"https://json-schema.org/draft/2020-12/schema","prefixItems":[{"type":"string"}],"items":{"type":"number"}}`,
+			value:     `["a",1,"b"]`,
+			expectErr: []string{"/2: expected type number but got string"},
+		}, {
+			desc:   "prefixItems ignored in draft 7",
+			schema: `{"$schema":"http://json-schema.org/draft-07/schema#","prefixItems":[{"type":"number"}]}`,
+			value:  `["a"]`,
+		}, {
+			desc:      "dependentRequired and dependencies",
+			schema:    `{"dependentRequired":{"a":["b"]},"dependencies":{"c":{"required":["d"]}}}`,
+			value:     `{"a":1,"c":2}`,
+			expectErr: []string{`/: property "b" is required when "a" is present`, `/: missing required property "d"`},
+		}, {
+			desc:      "$defs refs",
+			schema:    `{"$defs":{"id":{"type":"string"}},"items":{"$ref":"#/$defs/id"}}`,
+			value:     `["a",2]`,
+			expectErr: []string{"/1: expected type string but got number"},
+		}, {
+			desc:      "if then else",
+			schema:    `{"if":{"properties":{"kind":{"const":"a"}}},"then":{"required":["a"]},"else":{"required":["b"]}}`,
+			value:     `{"kind":"c"}`,
+			expectErr: []string{`/: missing required property "b"`},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			s, err := Parse([]byte(tc.schema))
+			if err != nil {
+				t.Fatalf("Unable to parse schema: %v", err)
+			}
+
+			errs, err := s.ValidateJSON([]byte(tc.value))
+			if err != nil {
+				t.Fatalf("Unable to parse value: %v", err)
+			}
+
+			var got []string
+			for _, e := range errs {
+				got = append(got, e.Error())
+			}
+			if !reflect.DeepEqual(got, tc.expectErr) {
+				t.Errorf("Expected errors %q, got %q", tc.expectErr, got)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	s, err := Load("testdata/post.json")
+	if err != nil {
+		t.Fatalf("Unable to load schema: %v", err)
+	}
+	if s.Draft() != Draft202012 {
+		t.Errorf("Expected draft %v, got %v", Draft202012, s.Draft())
+	}
+
+	errs, err := s.ValidateJSON([]byte(`{"id":0,"author":{"id":"x"}}`))
+	if err != nil {
+		t.Fatalf("Unable to parse value: %v", err)
+	}
+
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Error())
+	}
+	expected := []string{"/author/id: expected type integer but got string", "/id: 0 is less than minimum 1"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected errors %q, got %q", expected, got)
+	}
+}
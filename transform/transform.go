@@ -30,17 +30,29 @@ import (
 
 var truncationLength = 512
 
+// deferredPrefixes are the prefixes of references which are not from the piped
+// data and are left as-is to be resolved when the request is built (e.g.
+// ${session.token}).
+var deferredPrefixes = []string{"session."}
+
 // InputToCommands reads from the given io.Reader (e.g. os.Stdin) and uses the
 // data there to replace values like $1.uuid in the args. It returns a
 // [][]string which is a set of rows, each with a slice of string values.
 func InputToCommands(r io.Reader, args []string, explodeArrays bool) ([][]string, error) {
-	data, err := readData(r, explodeArrays)
+	data, err := ReadData(r, explodeArrays)
 	if err != nil {
 		return nil, err
 	}
 
+	return DataToCommands(data, args), nil
+}
+
+// DataToCommands uses each row of data (as returned by ReadData) to replace
+// values like $1.uuid in the args. If there is no data, the args are returned
+// unmodified as the only row.
+func DataToCommands(data [][]string, args []string) [][]string {
 	if len(data) == 0 {
-		return [][]string{args}, nil
+		return [][]string{args}
 	}
 
 	cmds := make([][]string, len(data))
@@ -51,16 +63,30 @@ func InputToCommands(r io.Reader, args []string, explodeArrays bool) ([][]string
 		}
 	}
 
-	return cmds, nil
+	return cmds
 }
 
-// readData reads all data from the given reader and splits it into a
+// ReadData reads all data from the given reader and splits it into a
 // [][]string: a slice of commands, each command having multiple positional
 // arguments. If explodeArrays is true, arrays are treated as if they were
 // simply given as a list of newline separated JSON objects.
-func readData(r io.Reader, explodeArrays bool) ([][]string, error) {
+func ReadData(r io.Reader, explodeArrays bool) ([][]string, error) {
 	var data [][]string
+	err := ReadRows(r, explodeArrays, func(row []string) error {
+		data = append(data, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
 
+// ReadRows reads data from the given reader in the same way as ReadData but
+// calls fn with each row as soon as it has been read, so that rows can be
+// handled while more are still arriving. An error from fn stops the reading
+// and is returned as-is.
+func ReadRows(r io.Reader, explodeArrays bool, fn func(row []string) error) error {
 ProcessLoop:
 	dec := json.NewDecoder(r)
 	for dec.More() {
@@ -75,7 +101,7 @@ ProcessLoop:
 			case *json.SyntaxError:
 				// Allow parsing as a string.
 			default:
-				return nil, err
+				return err
 			}
 		}
 
@@ -85,37 +111,45 @@ ProcessLoop:
 				for _, obj := range raw {
 					jsonObj, ok := obj.(map[string]interface{})
 					if !ok {
-						return nil, errors.New("invalid piped data")
+						return errors.New("invalid piped data")
 					}
 
 					b, err := json.Marshal(jsonObj)
 					if err != nil {
-						return nil, err
+						return err
 					}
 					// Every value gets appened in its own row.
-					data = append(data, []string{string(b)})
+					if err := fn([]string{string(b)}); err != nil {
+						return err
+					}
 				}
 			} else {
 				b, err := json.Marshal(raw)
 				if err != nil {
-					return nil, err
+					return err
+				}
+				if err := fn([]string{string(b)}); err != nil {
+					return err
 				}
-				data = append(data, []string{string(b)})
 			}
 		case map[string]interface{}:
 			b, err := json.Marshal(raw)
 			if err != nil {
-				return nil, err
+				return err
 			}
 			// Every value gets appened in its own row.
-			data = append(data, []string{string(b)})
+			if err := fn([]string{string(b)}); err != nil {
+				return err
+			}
 		case nil:
 			// Failed to parse as JSON; parse as a word.
 			subR := dec.Buffered()
 			scanner := bufio.NewScanner(subR)
 			scanner.Split(bufio.ScanWords)
 			for scanner.Scan() {
-				data = append(data, []string{scanner.Text()})
+				if err := fn([]string{scanner.Text()}); err != nil {
+					return err
+				}
 			}
 			if err := scanner.Err(); err != nil {
 				log.Fatalf("reading standard input: %v", err)
@@ -127,11 +161,11 @@ ProcessLoop:
 			r = io.MultiReader(subR, r)
 			goto ProcessLoop
 		default:
-			return nil, fmt.Errorf("unexpected type (%T): %v", raw, truncatedValue(raw))
+			return fmt.Errorf("unexpected type (%T): %v", raw, truncatedValue(raw))
 		}
 	}
 
-	return data, nil
+	return nil
 }
 
 // transform uses the data to transform the argument (e.g. foo $1.uuid -> foo
@@ -144,6 +178,12 @@ func transform(data []string, arg string) string {
 // and returns their values based on the data passed to the generator.
 func dataLookup(data []string) func(string) string {
 	return func(s string) string {
+		for _, prefix := range deferredPrefixes {
+			if strings.HasPrefix(s, prefix) {
+				return "${" + s + "}"
+			}
+		}
+
 		pos, jsonKeyQuery := parseTransform(s)
 		if pos > len(data) || pos < 0 {
 			return "<nil>"
@@ -19,6 +19,7 @@ package transform
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"reflect"
@@ -106,6 +107,13 @@ func TestInputToCommands(t *testing.T) {
 				[]string{"a b <nil> <nil>"},
 				[]string{"a b <nil> <nil>"},
 			},
+		}, {
+			desc: "Session references are left as-is",
+			r:    bytes.NewBufferString(`{"c":"d"}`),
+			args: []string{"a ${c} ${session.token}"},
+			expectCmds: [][]string{
+				[]string{"a d ${session.token}"},
+			},
 		}, {
 			desc: "Multiline JSON",
 			r: bytes.NewBufferString(`{
@@ -164,6 +172,35 @@ func TestInputToCommands(t *testing.T) {
 	}
 }
 
+func TestReadRows(t *testing.T) {
+	r, w := io.Pipe()
+	rows := make(chan string)
+	done := make(chan error)
+	go func() {
+		done <- ReadRows(r, true, func(row []string) error {
+			rows <- row[0]
+			if row[0] == "stop" {
+				return errors.New("stopped")
+			}
+			return nil
+		})
+	}()
+
+	// Each row is handled before the next one is written.
+	for _, expected := range []string{`{"a":1}`, `{"b":2}`} {
+		go fmt.Fprintln(w, expected)
+		if row := <-rows; row != expected {
+			t.Errorf("Expected row %q got %q", expected, row)
+		}
+	}
+
+	go fmt.Fprintln(w, "stop")
+	<-rows
+	if err := <-done; err == nil || err.Error() != "stopped" {
+		t.Errorf("Expected the error from the row to be returned, got %v", err)
+	}
+}
+
 func TestTruncatedValue(t *testing.T) {
 	testCases := []struct {
 		desc     string